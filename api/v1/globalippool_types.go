@@ -0,0 +1,105 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GlobalIPPoolSpec defines the desired state of GlobalIPPool
+type GlobalIPPoolSpec struct {
+	// CIDR is the range the pool hands globalnet CIDRs out of, e.g. 242.0.0.0/8
+	CIDR string `json:"cidr"`
+	// BlockSize is the prefix length of the globalnet CIDR handed to each owner
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=30
+	// +kubebuilder:default=24
+	// +optional
+	BlockSize int `json:"blockSize,omitempty"`
+	// Scope selects the owner of a globalnet CIDR: Vpc gives every kube-ovn VPC a CIDR of its own, Cluster shares
+	// one among all tunnels of the cluster
+	// +kubebuilder:validation:Enum=Vpc;Cluster
+	// +kubebuilder:default=Vpc
+	// +optional
+	Scope string `json:"scope,omitempty"`
+	// EgressIPs is the number of consecutive addresses each tunnel gets out of the globalnet CIDR of its owner
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=256
+	// +kubebuilder:default=8
+	// +optional
+	EgressIPs int `json:"egressIPs,omitempty"`
+}
+
+const (
+	// PoolScopeVpc allocates a globalnet CIDR per kube-ovn VPC, the default
+	PoolScopeVpc = "Vpc"
+	// PoolScopeCluster allocates a single globalnet CIDR for the cluster
+	PoolScopeCluster = "Cluster"
+)
+
+// GlobalIPBlock is a globalnet CIDR allocated to an owner, "vpc/<name>" or "cluster"
+type GlobalIPBlock struct {
+	Owner string `json:"owner"`
+	CIDR  string `json:"cidr"`
+}
+
+// GlobalEgressRange is the egress IP range allocated to a tunnel, out of the block of its owner
+type GlobalEgressRange struct {
+	// Tunnel is the namespace/name of the VpcNatTunnel
+	Tunnel string `json:"tunnel"`
+	Owner  string `json:"owner"`
+	// First and Last are the first and last address of the range
+	First string `json:"first"`
+	Last  string `json:"last"`
+}
+
+// GlobalIPPoolStatus records the allocations of the pool, they survive operator restarts
+type GlobalIPPoolStatus struct {
+	// +optional
+	Blocks []GlobalIPBlock `json:"blocks,omitempty"`
+	// +optional
+	EgressRanges []GlobalEgressRange `json:"egressRanges,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="CIDR",type=string,JSONPath=`.spec.cidr`
+//+kubebuilder:printcolumn:name="Scope",type=string,JSONPath=`.spec.scope`
+
+// GlobalIPPool is the Schema for the globalippools API. Tunnels naming it in spec.globalIPPool take their globalnet
+// CIDR and egress IPs from it instead of Submariner.
+type GlobalIPPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   GlobalIPPoolSpec   `json:"spec,omitempty"`
+	Status GlobalIPPoolStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// GlobalIPPoolList contains a list of GlobalIPPool
+type GlobalIPPoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []GlobalIPPool `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&GlobalIPPool{}, &GlobalIPPoolList{})
+}
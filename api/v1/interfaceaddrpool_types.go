@@ -0,0 +1,74 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// InterfaceAddrPoolSpec defines the desired state of InterfaceAddrPool
+type InterfaceAddrPoolSpec struct {
+	// CIDR is the range the point-to-point blocks of tunnel interfaces are cut from, e.g. 10.100.0.0/16
+	CIDR string `json:"cidr"`
+	// PrefixLength of the block of a tunnel, /30 or /31
+	// +kubebuilder:validation:Enum=30;31
+	// +kubebuilder:default=30
+	// +optional
+	PrefixLength int `json:"prefixLength,omitempty"`
+}
+
+// InterfaceAddrAllocation is the block allocated to a tunnel
+type InterfaceAddrAllocation struct {
+	// Tunnel is the namespace/name of the VpcNatTunnel
+	Tunnel string `json:"tunnel"`
+	CIDR   string `json:"cidr"`
+}
+
+// InterfaceAddrPoolStatus records the allocations of the pool, they survive operator restarts
+type InterfaceAddrPoolStatus struct {
+	// +optional
+	Allocations []InterfaceAddrAllocation `json:"allocations,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="CIDR",type=string,JSONPath=`.spec.cidr`
+//+kubebuilder:printcolumn:name="PrefixLength",type=integer,JSONPath=`.spec.prefixLength`
+
+// InterfaceAddrPool is the Schema for the interfaceaddrpools API. Tunnels naming it in spec.interfaceAddrPool get
+// their interface address from it instead of setting it by hand.
+type InterfaceAddrPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   InterfaceAddrPoolSpec   `json:"spec,omitempty"`
+	Status InterfaceAddrPoolStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// InterfaceAddrPoolList contains a list of InterfaceAddrPool
+type InterfaceAddrPoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []InterfaceAddrPool `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&InterfaceAddrPool{}, &InterfaceAddrPoolList{})
+}
@@ -30,32 +30,218 @@ type VpcNatTunnelSpec struct {
 
 	// Foo is an example field of VpcNatTunnel. Edit vpcnattunnel_types.go to remove/update
 	// InternalIP    string `json:"internalIp"`
-	RemoteIP      string `json:"remoteIp"`
+	// RemoteIP is the external address of the peer vpc-nat-gw
+	RemoteIP string `json:"remoteIp"`
+	// InterfaceAddr is the address of the tunnel interface in CIDR notation, left empty when InterfaceAddrPool is set
+	// +optional
 	InterfaceAddr string `json:"interfaceAddr"`
 	NatGwDp       string `json:"natGwDp"`
 	// +kubebuilder:default="gre"
 	Type string `json:"type"`
 
-	RemoteGlobalnetCIDR string `json:"remoteGlobalnetCIDR"`
+	// RemoteGlobalnetCIDR is the globalnet CIDR of the peer cluster. It is routed through the tunnel along with
+	// RemoteCIDRs, at least one of the two has to be set.
+	// +optional
+	RemoteGlobalnetCIDR string `json:"remoteGlobalnetCIDR,omitempty"`
+	// RemoteClusterID names the Submariner cluster of the peer, instead of setting RemoteGlobalnetCIDR. Its globalnet
+	// CIDR is taken from its broker-synced Endpoint and Cluster and kept up to date in
+	// status.resolvedRemoteGlobalnetCIDR by the controller. The Endpoint addresses belong to the Submariner gateway
+	// node rather than the peer vpc-nat-gw, RemoteIP stays explicit.
+	// +optional
+	RemoteClusterID string `json:"remoteClusterID,omitempty"`
+	// RemoteCIDRs are further prefixes of the peer cluster routed through the tunnel, e.g. its service CIDR
+	// +optional
+	RemoteCIDRs []string `json:"remoteCIDRs,omitempty"`
+
+	// SourceCIDRs limit the tunnel to traffic from these VPC subnets. Together with FwMark they let tunnels on one
+	// gateway reach overlapping remote CIDRs.
+	// +optional
+	SourceCIDRs []string `json:"sourceCIDRs,omitempty"`
+	// FwMark limits the tunnel to traffic carrying this firewall mark
+	// +optional
+	FwMark uint32 `json:"fwMark,omitempty"`
+
+	// Vpc is the kube-ovn VPC the tunnel belongs to, it must be the VPC of the NatGwDp gateway when set
+	// +optional
+	Vpc string `json:"vpc,omitempty"`
+
+	// Mode selects how local addresses are presented to the peer: globalnet SNATs them to the Submariner
+	// ClusterGlobalEgressIPs, subnet routes the VPC subnets as they are, overlay maps them 1:1 to OverlayMappings
+	// +kubebuilder:validation:Enum=globalnet;subnet;overlay
+	// +kubebuilder:default=globalnet
+	// +optional
+	Mode string `json:"mode,omitempty"`
+	// OverlayMappings are the VPC subnets reachable through the tunnel in overlay mode and the ranges the peer
+	// knows them as
+	// +optional
+	OverlayMappings []OverlayMapping `json:"overlayMappings,omitempty"`
+	// InterfaceAddrPool names an InterfaceAddrPool the tunnel takes a point-to-point block from. The first address
+	// is recorded in status.allocatedInterfaceAddr and used as its interface address, the second one in
+	// status.remoteInterfaceAddr for the peer, which sets it as its InterfaceAddr. Only one end names a pool.
+	// +optional
+	InterfaceAddrPool string `json:"interfaceAddrPool,omitempty"`
+	// GlobalIPPool names a GlobalIPPool the globalnet CIDR and egress IPs of the tunnel are allocated from in
+	// globalnet mode, instead of reading them from Submariner
+	// +optional
+	GlobalIPPool string `json:"globalIPPool,omitempty"`
+	// EgressIPs are the SNAT sources of the tunnel in globalnet mode, in place of the cluster-wide or pool ones.
+	// They let the peer tell the traffic of this tunnel apart.
+	// +optional
+	EgressIPs []string `json:"egressIPs,omitempty"`
+	// GlobalEgressIP names a Submariner GlobalEgressIP in the namespace of the tunnel whose allocated IPs are the
+	// SNAT sources in globalnet mode. It cannot be set together with EgressIPs.
+	// +optional
+	GlobalEgressIP string `json:"globalEgressIP,omitempty"`
+
+	// Ingress exposes VPC-internal services and pods to the peer: connections coming in through the tunnel to a
+	// global IP are DNATed to the internal IP
+	// +optional
+	Ingress []IngressRule `json:"ingress,omitempty"`
+}
+
+// IngressRule maps a global IP, or one port of it, to a VPC-internal IP
+type IngressRule struct {
+	// GlobalIP is the address the peer connects to. It can be left out if GlobalIngressIP is set.
+	// +optional
+	GlobalIP string `json:"globalIP,omitempty"`
+	// GlobalIngressIP names a Submariner GlobalIngressIP in the namespace of the tunnel whose allocated IP is used
+	// as GlobalIP
+	// +optional
+	GlobalIngressIP string `json:"globalIngressIP,omitempty"`
+	// InternalIP is the service or pod IP in the VPC the connections are forwarded to
+	InternalIP string `json:"internalIP"`
+	// Protocol limits the rule to tcp or udp, it is required with Port
+	// +kubebuilder:validation:Enum=tcp;udp
+	// +optional
+	Protocol string `json:"protocol,omitempty"`
+	// Port limits the rule to connections to this port, all of them are forwarded when unset
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port int32 `json:"port,omitempty"`
+	// TargetPort is the port on InternalIP, Port when unset
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	TargetPort int32 `json:"targetPort,omitempty"`
+}
+
+// OverlayMapping translates a VPC subnet to an overlay range of the same size, SNAT on the way out and DNAT on
+// the way in
+type OverlayMapping struct {
+	LocalCIDR   string `json:"localCIDR"`
+	OverlayCIDR string `json:"overlayCIDR"`
 }
 
+const (
+	// ModeGlobalnet relies on Submariner Globalnet for the local addresses, the default
+	ModeGlobalnet = "globalnet"
+	// ModeSubnet routes the VPC subnets without translation, they must not overlap with the peer
+	ModeSubnet = "subnet"
+	// ModeOverlay maps the VPC subnets to operator-managed overlay ranges
+	ModeOverlay = "overlay"
+)
+
 // VpcNatTunnelStatus defines the observed state of VpcNatTunnel
 type VpcNatTunnelStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
-	Initialized   bool   `json:"initialized"`
-	InternalIP    string `json:"internalIp"`
-	RemoteIP      string `json:"remoteIp"`
+	Initialized bool   `json:"initialized"`
+	InternalIP  string `json:"internalIp"`
+	RemoteIP    string `json:"remoteIp"`
+	// InterfaceAddr is the address provisioned on the tunnel interface
+	// +optional
 	InterfaceAddr string `json:"interfaceAddr"`
 	NatGwDp       string `json:"natGwDp"`
 	Type          string `json:"type"`
 
 	GlobalnetCIDR       string   `json:"globalnetCIDR"`
-	RemoteGlobalnetCIDR string   `json:"remoteGlobalnetCIDR"`
+	RemoteGlobalnetCIDR string   `json:"remoteGlobalnetCIDR,omitempty"`
 	OvnGwIP             string   `json:"ovnGwIP"`
 	GlobalEgressIP      []string `json:"globalEgressIP"`
+
+	// Vpc is the VPC of the gateway the tunnel was provisioned on
+	// +optional
+	Vpc string `json:"vpc,omitempty"`
+	// RemoteCIDRs are the remote prefixes routed through the tunnel on the gateway and in the VPC
+	// +optional
+	RemoteCIDRs []string `json:"remoteCIDRs,omitempty"`
+	// LanIP is the gateway address the VPC routes the remote prefixes to
+	// +optional
+	LanIP string `json:"lanIp,omitempty"`
+	// NatBackend is the backend holding the SNAT rules of the tunnel, empty means iptables
+	// +optional
+	NatBackend string `json:"natBackend,omitempty"`
+	// RouteTable is the policy routing table holding the routes of the tunnel, 0 for tunnels routed in the main table
+	// +optional
+	RouteTable int `json:"routeTable,omitempty"`
+	// +optional
+	SourceCIDRs []string `json:"sourceCIDRs,omitempty"`
+	// +optional
+	FwMark uint32 `json:"fwMark,omitempty"`
+
+	// Mode is the mode the tunnel was provisioned in, empty for globalnet
+	// +optional
+	Mode string `json:"mode,omitempty"`
+	// +optional
+	OverlayMappings []OverlayMapping `json:"overlayMappings,omitempty"`
+	// GlobalIPPool is the pool holding the allocations of the tunnel, empty if they come from Submariner
+	// +optional
+	GlobalIPPool string `json:"globalIPPool,omitempty"`
+	// Ingress are the DNAT rules installed on the gateway, with their global IPs resolved
+	// +optional
+	Ingress []IngressRule `json:"ingress,omitempty"`
+
+	// InterfaceAddrPool is the pool holding the interface block of the tunnel
+	// +optional
+	InterfaceAddrPool string `json:"interfaceAddrPool,omitempty"`
+	// ResolvedRemoteGlobalnetCIDR is the globalnet CIDR of RemoteClusterID last read from Submariner, the
+	// RemoteGlobalnetCIDR of the tunnel
+	// +optional
+	ResolvedRemoteGlobalnetCIDR string `json:"resolvedRemoteGlobalnetCIDR,omitempty"`
+	// AllocatedInterfaceAddr is the first address of the block allocated from InterfaceAddrPool, the interface
+	// address of the tunnel
+	// +optional
+	AllocatedInterfaceAddr string `json:"allocatedInterfaceAddr,omitempty"`
+	// RemoteInterfaceAddr is the other address of the block allocated from InterfaceAddrPool, to be used as the
+	// interfaceAddr of the peer tunnel
+	// +optional
+	RemoteInterfaceAddr string `json:"remoteInterfaceAddr,omitempty"`
+
+	// InterfaceName is the kernel interface created for the tunnel on the gateway
+	// +optional
+	InterfaceName string `json:"interfaceName,omitempty"`
+
+	// Conditions represent the latest available observations of the tunnel's state
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+const (
+	// ConditionConflict is True when the tunnel overlaps with another tunnel on the same gateway
+	ConditionConflict = "Conflict"
+	// ConditionGatewayReady is False while the vpc-nat-gw pod of the tunnel cannot be used
+	ConditionGatewayReady = "GatewayReady"
+	// ConditionRemoteEndpointReady is False while the Submariner Endpoint of the RemoteClusterID of the tunnel cannot
+	// be resolved
+	ConditionRemoteEndpointReady = "RemoteEndpointReady"
+	// ConditionGlobalnetReady is False while the globalnet CIDR of the local cluster cannot be told from the
+	// Submariner Gateways, or cannot be allocated from the GlobalIPPool of the tunnel
+	ConditionGlobalnetReady = "GlobalnetReady"
+)
+
+// NatBackendAnnotation on a kube-ovn VpcNatGateway selects the backend of the tunnel SNAT rules on that gateway,
+// "iptables" or "nftables". It takes precedence over the manager configuration.
+const NatBackendAnnotation = "kubeovn.ustc.io/nat-backend"
+
+// TunnelRoutesAnnotation on a kube-ovn Vpc lists the static routes added for tunnels, as comma-separated
+// "<cidr> via <nextHop>". Routes that are not listed were there before and are never removed.
+const TunnelRoutesAnnotation = "kubeovn.ustc.io/tunnel-routes"
+
+// ForceDeleteAnnotation set to "true" lets a tunnel be deleted even if it cannot be removed from its gateway.
+// The teardown commands are then kept for garbage collection on that gateway.
+const ForceDeleteAnnotation = "kubeovn.ustc.io/force-delete"
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 
@@ -0,0 +1,124 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VpcPeeringSpec defines the desired state of VpcPeering
+type VpcPeeringSpec struct {
+	// NatGwDp is the local kube-ovn VpcNatGateway the tunnel is provisioned on
+	NatGwDp string `json:"natGwDp"`
+	// Remote is the other end of the peering
+	Remote PeeringRemote `json:"remote"`
+	// TransitCIDR holds the addresses of the tunnel interfaces: the first host address goes to the local end, the
+	// second one to the remote end, e.g. 10.100.0.0/30. Required unless InterfaceAddrPool is set
+	// +optional
+	TransitCIDR string `json:"transitCIDR,omitempty"`
+	// InterfaceAddrPool names a local InterfaceAddrPool the local tunnel takes its block from, instead of
+	// TransitCIDR. The remote tunnel gets the other address of the block.
+	// +optional
+	InterfaceAddrPool string `json:"interfaceAddrPool,omitempty"`
+	// +kubebuilder:validation:Enum=gre;vxlan
+	// +kubebuilder:default="gre"
+	// +optional
+	Type string `json:"type,omitempty"`
+	// GlobalnetCIDR overrides the globalnet CIDR of the local cluster, it is read from its Submariner Gateways otherwise
+	// +optional
+	GlobalnetCIDR string `json:"globalnetCIDR,omitempty"`
+}
+
+// PeeringRemote is the remote end of a VpcPeering
+type PeeringRemote struct {
+	// KubeconfigSecret is a Secret in the namespace of the peering whose "kubeconfig" key gives access to the remote
+	// cluster. It needs to read the gateway and Submariner objects there and to manage VpcNatTunnels.
+	KubeconfigSecret string `json:"kubeconfigSecret"`
+	// Namespace of the remote VpcNatTunnel, the namespace of the peering when unset
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// NatGwDp is the kube-ovn VpcNatGateway of the remote cluster the tunnel is provisioned on
+	NatGwDp string `json:"natGwDp"`
+	// GlobalnetCIDR overrides the globalnet CIDR of the remote cluster, it is read from its Submariner Gateways
+	// otherwise
+	// +optional
+	GlobalnetCIDR string `json:"globalnetCIDR,omitempty"`
+}
+
+// KubeconfigSecretKey is the key of the kubeconfig in the Secret named by PeeringRemote.KubeconfigSecret
+const KubeconfigSecretKey = "kubeconfig"
+
+// VpcPeeringLabel names the VpcPeering on the VpcNatTunnels it manages, on both clusters
+const VpcPeeringLabel = "kubeovn.ustc.io/vpc-peering"
+
+// PeeringEnd is what a VpcPeering found out about one of its ends
+type PeeringEnd struct {
+	// Tunnel is the namespace/name of the VpcNatTunnel of this end
+	Tunnel string `json:"tunnel,omitempty"`
+	// GatewayIP is the external address of the gateway, the RemoteIP of the tunnel at the other end
+	GatewayIP string `json:"gatewayIP,omitempty"`
+	// InterfaceAddr is the address of the tunnel interface of this end
+	InterfaceAddr string `json:"interfaceAddr,omitempty"`
+	// GlobalnetCIDR of this end, the RemoteGlobalnetCIDR of the tunnel at the other end
+	GlobalnetCIDR string `json:"globalnetCIDR,omitempty"`
+}
+
+// VpcPeeringStatus defines the observed state of VpcPeering
+type VpcPeeringStatus struct {
+	// +optional
+	Local PeeringEnd `json:"local,omitempty"`
+	// +optional
+	Remote PeeringEnd `json:"remote,omitempty"`
+	// RemoteKubeconfigSecret is the kubeconfig Secret of the cluster the remote tunnel was provisioned on
+	// +optional
+	RemoteKubeconfigSecret string `json:"remoteKubeconfigSecret,omitempty"`
+	// Conditions represent the latest available observations of the peering's state
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// ConditionPeeringReady is True once the tunnels of both ends match the peering, or of every member match the mesh
+const ConditionPeeringReady = "Ready"
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="NatGwDp",type=string,JSONPath=`.spec.natGwDp`
+//+kubebuilder:printcolumn:name="RemoteNatGwDp",type=string,JSONPath=`.spec.remote.natGwDp`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+
+// VpcPeering is the Schema for the vpcpeerings API. It provisions the VpcNatTunnels of both ends of a peering,
+// on the local cluster and on the remote one, and keeps them mirrored.
+type VpcPeering struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VpcPeeringSpec   `json:"spec,omitempty"`
+	Status VpcPeeringStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// VpcPeeringList contains a list of VpcPeering
+type VpcPeeringList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VpcPeering `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VpcPeering{}, &VpcPeeringList{})
+}
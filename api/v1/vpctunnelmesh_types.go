@@ -0,0 +1,148 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// VpcTunnelMeshSpec defines the desired state of VpcTunnelMesh
+type VpcTunnelMeshSpec struct {
+	// Members are the VPC gateways joined by the mesh
+	// +kubebuilder:validation:MinItems=2
+	Members []MeshMember `json:"members"`
+	// Topology is FullMesh, a tunnel between every two members, or HubAndSpoke, a tunnel between the Hub and every
+	// other member
+	// +kubebuilder:validation:Enum=FullMesh;HubAndSpoke
+	// +kubebuilder:default=FullMesh
+	// +optional
+	Topology string `json:"topology,omitempty"`
+	// Hub is the name of the member the spokes connect to, required with HubAndSpoke
+	// +optional
+	Hub string `json:"hub,omitempty"`
+	// TransitCIDR is cut into a /30 per tunnel pair, holding the interface addresses of its two ends
+	TransitCIDR string `json:"transitCIDR"`
+	// +kubebuilder:validation:Enum=gre;vxlan
+	// +kubebuilder:default="gre"
+	// +optional
+	Type string `json:"type,omitempty"`
+	// VNIBase is the first VNI handed out to vxlan tunnel pairs, every pair gets its own
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=16777215
+	// +kubebuilder:default=1000
+	// +optional
+	VNIBase int32 `json:"vniBase,omitempty"`
+	// VxlanPort is the UDP port of the vxlan tunnels
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +kubebuilder:default=4789
+	// +optional
+	VxlanPort int32 `json:"vxlanPort,omitempty"`
+}
+
+const (
+	// TopologyFullMesh connects every two members, the default
+	TopologyFullMesh = "FullMesh"
+	// TopologyHubAndSpoke connects every member to the hub
+	TopologyHubAndSpoke = "HubAndSpoke"
+)
+
+// MeshMember is a VPC gateway of a VpcTunnelMesh
+type MeshMember struct {
+	// Name identifies the member in the mesh and in the names of its tunnels
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	// +kubebuilder:validation:MaxLength=20
+	Name string `json:"name"`
+	// NatGwDp is the kube-ovn VpcNatGateway of the member
+	NatGwDp string `json:"natGwDp"`
+	// KubeconfigSecret is a Secret in the namespace of the mesh whose "kubeconfig" key gives access to the cluster
+	// of the member, the member is on the local cluster when unset
+	// +optional
+	KubeconfigSecret string `json:"kubeconfigSecret,omitempty"`
+	// Namespace of the tunnels of the member, the namespace of the mesh when unset
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// GlobalnetCIDR overrides the globalnet CIDR of the cluster of the member, it is read from its Submariner
+	// Gateways otherwise
+	// +optional
+	GlobalnetCIDR string `json:"globalnetCIDR,omitempty"`
+}
+
+// VpcTunnelMeshLabel names the VpcTunnelMesh on the VpcNatTunnels it manages, on every cluster
+const VpcTunnelMeshLabel = "kubeovn.ustc.io/vpc-tunnel-mesh"
+
+// MeshLink is a tunnel pair of the mesh, between members A and B. Its addresses are kept as long as the pair is.
+type MeshLink struct {
+	A string `json:"a"`
+	B string `json:"b"`
+	// TransitCIDR holds the interface addresses, the first host address goes to A and the second one to B
+	TransitCIDR string `json:"transitCIDR"`
+	// VNI of the pair when its tunnels are vxlan
+	// +optional
+	VNI int32 `json:"vni,omitempty"`
+}
+
+// MeshMemberStatus is where the tunnels of a member were provisioned, and what was found out about its gateway
+type MeshMemberStatus struct {
+	Name string `json:"name"`
+	// +optional
+	KubeconfigSecret string `json:"kubeconfigSecret,omitempty"`
+	Namespace        string `json:"namespace"`
+	// +optional
+	GatewayIP string `json:"gatewayIP,omitempty"`
+	// +optional
+	GlobalnetCIDR string `json:"globalnetCIDR,omitempty"`
+}
+
+// VpcTunnelMeshStatus defines the observed state of VpcTunnelMesh
+type VpcTunnelMeshStatus struct {
+	// +optional
+	Links []MeshLink `json:"links,omitempty"`
+	// +optional
+	Members []MeshMemberStatus `json:"members,omitempty"`
+	// Conditions represent the latest available observations of the mesh's state
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Topology",type=string,JSONPath=`.spec.topology`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+
+// VpcTunnelMesh is the Schema for the vpctunnelmeshes API. It provisions the VpcNatTunnels connecting its members
+// in the chosen topology, on whatever cluster each member is, and removes those no longer needed.
+type VpcTunnelMesh struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VpcTunnelMeshSpec   `json:"spec,omitempty"`
+	Status VpcTunnelMeshStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// VpcTunnelMeshList contains a list of VpcTunnelMesh
+type VpcTunnelMeshList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VpcTunnelMesh `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VpcTunnelMesh{}, &VpcTunnelMeshList{})
+}
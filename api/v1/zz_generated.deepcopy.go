@@ -21,9 +21,353 @@ limitations under the License.
 package v1
 
 import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GlobalEgressRange) DeepCopyInto(out *GlobalEgressRange) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GlobalEgressRange.
+func (in *GlobalEgressRange) DeepCopy() *GlobalEgressRange {
+	if in == nil {
+		return nil
+	}
+	out := new(GlobalEgressRange)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GlobalIPBlock) DeepCopyInto(out *GlobalIPBlock) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GlobalIPBlock.
+func (in *GlobalIPBlock) DeepCopy() *GlobalIPBlock {
+	if in == nil {
+		return nil
+	}
+	out := new(GlobalIPBlock)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GlobalIPPool) DeepCopyInto(out *GlobalIPPool) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GlobalIPPool.
+func (in *GlobalIPPool) DeepCopy() *GlobalIPPool {
+	if in == nil {
+		return nil
+	}
+	out := new(GlobalIPPool)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *GlobalIPPool) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GlobalIPPoolList) DeepCopyInto(out *GlobalIPPoolList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]GlobalIPPool, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GlobalIPPoolList.
+func (in *GlobalIPPoolList) DeepCopy() *GlobalIPPoolList {
+	if in == nil {
+		return nil
+	}
+	out := new(GlobalIPPoolList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *GlobalIPPoolList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GlobalIPPoolSpec) DeepCopyInto(out *GlobalIPPoolSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GlobalIPPoolSpec.
+func (in *GlobalIPPoolSpec) DeepCopy() *GlobalIPPoolSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(GlobalIPPoolSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GlobalIPPoolStatus) DeepCopyInto(out *GlobalIPPoolStatus) {
+	*out = *in
+	if in.Blocks != nil {
+		in, out := &in.Blocks, &out.Blocks
+		*out = make([]GlobalIPBlock, len(*in))
+		copy(*out, *in)
+	}
+	if in.EgressRanges != nil {
+		in, out := &in.EgressRanges, &out.EgressRanges
+		*out = make([]GlobalEgressRange, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GlobalIPPoolStatus.
+func (in *GlobalIPPoolStatus) DeepCopy() *GlobalIPPoolStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(GlobalIPPoolStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IngressRule) DeepCopyInto(out *IngressRule) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IngressRule.
+func (in *IngressRule) DeepCopy() *IngressRule {
+	if in == nil {
+		return nil
+	}
+	out := new(IngressRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InterfaceAddrAllocation) DeepCopyInto(out *InterfaceAddrAllocation) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InterfaceAddrAllocation.
+func (in *InterfaceAddrAllocation) DeepCopy() *InterfaceAddrAllocation {
+	if in == nil {
+		return nil
+	}
+	out := new(InterfaceAddrAllocation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InterfaceAddrPool) DeepCopyInto(out *InterfaceAddrPool) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InterfaceAddrPool.
+func (in *InterfaceAddrPool) DeepCopy() *InterfaceAddrPool {
+	if in == nil {
+		return nil
+	}
+	out := new(InterfaceAddrPool)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *InterfaceAddrPool) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InterfaceAddrPoolList) DeepCopyInto(out *InterfaceAddrPoolList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]InterfaceAddrPool, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InterfaceAddrPoolList.
+func (in *InterfaceAddrPoolList) DeepCopy() *InterfaceAddrPoolList {
+	if in == nil {
+		return nil
+	}
+	out := new(InterfaceAddrPoolList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *InterfaceAddrPoolList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InterfaceAddrPoolSpec) DeepCopyInto(out *InterfaceAddrPoolSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InterfaceAddrPoolSpec.
+func (in *InterfaceAddrPoolSpec) DeepCopy() *InterfaceAddrPoolSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(InterfaceAddrPoolSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InterfaceAddrPoolStatus) DeepCopyInto(out *InterfaceAddrPoolStatus) {
+	*out = *in
+	if in.Allocations != nil {
+		in, out := &in.Allocations, &out.Allocations
+		*out = make([]InterfaceAddrAllocation, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InterfaceAddrPoolStatus.
+func (in *InterfaceAddrPoolStatus) DeepCopy() *InterfaceAddrPoolStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(InterfaceAddrPoolStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MeshLink) DeepCopyInto(out *MeshLink) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MeshLink.
+func (in *MeshLink) DeepCopy() *MeshLink {
+	if in == nil {
+		return nil
+	}
+	out := new(MeshLink)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MeshMember) DeepCopyInto(out *MeshMember) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MeshMember.
+func (in *MeshMember) DeepCopy() *MeshMember {
+	if in == nil {
+		return nil
+	}
+	out := new(MeshMember)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MeshMemberStatus) DeepCopyInto(out *MeshMemberStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MeshMemberStatus.
+func (in *MeshMemberStatus) DeepCopy() *MeshMemberStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(MeshMemberStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OverlayMapping) DeepCopyInto(out *OverlayMapping) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OverlayMapping.
+func (in *OverlayMapping) DeepCopy() *OverlayMapping {
+	if in == nil {
+		return nil
+	}
+	out := new(OverlayMapping)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PeeringEnd) DeepCopyInto(out *PeeringEnd) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PeeringEnd.
+func (in *PeeringEnd) DeepCopy() *PeeringEnd {
+	if in == nil {
+		return nil
+	}
+	out := new(PeeringEnd)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PeeringRemote) DeepCopyInto(out *PeeringRemote) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PeeringRemote.
+func (in *PeeringRemote) DeepCopy() *PeeringRemote {
+	if in == nil {
+		return nil
+	}
+	out := new(PeeringRemote)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VpcDnsForward) DeepCopyInto(out *VpcDnsForward) {
 	*out = *in
@@ -118,7 +462,7 @@ func (in *VpcNatTunnel) DeepCopyInto(out *VpcNatTunnel) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -175,6 +519,31 @@ func (in *VpcNatTunnelList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VpcNatTunnelSpec) DeepCopyInto(out *VpcNatTunnelSpec) {
 	*out = *in
+	if in.RemoteCIDRs != nil {
+		in, out := &in.RemoteCIDRs, &out.RemoteCIDRs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.SourceCIDRs != nil {
+		in, out := &in.SourceCIDRs, &out.SourceCIDRs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.OverlayMappings != nil {
+		in, out := &in.OverlayMappings, &out.OverlayMappings
+		*out = make([]OverlayMapping, len(*in))
+		copy(*out, *in)
+	}
+	if in.EgressIPs != nil {
+		in, out := &in.EgressIPs, &out.EgressIPs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Ingress != nil {
+		in, out := &in.Ingress, &out.Ingress
+		*out = make([]IngressRule, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VpcNatTunnelSpec.
@@ -195,6 +564,33 @@ func (in *VpcNatTunnelStatus) DeepCopyInto(out *VpcNatTunnelStatus) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.RemoteCIDRs != nil {
+		in, out := &in.RemoteCIDRs, &out.RemoteCIDRs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.SourceCIDRs != nil {
+		in, out := &in.SourceCIDRs, &out.SourceCIDRs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.OverlayMappings != nil {
+		in, out := &in.OverlayMappings, &out.OverlayMappings
+		*out = make([]OverlayMapping, len(*in))
+		copy(*out, *in)
+	}
+	if in.Ingress != nil {
+		in, out := &in.Ingress, &out.Ingress
+		*out = make([]IngressRule, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VpcNatTunnelStatus.
@@ -206,3 +602,213 @@ func (in *VpcNatTunnelStatus) DeepCopy() *VpcNatTunnelStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VpcPeering) DeepCopyInto(out *VpcPeering) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VpcPeering.
+func (in *VpcPeering) DeepCopy() *VpcPeering {
+	if in == nil {
+		return nil
+	}
+	out := new(VpcPeering)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *VpcPeering) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VpcPeeringList) DeepCopyInto(out *VpcPeeringList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]VpcPeering, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VpcPeeringList.
+func (in *VpcPeeringList) DeepCopy() *VpcPeeringList {
+	if in == nil {
+		return nil
+	}
+	out := new(VpcPeeringList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *VpcPeeringList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VpcPeeringSpec) DeepCopyInto(out *VpcPeeringSpec) {
+	*out = *in
+	out.Remote = in.Remote
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VpcPeeringSpec.
+func (in *VpcPeeringSpec) DeepCopy() *VpcPeeringSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(VpcPeeringSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VpcPeeringStatus) DeepCopyInto(out *VpcPeeringStatus) {
+	*out = *in
+	out.Local = in.Local
+	out.Remote = in.Remote
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VpcPeeringStatus.
+func (in *VpcPeeringStatus) DeepCopy() *VpcPeeringStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(VpcPeeringStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VpcTunnelMesh) DeepCopyInto(out *VpcTunnelMesh) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VpcTunnelMesh.
+func (in *VpcTunnelMesh) DeepCopy() *VpcTunnelMesh {
+	if in == nil {
+		return nil
+	}
+	out := new(VpcTunnelMesh)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *VpcTunnelMesh) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VpcTunnelMeshList) DeepCopyInto(out *VpcTunnelMeshList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]VpcTunnelMesh, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VpcTunnelMeshList.
+func (in *VpcTunnelMeshList) DeepCopy() *VpcTunnelMeshList {
+	if in == nil {
+		return nil
+	}
+	out := new(VpcTunnelMeshList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *VpcTunnelMeshList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VpcTunnelMeshSpec) DeepCopyInto(out *VpcTunnelMeshSpec) {
+	*out = *in
+	if in.Members != nil {
+		in, out := &in.Members, &out.Members
+		*out = make([]MeshMember, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VpcTunnelMeshSpec.
+func (in *VpcTunnelMeshSpec) DeepCopy() *VpcTunnelMeshSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(VpcTunnelMeshSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VpcTunnelMeshStatus) DeepCopyInto(out *VpcTunnelMeshStatus) {
+	*out = *in
+	if in.Links != nil {
+		in, out := &in.Links, &out.Links
+		*out = make([]MeshLink, len(*in))
+		copy(*out, *in)
+	}
+	if in.Members != nil {
+		in, out := &in.Members, &out.Members
+		*out = make([]MeshMemberStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VpcTunnelMeshStatus.
+func (in *VpcTunnelMeshStatus) DeepCopy() *VpcTunnelMeshStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(VpcTunnelMeshStatus)
+	in.DeepCopyInto(out)
+	return out
+}
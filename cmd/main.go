@@ -38,6 +38,8 @@ import (
 
 	kubeovnv1 "multi-vpc/api/v1"
 	"multi-vpc/internal/controller"
+	"multi-vpc/internal/options"
+	webhookkubeovnv1 "multi-vpc/internal/webhook/v1"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -63,6 +65,7 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var tunnelConcurrency int
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
@@ -72,6 +75,11 @@ func main() {
 		"If set the metrics endpoint is served securely")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.IntVar(&tunnelConcurrency, "tunnel-max-concurrent-reconciles", 1,
+		"The number of VpcNatTunnels reconciled in parallel, so a slow gateway does not hold up tunnels on other gateways. "+
+			"Tunnels on the same gateway are always reconciled one at a time.")
+	managerOpts := options.NewOptions()
+	managerOpts.BindFlags(flag.CommandLine)
 	opts := zap.Options{
 		Development: true,
 	}
@@ -129,25 +137,45 @@ func main() {
 	}
 
 	if err = (&controller.VpcDnsForwardReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:  mgr.GetClient(),
+		Scheme:  mgr.GetScheme(),
+		Options: managerOpts,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "VpcDnsForward")
 		os.Exit(1)
 	}
 	if err = (&controller.VpcNatTunnelReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		Options:                 managerOpts,
+		MaxConcurrentReconciles: tunnelConcurrency,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "VpcNatTunnel")
 		os.Exit(1)
 	}
-	//+kubebuilder:scaffold:builder
-
-	if err := mgr.Add(controller.NewInformer(mgr.GetClient(), mgr.GetConfig())); err != nil {
-		setupLog.Error(err, "unable to set up gateway informer")
+	if err = (&controller.VpcPeeringReconciler{
+		Client:  mgr.GetClient(),
+		Scheme:  mgr.GetScheme(),
+		Options: managerOpts,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "VpcPeering")
+		os.Exit(1)
+	}
+	if err = (&controller.VpcTunnelMeshReconciler{
+		Client:  mgr.GetClient(),
+		Scheme:  mgr.GetScheme(),
+		Options: managerOpts,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "VpcTunnelMesh")
 		os.Exit(1)
 	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&webhookkubeovnv1.VpcNatTunnelCustomValidator{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "VpcNatTunnel")
+			os.Exit(1)
+		}
+	}
+	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
@@ -0,0 +1,43 @@
+package cidr
+
+// Dedup returns the non-empty prefixes of lists in order of first appearance, without duplicates
+func Dedup(lists ...[]string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, list := range lists {
+		for _, cidr := range list {
+			if cidr == "" || seen[cidr] {
+				continue
+			}
+			seen[cidr] = true
+			out = append(out, cidr)
+		}
+	}
+	return out
+}
+
+// Diff returns the prefixes of desired missing from current, and those of current missing from desired
+func Diff(current, desired []string) (added, removed []string) {
+	return missing(desired, current), missing(current, desired)
+}
+
+// Equal reports whether a and b hold the same prefixes, in any order
+func Equal(a, b []string) bool {
+	added, removed := Diff(a, b)
+	return len(added) == 0 && len(removed) == 0
+}
+
+// missing returns the prefixes of a that are not in b
+func missing(a, b []string) []string {
+	in := map[string]bool{}
+	for _, cidr := range b {
+		in[cidr] = true
+	}
+	var out []string
+	for _, cidr := range Dedup(a) {
+		if !in[cidr] {
+			out = append(out, cidr)
+		}
+	}
+	return out
+}
@@ -0,0 +1,186 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	kubeovnv1 "multi-vpc/api/v1"
+	"multi-vpc/internal/tunnel"
+	"multi-vpc/internal/tunnel/factory"
+	"multi-vpc/internal/tunnel/vxlan"
+)
+
+// findConflicts compares tunnel against the other tunnels on the same gateway and describes every overlap
+// that would make provisioning fail or misroute traffic. A tunnel only yields to peers that already hold
+// their resources on the gateway or were created before it, so the first tunnel keeps working.
+func findConflicts(tunnel *kubeovnv1.VpcNatTunnel, peers []kubeovnv1.VpcNatTunnel) []string {
+	var conflicts []string
+	for i := range peers {
+		peer := &peers[i]
+		if peer.UID == tunnel.UID || peer.Spec.NatGwDp != tunnel.Spec.NatGwDp || !peer.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if !yieldsTo(tunnel, peer) {
+			continue
+		}
+		peerName := peer.Namespace + "/" + peer.Name
+
+		if ifName := interfaceName(tunnel); ifName == interfaceName(peer) {
+			conflicts = append(conflicts, fmt.Sprintf("interface name %s is already used by %s", ifName, peerName))
+		}
+		if cidrsOverlap(specInterfaceAddr(peer), specInterfaceAddr(tunnel)) {
+			conflicts = append(conflicts, fmt.Sprintf("interfaceAddr %s overlaps %s of %s", specInterfaceAddr(tunnel), specInterfaceAddr(peer), peerName))
+		}
+		// overlapping remote CIDRs are fine as long as the source or fwmark selectors tell the traffic apart
+		if selectorsOverlap(peer, tunnel) {
+			conflicts = append(conflicts, fmt.Sprintf("remote CIDRs %v overlap %v of %s for the same sources", specRemoteCIDRs(tunnel), specRemoteCIDRs(peer), peerName))
+		}
+		if table := routeTable(tunnel); table == routeTable(peer) {
+			conflicts = append(conflicts, fmt.Sprintf("route table %d is already used by %s", table, peerName))
+		}
+
+		switch tunnelType(tunnel) {
+		case factory.VXLAN:
+			if tunnelType(peer) != factory.VXLAN {
+				break
+			}
+			vid, port := vxlan.GetVidAndPort(tunnel)
+			peerVid, peerPort := vxlan.GetVidAndPort(peer)
+			if vid == peerVid && port == peerPort {
+				conflicts = append(conflicts, fmt.Sprintf("vxlan id %s on port %s is already used by %s", vid, port, peerName))
+			}
+		case factory.GRE:
+			if tunnelType(peer) == factory.GRE && peer.Spec.RemoteIP == tunnel.Spec.RemoteIP {
+				conflicts = append(conflicts, fmt.Sprintf("gre endpoint to %s is already used by %s", tunnel.Spec.RemoteIP, peerName))
+			}
+		}
+	}
+	return conflicts
+}
+
+// yieldsTo reports whether tunnel has to give way to peer when the two overlap
+func yieldsTo(tunnel, peer *kubeovnv1.VpcNatTunnel) bool {
+	tunnelHolds, peerHolds := holdsNatGw(tunnel), holdsNatGw(peer)
+	if tunnelHolds != peerHolds {
+		return peerHolds
+	}
+	return olderThan(peer, tunnel)
+}
+
+// holdsNatGw reports whether the tunnel is already provisioned on the gateway named in its spec
+func holdsNatGw(tunnel *kubeovnv1.VpcNatTunnel) bool {
+	return tunnel.Status.Initialized && tunnel.Status.NatGwDp == tunnel.Spec.NatGwDp
+}
+
+// interfaceName returns the recorded interface name of the tunnel, or the one it is about to get
+func interfaceName(t *kubeovnv1.VpcNatTunnel) string {
+	if t.Status.InterfaceName != "" {
+		return t.Status.InterfaceName
+	}
+	return tunnel.GenInterfaceName(t.Namespace, t.Name)
+}
+
+func olderThan(a, b *kubeovnv1.VpcNatTunnel) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return a.Namespace+"/"+a.Name < b.Namespace+"/"+b.Name
+}
+
+// tunnelType returns the driver the factory will use for the tunnel
+func tunnelType(tunnel *kubeovnv1.VpcNatTunnel) string {
+	if tunnel.Spec.Type == factory.VXLAN {
+		return factory.VXLAN
+	}
+	return factory.GRE
+}
+
+func cidrsOverlap(a, b string) bool {
+	_, netA, err := net.ParseCIDR(a)
+	if err != nil {
+		return false
+	}
+	_, netB, err := net.ParseCIDR(b)
+	if err != nil {
+		return false
+	}
+	return netA.Contains(netB.IP) || netB.Contains(netA.IP)
+}
+
+// anyCidrsOverlap reports whether a prefix of a overlaps a prefix of b
+func anyCidrsOverlap(a, b []string) bool {
+	for _, cidrA := range a {
+		for _, cidrB := range b {
+			if cidrsOverlap(cidrA, cidrB) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkConflicts records the Conflict condition on the tunnel and reports whether provisioning must stop
+func (r *VpcNatTunnelReconciler) checkConflicts(ctx context.Context, vpcTunnel *kubeovnv1.VpcNatTunnel) (bool, error) {
+	peers := &kubeovnv1.VpcNatTunnelList{}
+	err := r.List(ctx, peers, client.MatchingFields{natGwDpIndexKey: vpcTunnel.Spec.NatGwDp})
+	if err != nil {
+		return false, err
+	}
+
+	condition := metav1.Condition{
+		Type:    kubeovnv1.ConditionConflict,
+		Status:  metav1.ConditionFalse,
+		Reason:  "NoConflict",
+		Message: "tunnel does not overlap with other tunnels on gateway " + vpcTunnel.Spec.NatGwDp,
+	}
+	conflicts := findConflicts(vpcTunnel, peers.Items)
+	if len(conflicts) != 0 {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "OverlapsExistingTunnel"
+		condition.Message = strings.Join(conflicts, "; ")
+	}
+	condition.ObservedGeneration = vpcTunnel.Generation
+	if meta.SetStatusCondition(&vpcTunnel.Status.Conditions, condition) {
+		if err := r.Status().Update(ctx, vpcTunnel); err != nil {
+			return false, err
+		}
+	}
+	return len(conflicts) != 0, nil
+}
+
+// conflictingPeers maps a tunnel to the tunnels that are waiting on a conflict on its gateway, so they are retried
+// once the tunnel changes or goes away. Tunnels on the gateway it was provisioned on are included, since the
+// tunnel releases that one when it moves.
+func (r *VpcNatTunnelReconciler) conflictingPeers(ctx context.Context, obj client.Object) []reconcile.Request {
+	tunnel, ok := obj.(*kubeovnv1.VpcNatTunnel)
+	if !ok {
+		return nil
+	}
+	natGws := []string{tunnel.Spec.NatGwDp}
+	if tunnel.Status.NatGwDp != "" && tunnel.Status.NatGwDp != tunnel.Spec.NatGwDp {
+		natGws = append(natGws, tunnel.Status.NatGwDp)
+	}
+	var requests []reconcile.Request
+	for _, natGw := range natGws {
+		peers := &kubeovnv1.VpcNatTunnelList{}
+		if err := r.List(ctx, peers, client.MatchingFields{natGwDpIndexKey: natGw}); err != nil {
+			return nil
+		}
+		for _, peer := range peers.Items {
+			if peer.UID != tunnel.UID && meta.IsStatusConditionTrue(peer.Status.Conditions, kubeovnv1.ConditionConflict) {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{Namespace: peer.Namespace, Name: peer.Name},
+				})
+			}
+		}
+	}
+	return requests
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	kubeovnv1 "multi-vpc/api/v1"
+	"multi-vpc/internal/tunnel"
+)
+
+var _ = Describe("Tunnel conflict detection", func() {
+	newTunnel := func(namespace, name string, age time.Duration, spec kubeovnv1.VpcNatTunnelSpec) kubeovnv1.VpcNatTunnel {
+		return kubeovnv1.VpcNatTunnel{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:         namespace,
+				Name:              name,
+				UID:               types.UID(namespace + "/" + name),
+				CreationTimestamp: metav1.NewTime(time.Now().Add(-age)),
+			},
+			Spec: spec,
+		}
+	}
+	baseSpec := kubeovnv1.VpcNatTunnelSpec{
+		RemoteIP:            "10.10.0.21",
+		InterfaceAddr:       "10.100.0.1/24",
+		NatGwDp:             "gw1",
+		Type:                "gre",
+		RemoteGlobalnetCIDR: "242.0.0.0/16",
+	}
+
+	It("should report every overlap with an older tunnel", func() {
+		older := newTunnel("ns1", "ovn-gre0", time.Hour, baseSpec)
+		newer := newTunnel("ns2", "ovn-gre0", time.Minute, baseSpec)
+
+		conflicts := findConflicts(&newer, []kubeovnv1.VpcNatTunnel{older, newer})
+		Expect(conflicts).To(HaveLen(3))
+		Expect(findConflicts(&older, []kubeovnv1.VpcNatTunnel{older, newer})).To(BeEmpty())
+	})
+
+	It("should detect a reused interface name", func() {
+		older := newTunnel("ns1", "ovn-gre0", time.Hour, baseSpec)
+		older.Status.InterfaceName = "ovn-gre0"
+		newer := newTunnel("ns2", "b", time.Minute, kubeovnv1.VpcNatTunnelSpec{
+			RemoteIP:            "10.10.0.22",
+			InterfaceAddr:       "10.100.1.1/24",
+			NatGwDp:             "gw1",
+			Type:                "gre",
+			RemoteGlobalnetCIDR: "242.1.0.0/16",
+		})
+		newer.Status.InterfaceName = "ovn-gre0"
+
+		Expect(findConflicts(&newer, []kubeovnv1.VpcNatTunnel{older})).To(ConsistOf(ContainSubstring("interface name ovn-gre0")))
+	})
+
+	It("should let a provisioned tunnel keep its resources", func() {
+		provisioned := newTunnel("ns1", "a", time.Minute, baseSpec)
+		provisioned.Status.Initialized = true
+		provisioned.Status.NatGwDp = "gw1"
+		pending := newTunnel("ns1", "b", time.Hour, baseSpec)
+
+		Expect(findConflicts(&pending, []kubeovnv1.VpcNatTunnel{provisioned})).NotTo(BeEmpty())
+		Expect(findConflicts(&provisioned, []kubeovnv1.VpcNatTunnel{pending})).To(BeEmpty())
+	})
+
+	It("should ignore tunnels on other gateways and disjoint tunnels", func() {
+		older := newTunnel("ns1", "a", time.Hour, baseSpec)
+		otherGw := baseSpec
+		otherGw.NatGwDp = "gw2"
+		disjoint := kubeovnv1.VpcNatTunnelSpec{
+			RemoteIP:            "10.10.0.22",
+			InterfaceAddr:       "10.100.1.1/24",
+			NatGwDp:             "gw1",
+			Type:                "gre",
+			RemoteGlobalnetCIDR: "242.1.0.0/16",
+		}
+
+		onOtherGw := newTunnel("ns1", "b", time.Minute, otherGw)
+		Expect(findConflicts(&onOtherGw, []kubeovnv1.VpcNatTunnel{older})).To(BeEmpty())
+		separate := newTunnel("ns1", "c", time.Minute, disjoint)
+		Expect(findConflicts(&separate, []kubeovnv1.VpcNatTunnel{older})).To(BeEmpty())
+	})
+
+	It("should allow overlapping remote CIDRs for disjoint sources", func() {
+		older := newTunnel("ns1", "a", time.Hour, baseSpec)
+		older.Spec.SourceCIDRs = []string{"10.0.1.0/24"}
+		spec := kubeovnv1.VpcNatTunnelSpec{
+			RemoteIP:            "10.10.0.22",
+			InterfaceAddr:       "10.100.1.1/24",
+			NatGwDp:             "gw1",
+			Type:                "gre",
+			RemoteGlobalnetCIDR: "242.0.0.0/16",
+			SourceCIDRs:         []string{"10.0.3.0/24", "10.0.2.0/24"},
+		}
+		newer := newTunnel("ns1", "b", time.Minute, spec)
+		Expect(findConflicts(&newer, []kubeovnv1.VpcNatTunnel{older})).To(BeEmpty())
+
+		newer.Spec.SourceCIDRs = nil
+		Expect(findConflicts(&newer, []kubeovnv1.VpcNatTunnel{older})).To(ConsistOf(ContainSubstring("remote CIDRs")))
+		newer.Spec.FwMark = 1
+		older.Spec.FwMark = 2
+		Expect(findConflicts(&newer, []kubeovnv1.VpcNatTunnel{older})).To(BeEmpty())
+	})
+
+	It("should detect duplicate vxlan id and port", func() {
+		vxlanSpec := kubeovnv1.VpcNatTunnelSpec{
+			RemoteIP:            "10.10.0.21",
+			InterfaceAddr:       "10.100.0.1/24",
+			NatGwDp:             "gw1",
+			Type:                "vxlan",
+			RemoteGlobalnetCIDR: "242.0.0.0/16",
+		}
+		older := newTunnel("ns1", "a", time.Hour, vxlanSpec)
+		vxlanSpec.RemoteIP = "10.10.0.22"
+		vxlanSpec.InterfaceAddr = "10.100.1.1/24"
+		vxlanSpec.RemoteGlobalnetCIDR = "242.1.0.0/16"
+		newer := newTunnel("ns1", "b", time.Minute, vxlanSpec)
+
+		Expect(findConflicts(&newer, []kubeovnv1.VpcNatTunnel{older})).To(ConsistOf(ContainSubstring("vxlan id 100")))
+		newer.Labels = map[string]string{"vid": "101"}
+		Expect(findConflicts(&newer, []kubeovnv1.VpcNatTunnel{older})).To(BeEmpty())
+	})
+
+	It("should retry the tunnels blocked on the gateway a tunnel moves away from", func() {
+		blocked := newTunnel("ns1", "b", time.Minute, baseSpec)
+		blocked.Status.Conditions = []metav1.Condition{{Type: kubeovnv1.ConditionConflict, Status: metav1.ConditionTrue}}
+		moved := newTunnel("ns1", "a", time.Hour, baseSpec)
+		moved.Spec.NatGwDp = "gw2"
+		moved.Status.NatGwDp = "gw1"
+		scheme := runtime.NewScheme()
+		Expect(kubeovnv1.AddToScheme(scheme)).To(Succeed())
+		reconciler := &VpcNatTunnelReconciler{
+			Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(&blocked, &moved).
+				WithIndex(&kubeovnv1.VpcNatTunnel{}, natGwDpIndexKey, func(obj client.Object) []string {
+					return []string{obj.(*kubeovnv1.VpcNatTunnel).Spec.NatGwDp}
+				}).Build(),
+		}
+
+		Expect(reconciler.conflictingPeers(context.Background(), &moved)).To(ConsistOf(reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: "ns1", Name: "b"},
+		}))
+	})
+})
+
+var _ = Describe("Tunnel interface naming", func() {
+	It("should generate short names that differ across namespaces", func() {
+		name := tunnel.GenInterfaceName("ns1", "a-very-long-tunnel-name-over-ifnamsiz")
+		Expect(len(name)).To(BeNumerically("<=", 15))
+		Expect(name).To(HavePrefix(tunnel.InterfacePrefix))
+		Expect(tunnel.GenInterfaceName("ns1", "a-very-long-tunnel-name-over-ifnamsiz")).To(Equal(name))
+		Expect(tunnel.GenInterfaceName("ns2", "a-very-long-tunnel-name-over-ifnamsiz")).NotTo(Equal(name))
+	})
+})
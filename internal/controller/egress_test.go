@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	Submariner "github.com/submariner-io/submariner/pkg/apis/submariner.io/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kubeovnv1 "multi-vpc/api/v1"
+	"multi-vpc/internal/nat"
+	natfactory "multi-vpc/internal/nat/factory"
+	"multi-vpc/internal/tunnel"
+)
+
+var _ = Describe("Globalnet egress IPs", func() {
+	var vpcTunnel *kubeovnv1.VpcNatTunnel
+	var chain string
+
+	BeforeEach(func() {
+		vpcTunnel = &kubeovnv1.VpcNatTunnel{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "ovn-gre0"}}
+		vpcTunnel.Status.InterfaceName = "mvpc-0123456789"
+		chain = tunnel.GenChainName("ns1", "ovn-gre0")
+	})
+
+	It("should merge unordered egress IPs into ranges", func() {
+		ranges, err := nat.EgressRanges([]string{"242.0.0.9", "242.0.0.2", "242.0.0.1", "242.0.0.4", "242.0.0.3", "242.0.0.2"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(nat.RangesString(ranges)).To(Equal("242.0.0.1-242.0.0.4,242.0.0.9"))
+		Expect(ranges[0].Size()).To(Equal(uint64(4)))
+
+		ranges, err = nat.EgressRanges([]string{"242.0.0.1", "fd00::1", "bogus"})
+		Expect(err).To(MatchError(ContainSubstring("fd00::1, bogus")))
+		Expect(nat.RangesString(ranges)).To(Equal("242.0.0.1"))
+	})
+
+	It("should SNAT to every range in proportion to its size", func() {
+		egressIPs := []string{"242.0.0.9", "242.0.0.1", "242.0.0.2", "242.0.0.3"}
+		snat := genSnatOp(vpcTunnel, natfactory.IPTABLES, []string{"242.1.0.0/16"}, egressIPs, nil, nil)
+		Expect(snat.CreateCmd()).To(ContainSubstring(
+			"iptables -t nat -A " + chain + " -o mvpc-0123456789 -d 242.1.0.0/16 -m statistic --mode random --probability 0.75 -j SNAT --to-source 242.0.0.1-242.0.0.3;" +
+				"iptables -t nat -A " + chain + " -o mvpc-0123456789 -d 242.1.0.0/16 -j SNAT --to-source 242.0.0.9;"))
+
+		snat = genSnatOp(vpcTunnel, natfactory.NFTABLES, []string{"242.1.0.0/16"}, egressIPs, nil, nil)
+		Expect(snat.CreateCmd()).To(ContainSubstring(
+			"ip daddr 242.1.0.0/16 numgen random mod 4 < 3 counter snat to 242.0.0.1-242.0.0.3\n" +
+				"add rule ip multi-vpc " + chain + " oifname \"mvpc-0123456789\" ip daddr 242.1.0.0/16 counter snat to 242.0.0.9\n"))
+	})
+
+	It("should only rebuild the chain when the ranges change", func() {
+		previous := genSnatRule(vpcTunnel, []string{"242.1.0.0/16"}, []string{"242.0.0.1", "242.0.0.2"}, nil, nil)
+		snat := genSnatOp(vpcTunnel, natfactory.IPTABLES, []string{"242.1.0.0/16"}, []string{"242.0.0.2", "242.0.0.1"}, nil, nil)
+		Expect(snat.UpdateCmd(previous)).To(BeEmpty())
+		snat = genSnatOp(vpcTunnel, natfactory.IPTABLES, []string{"242.1.0.0/16"}, []string{"242.0.0.1", "242.0.0.3"}, nil, nil)
+		Expect(snat.UpdateCmd(previous)).To(ContainSubstring("iptables -t nat -F " + chain))
+	})
+
+	It("should wait until Submariner allocates egress IPs", func() {
+		scheme := runtime.NewScheme()
+		Expect(Submariner.AddToScheme(scheme)).To(Succeed())
+		egressIP := &Submariner.ClusterGlobalEgressIP{ObjectMeta: metav1.ObjectMeta{Name: "cluster-egress.submariner.io"}}
+		cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(egressIP).Build()
+		reconciler := &VpcNatTunnelReconciler{Client: cl}
+
+		_, err := reconciler.getGlobalEgressIP()
+		Expect(err).To(MatchError(ContainSubstring("no IPs allocated")))
+
+		egressIP.Status.AllocatedIPs = []string{"242.0.0.2", "242.0.0.1"}
+		Expect(cl.Update(context.Background(), egressIP)).To(Succeed())
+		Expect(reconciler.getGlobalEgressIP()).To(ConsistOf("242.0.0.1", "242.0.0.2"))
+	})
+})
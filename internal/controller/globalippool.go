@@ -0,0 +1,213 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	kubeovnv1 "multi-vpc/api/v1"
+	"multi-vpc/internal/globalip"
+)
+
+//+kubebuilder:rbac:groups=kubeovn.ustc.io,resources=globalippools,verbs=get;list;watch
+//+kubebuilder:rbac:groups=kubeovn.ustc.io,resources=globalippools/status,verbs=get;update;patch
+
+const (
+	globalIPPoolIndexKey = "spec.globalIPPool"
+
+	defaultPoolBlockSize = 24
+	defaultPoolEgressIPs = 8
+)
+
+// specGlobalIPPool returns the pool the tunnel takes its globalnet addresses from, none outside globalnet mode
+func specGlobalIPPool(vpcTunnel *kubeovnv1.VpcNatTunnel) string {
+	if specMode(vpcTunnel) != kubeovnv1.ModeGlobalnet {
+		return ""
+	}
+	return vpcTunnel.Spec.GlobalIPPool
+}
+
+// globalnetSourceChanged reports whether the globalnet addresses recorded in status came from elsewhere than where
+// the spec takes them from, in which case they cannot be reused
+func globalnetSourceChanged(vpcTunnel *kubeovnv1.VpcNatTunnel) bool {
+	return statusMode(vpcTunnel) != specMode(vpcTunnel) || vpcTunnel.Status.GlobalIPPool != specGlobalIPPool(vpcTunnel)
+}
+
+func tunnelKey(vpcTunnel *kubeovnv1.VpcNatTunnel) string {
+	return vpcTunnel.Namespace + "/" + vpcTunnel.Name
+}
+
+// poolOwner returns the owner of the globalnet CIDR the tunnels of vpc share
+func poolOwner(pool *kubeovnv1.GlobalIPPool, vpc string) string {
+	if pool.Spec.Scope == kubeovnv1.PoolScopeCluster {
+		return "cluster"
+	}
+	return "vpc/" + vpc
+}
+
+// allocateGlobalIPs returns the globalnet CIDR of owner and the egress IPs of the tunnel key, allocating them in the
+// pool status if they are not there yet. changed tells whether the status has to be written back.
+func allocateGlobalIPs(pool *kubeovnv1.GlobalIPPool, key, owner string) (GlobalnetCIDR string, GlobalEgressIP []string, changed bool, err error) {
+	// a tunnel that moved to another owner gives its range back first
+	for _, r := range pool.Status.EgressRanges {
+		if r.Tunnel == key && r.Owner != owner {
+			changed = releaseGlobalIPs(pool, key)
+			break
+		}
+	}
+
+	poolCIDR, err := netip.ParsePrefix(pool.Spec.CIDR)
+	if err != nil {
+		return "", nil, changed, fmt.Errorf("invalid CIDR %q in GlobalIPPool %s", pool.Spec.CIDR, pool.Name)
+	}
+	blockSize := pool.Spec.BlockSize
+	if blockSize == 0 {
+		blockSize = defaultPoolBlockSize
+	}
+	egressIPs := pool.Spec.EgressIPs
+	if egressIPs == 0 {
+		egressIPs = defaultPoolEgressIPs
+	}
+
+	var block netip.Prefix
+	var usedBlocks []netip.Prefix
+	for _, b := range pool.Status.Blocks {
+		p, err := netip.ParsePrefix(b.CIDR)
+		if err != nil {
+			continue
+		}
+		if b.Owner == owner {
+			block = p
+		}
+		usedBlocks = append(usedBlocks, p)
+	}
+	if !block.IsValid() {
+		block, err = globalip.NextBlock(poolCIDR, blockSize, usedBlocks)
+		if err != nil {
+			return "", nil, changed, err
+		}
+		pool.Status.Blocks = append(pool.Status.Blocks, kubeovnv1.GlobalIPBlock{Owner: owner, CIDR: block.String()})
+		changed = true
+	}
+
+	var usedRanges []globalip.Range
+	for _, r := range pool.Status.EgressRanges {
+		first, err1 := netip.ParseAddr(r.First)
+		last, err2 := netip.ParseAddr(r.Last)
+		if err1 != nil || err2 != nil || r.Owner != owner {
+			continue
+		}
+		if r.Tunnel == key {
+			return block.String(), globalip.Range{First: first, Last: last}.Addrs(), changed, nil
+		}
+		usedRanges = append(usedRanges, globalip.Range{First: first, Last: last})
+	}
+	egress, err := globalip.NextRange(block, egressIPs, usedRanges)
+	if err != nil {
+		return "", nil, changed, err
+	}
+	pool.Status.EgressRanges = append(pool.Status.EgressRanges, kubeovnv1.GlobalEgressRange{
+		Tunnel: key,
+		Owner:  owner,
+		First:  egress.First.String(),
+		Last:   egress.Last.String(),
+	})
+	return block.String(), egress.Addrs(), true, nil
+}
+
+// releaseGlobalIPs removes the egress range of the tunnel key from the pool status, and the globalnet CIDR of its
+// owner if no other tunnel uses it. It reports whether anything was removed.
+func releaseGlobalIPs(pool *kubeovnv1.GlobalIPPool, key string) bool {
+	var owner string
+	ranges := pool.Status.EgressRanges[:0]
+	for _, r := range pool.Status.EgressRanges {
+		if r.Tunnel == key {
+			owner = r.Owner
+			continue
+		}
+		ranges = append(ranges, r)
+	}
+	if owner == "" {
+		return false
+	}
+	pool.Status.EgressRanges = ranges
+	for _, r := range ranges {
+		if r.Owner == owner {
+			return true
+		}
+	}
+	blocks := pool.Status.Blocks[:0]
+	for _, b := range pool.Status.Blocks {
+		if b.Owner != owner {
+			blocks = append(blocks, b)
+		}
+	}
+	pool.Status.Blocks = blocks
+	return true
+}
+
+// allocateFromPool returns the globalnet CIDR and the egress IPs of a tunnel in vpc out of its GlobalIPPool. The
+// allocations are written to the pool status before they are used, concurrent allocations are retried on conflict.
+func (r *VpcNatTunnelReconciler) allocateFromPool(ctx context.Context, vpcTunnel *kubeovnv1.VpcNatTunnel, vpc string) (string, []string, error) {
+	var GlobalnetCIDR string
+	var GlobalEgressIP []string
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pool := &kubeovnv1.GlobalIPPool{}
+		err := r.Get(ctx, client.ObjectKey{Name: vpcTunnel.Spec.GlobalIPPool}, pool)
+		if k8serrors.IsNotFound(err) {
+			return &globalnetError{reason: "GlobalIPPoolNotFound", message: fmt.Sprintf("GlobalIPPool %s not found", vpcTunnel.Spec.GlobalIPPool)}
+		}
+		if err != nil {
+			return err
+		}
+		var changed bool
+		GlobalnetCIDR, GlobalEgressIP, changed, err = allocateGlobalIPs(pool, tunnelKey(vpcTunnel), poolOwner(pool, vpc))
+		if err != nil {
+			return &globalnetError{reason: "GlobalIPPoolExhausted", message: err.Error()}
+		}
+		if !changed {
+			return nil
+		}
+		return r.Status().Update(ctx, pool)
+	})
+	return GlobalnetCIDR, GlobalEgressIP, err
+}
+
+// releaseFromPool gives the allocations of the tunnel back to the named pool. A pool that is gone has nothing to
+// give back.
+func (r *VpcNatTunnelReconciler) releaseFromPool(ctx context.Context, vpcTunnel *kubeovnv1.VpcNatTunnel, poolName string) error {
+	if poolName == "" {
+		return nil
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pool := &kubeovnv1.GlobalIPPool{}
+		err := r.Get(ctx, client.ObjectKey{Name: poolName}, pool)
+		if err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if !releaseGlobalIPs(pool, tunnelKey(vpcTunnel)) {
+			return nil
+		}
+		return r.Status().Update(ctx, pool)
+	})
+}
+
+// globalIPPoolToTunnels maps a GlobalIPPool to the tunnels allocating from it
+func (r *VpcNatTunnelReconciler) globalIPPoolToTunnels(ctx context.Context, obj client.Object) []reconcile.Request {
+	tunnels := &kubeovnv1.VpcNatTunnelList{}
+	if err := r.List(ctx, tunnels, client.MatchingFields{globalIPPoolIndexKey: obj.GetName()}); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list vpcNatTunnels for pool", "globalIPPool", obj.GetName())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(tunnels.Items))
+	for _, tunnel := range tunnels.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&tunnel)})
+	}
+	return requests
+}
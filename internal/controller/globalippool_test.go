@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kubeovnv1 "multi-vpc/api/v1"
+)
+
+var _ = Describe("GlobalIPPool allocation", func() {
+	var pool *kubeovnv1.GlobalIPPool
+
+	BeforeEach(func() {
+		pool = &kubeovnv1.GlobalIPPool{ObjectMeta: metav1.ObjectMeta{Name: "pool1"}}
+		pool.Spec.CIDR = "242.0.0.0/22"
+		pool.Spec.BlockSize = 24
+		pool.Spec.Scope = kubeovnv1.PoolScopeVpc
+		pool.Spec.EgressIPs = 4
+	})
+
+	It("should give every VPC its own block and every tunnel its own range", func() {
+		GlobalnetCIDR, GlobalEgressIP, changed, err := allocateGlobalIPs(pool, "ns1/a", "vpc/vpc1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(GlobalnetCIDR).To(Equal("242.0.0.0/24"))
+		Expect(GlobalEgressIP).To(Equal([]string{"242.0.0.1", "242.0.0.2", "242.0.0.3", "242.0.0.4"}))
+
+		GlobalnetCIDR, GlobalEgressIP, _, err = allocateGlobalIPs(pool, "ns1/b", "vpc/vpc1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(GlobalnetCIDR).To(Equal("242.0.0.0/24"))
+		Expect(GlobalEgressIP).To(HaveExactElements("242.0.0.5", "242.0.0.6", "242.0.0.7", "242.0.0.8"))
+
+		GlobalnetCIDR, _, _, err = allocateGlobalIPs(pool, "ns2/c", "vpc/vpc2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(GlobalnetCIDR).To(Equal("242.0.1.0/24"))
+
+		// allocations are stable
+		GlobalnetCIDR, GlobalEgressIP, changed, err = allocateGlobalIPs(pool, "ns1/a", "vpc/vpc1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeFalse())
+		Expect(GlobalnetCIDR).To(Equal("242.0.0.0/24"))
+		Expect(GlobalEgressIP).To(HaveExactElements("242.0.0.1", "242.0.0.2", "242.0.0.3", "242.0.0.4"))
+	})
+
+	It("should reuse released addresses and blocks", func() {
+		_, _, _, err := allocateGlobalIPs(pool, "ns1/a", "vpc/vpc1")
+		Expect(err).NotTo(HaveOccurred())
+		_, _, _, err = allocateGlobalIPs(pool, "ns1/b", "vpc/vpc1")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(releaseGlobalIPs(pool, "ns1/a")).To(BeTrue())
+		Expect(releaseGlobalIPs(pool, "ns1/a")).To(BeFalse())
+		Expect(pool.Status.Blocks).To(HaveLen(1))
+		_, GlobalEgressIP, _, err := allocateGlobalIPs(pool, "ns1/c", "vpc/vpc1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(GlobalEgressIP).To(HaveExactElements("242.0.0.1", "242.0.0.2", "242.0.0.3", "242.0.0.4"))
+
+		// moving to another VPC gives the old range back
+		GlobalnetCIDR, _, _, err := allocateGlobalIPs(pool, "ns1/b", "vpc/vpc2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(GlobalnetCIDR).To(Equal("242.0.1.0/24"))
+		Expect(pool.Status.EgressRanges).To(HaveLen(2))
+
+		Expect(releaseGlobalIPs(pool, "ns1/c")).To(BeTrue())
+		Expect(pool.Status.Blocks).To(Equal([]kubeovnv1.GlobalIPBlock{{Owner: "vpc/vpc2", CIDR: "242.0.1.0/24"}}))
+	})
+
+	It("should share one block in cluster scope and report exhaustion", func() {
+		pool.Spec.Scope = kubeovnv1.PoolScopeCluster
+		pool.Spec.CIDR = "242.0.0.0/24"
+		pool.Spec.EgressIPs = 200
+		GlobalnetCIDR, _, _, err := allocateGlobalIPs(pool, "ns1/a", poolOwner(pool, "vpc1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(GlobalnetCIDR).To(Equal("242.0.0.0/24"))
+		_, _, _, err = allocateGlobalIPs(pool, "ns2/b", poolOwner(pool, "vpc2"))
+		Expect(err).To(MatchError(ContainSubstring("no 200 free addresses left in 242.0.0.0/24")))
+
+		pool.Spec.Scope = kubeovnv1.PoolScopeVpc
+		_, _, _, err = allocateGlobalIPs(pool, "ns2/b", poolOwner(pool, "vpc2"))
+		Expect(err).To(MatchError(ContainSubstring("no free /24 block left in 242.0.0.0/24")))
+	})
+
+	It("should record allocations in the pool status and the tunnel condition", func() {
+		ctx := context.Background()
+		scheme := runtime.NewScheme()
+		Expect(kubeovnv1.AddToScheme(scheme)).To(Succeed())
+		vpcTunnel := &kubeovnv1.VpcNatTunnel{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "a"}}
+		vpcTunnel.Spec.GlobalIPPool = "pool1"
+		reconciler := &VpcNatTunnelReconciler{
+			Client: fake.NewClientBuilder().WithScheme(scheme).
+				WithStatusSubresource(&kubeovnv1.VpcNatTunnel{}, &kubeovnv1.GlobalIPPool{}).
+				WithObjects(vpcTunnel).Build(),
+		}
+
+		_, _, err := reconciler.resolveGlobalnet(ctx, vpcTunnel, "vpc1")
+		Expect(err).To(HaveOccurred())
+		condition := meta.FindStatusCondition(vpcTunnel.Status.Conditions, kubeovnv1.ConditionGlobalnetReady)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Reason).To(Equal("GlobalIPPoolNotFound"))
+
+		Expect(reconciler.Create(ctx, pool)).To(Succeed())
+		GlobalnetCIDR, GlobalEgressIP, err := reconciler.resolveGlobalnet(ctx, vpcTunnel, "vpc1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(GlobalnetCIDR).To(Equal("242.0.0.0/24"))
+		Expect(GlobalEgressIP).To(HaveLen(4))
+		Expect(meta.IsStatusConditionTrue(vpcTunnel.Status.Conditions, kubeovnv1.ConditionGlobalnetReady)).To(BeTrue())
+
+		stored := &kubeovnv1.GlobalIPPool{}
+		Expect(reconciler.Get(ctx, client.ObjectKey{Name: "pool1"}, stored)).To(Succeed())
+		Expect(stored.Status.EgressRanges).To(Equal([]kubeovnv1.GlobalEgressRange{
+			{Tunnel: "ns1/a", Owner: "vpc/vpc1", First: "242.0.0.1", Last: "242.0.0.4"},
+		}))
+
+		Expect(reconciler.releaseFromPool(ctx, vpcTunnel, "pool1")).To(Succeed())
+		Expect(reconciler.Get(ctx, client.ObjectKey{Name: "pool1"}, stored)).To(Succeed())
+		Expect(stored.Status.Blocks).To(BeEmpty())
+		Expect(reconciler.releaseFromPool(ctx, vpcTunnel, "gone")).To(Succeed())
+	})
+})
@@ -0,0 +1,426 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"strings"
+
+	Submariner "github.com/submariner-io/submariner/pkg/apis/submariner.io/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	kubeovnv1 "multi-vpc/api/v1"
+	"multi-vpc/internal/nat"
+)
+
+//+kubebuilder:rbac:groups=submariner.io,resources=clusters,verbs=get;list;watch
+
+// genInFlowRoute forwards traffic to the local globalnet CIDR to the OVN gateway of the VPC. The route is the same
+// for every tunnel on a gateway, so it is replaced rather than added.
+func genInFlowRoute(GlobalnetCIDR string, ovnGwIP string) string {
+	return fmt.Sprintf("ip route replace %s via %s dev eth0", GlobalnetCIDR, ovnGwIP)
+}
+
+// submarinerServed reports whether the cluster serves the submariner.io/v1 API. The Submariner objects are only
+// watched and read if it does, it is looked up once at startup.
+func submarinerServed(mapper meta.RESTMapper) (bool, error) {
+	gk := schema.GroupKind{Group: Submariner.SchemeGroupVersion.Group, Kind: "Gateway"}
+	_, err := mapper.RESTMapping(gk, Submariner.SchemeGroupVersion.Version)
+	if meta.IsNoMatchError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// needsSubmariner reports whether the globalnet addresses of the tunnel are read from Submariner rather than its
+// GlobalIPPool and egress IPs
+func needsSubmariner(vpcTunnel *kubeovnv1.VpcNatTunnel) bool {
+	return vpcTunnel.Spec.GlobalIPPool == "" || vpcTunnel.Spec.GlobalEgressIP != ""
+}
+
+// globalnetChanged passes the Submariner objects the tunnels depend on, and only the updates of the
+// fields read from them. The Gateway status is rewritten with connection stats every few seconds.
+func (r *VpcNatTunnelReconciler) globalnetChanged() predicate.Predicate {
+	relevant := func(obj client.Object) bool {
+		switch obj.(type) {
+		case *Submariner.Gateway:
+			return obj.GetNamespace() == r.opts().SubmarinerNamespace
+		case *Submariner.ClusterGlobalEgressIP:
+			return obj.GetName() == r.opts().ClusterGlobalEgressIP
+		case *Submariner.Cluster:
+			return obj.GetNamespace() == r.opts().SubmarinerNamespace
+		}
+		return false
+	}
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool { return relevant(e.Object) },
+		DeleteFunc: func(e event.DeleteEvent) bool { return relevant(e.Object) },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if !relevant(e.ObjectNew) {
+				return false
+			}
+			switch newObj := e.ObjectNew.(type) {
+			case *Submariner.Gateway:
+				oldObj := e.ObjectOld.(*Submariner.Gateway)
+				return oldObj.Status.HAStatus != newObj.Status.HAStatus ||
+					oldObj.Status.LocalEndpoint.ClusterID != newObj.Status.LocalEndpoint.ClusterID ||
+					!slices.Equal(oldObj.Status.LocalEndpoint.Subnets, newObj.Status.LocalEndpoint.Subnets)
+			case *Submariner.ClusterGlobalEgressIP:
+				oldObj := e.ObjectOld.(*Submariner.ClusterGlobalEgressIP)
+				return !slices.Equal(oldObj.Status.AllocatedIPs, newObj.Status.AllocatedIPs)
+			case *Submariner.Cluster:
+				oldObj := e.ObjectOld.(*Submariner.Cluster)
+				return !slices.Equal(oldObj.Spec.GlobalCIDR, newObj.Spec.GlobalCIDR)
+			}
+			return false
+		},
+		GenericFunc: func(e event.GenericEvent) bool { return false },
+	}
+}
+
+// allTunnels maps a change of the cluster globalnet configuration to every tunnel
+func (r *VpcNatTunnelReconciler) allTunnels(ctx context.Context, _ client.Object) []reconcile.Request {
+	tunnels := &kubeovnv1.VpcNatTunnelList{}
+	if err := r.List(ctx, tunnels); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list vpcNatTunnels")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(tunnels.Items))
+	for _, tunnel := range tunnels.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: tunnel.Namespace, Name: tunnel.Name},
+		})
+	}
+	return requests
+}
+
+// globalnetError tells why the globalnet CIDR could not be selected, its reason goes into the GlobalnetReady
+// condition
+type globalnetError struct {
+	reason  string
+	message string
+}
+
+func (e *globalnetError) Error() string {
+	return e.message
+}
+
+// getGlobalnetCIDR returns the globalnet CIDR of the local cluster and the Submariner Gateway it was read from.
+// Only active gateways are considered, passive ones may still advertise a stale endpoint. Every active gateway
+// has to agree on the CIDR.
+func (r *VpcNatTunnelReconciler) getGlobalnetCIDR(ctx context.Context) (string, string, error) {
+	submGwlist := &Submariner.GatewayList{}
+	err := r.List(ctx, submGwlist, client.InNamespace(r.opts().SubmarinerNamespace))
+	if err != nil {
+		return "", "", err
+	}
+	var active []Submariner.Gateway
+	for _, gw := range submGwlist.Items {
+		if gw.Status.HAStatus == Submariner.HAStatusActive {
+			active = append(active, gw)
+		}
+	}
+	if len(active) == 0 {
+		return "", "", &globalnetError{
+			reason:  "NoActiveGateway",
+			message: fmt.Sprintf("no active Submariner Gateway in namespace %s among %d", r.opts().SubmarinerNamespace, len(submGwlist.Items)),
+		}
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].Name < active[j].Name })
+
+	var GlobalnetCIDR, gwName string
+	var candidates []string
+	for _, gw := range active {
+		configured, err := r.configuredGlobalnetCIDR(ctx, gw.Status.LocalEndpoint.ClusterID)
+		if err != nil {
+			return "", "", err
+		}
+		subnet, err := selectGlobalnetSubnet(gw.Status.LocalEndpoint.Subnets, configured)
+		if err != nil {
+			return "", "", &globalnetError{
+				reason:  "NoMatchingSubnet",
+				message: fmt.Sprintf("Submariner Gateway %s: %v", gw.Name, err),
+			}
+		}
+		candidates = append(candidates, fmt.Sprintf("%s from %s", subnet, gw.Name))
+		if GlobalnetCIDR == "" {
+			GlobalnetCIDR, gwName = subnet, gw.Name
+		} else if subnet != GlobalnetCIDR {
+			return "", "", &globalnetError{
+				reason:  "GatewaysDisagree",
+				message: "active Submariner Gateways report different globalnet CIDRs: " + strings.Join(candidates, ", "),
+			}
+		}
+	}
+	return GlobalnetCIDR, gwName, nil
+}
+
+// configuredGlobalnetCIDR returns the globalnet CIDR the cluster was deployed with: the --globalnet-cidr option,
+// or else the global CIDR of the Submariner Cluster of clusterID. It is empty if neither is known.
+func (r *VpcNatTunnelReconciler) configuredGlobalnetCIDR(ctx context.Context, clusterID string) (string, error) {
+	if r.opts().GlobalnetCIDR != "" {
+		return r.opts().GlobalnetCIDR, nil
+	}
+	if clusterID == "" {
+		return "", nil
+	}
+	cluster := &Submariner.Cluster{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: r.opts().SubmarinerNamespace, Name: clusterID}, cluster)
+	if err != nil {
+		return "", client.IgnoreNotFound(err)
+	}
+	if len(cluster.Spec.GlobalCIDR) == 0 {
+		return "", nil
+	}
+	return cluster.Spec.GlobalCIDR[0], nil
+}
+
+// selectGlobalnetSubnet picks the subnet of a gateway endpoint matching the configured globalnet CIDR. Without one,
+// the endpoint has to advertise a single subnet, which is what it does with globalnet enabled.
+func selectGlobalnetSubnet(subnets []string, configured string) (string, error) {
+	if configured == "" {
+		switch len(subnets) {
+		case 0:
+			return "", fmt.Errorf("no subnets in the local endpoint")
+		case 1:
+			return subnets[0], nil
+		default:
+			return "", fmt.Errorf("several subnets %v in the local endpoint and no globalnet CIDR configured to pick one", subnets)
+		}
+	}
+	_, want, err := net.ParseCIDR(configured)
+	if err != nil {
+		return "", fmt.Errorf("invalid configured globalnet CIDR %q", configured)
+	}
+	for _, subnet := range subnets {
+		if _, got, err := net.ParseCIDR(subnet); err == nil && got.String() == want.String() {
+			return subnet, nil
+		}
+	}
+	return "", fmt.Errorf("none of the subnets %v of the local endpoint is the globalnet CIDR %s", subnets, configured)
+}
+
+// resolveGlobalnetCIDR returns the globalnet CIDR of the local cluster and records in the GlobalnetReady condition
+// of the tunnel whether it could be selected
+func (r *VpcNatTunnelReconciler) resolveGlobalnetCIDR(ctx context.Context, vpcTunnel *kubeovnv1.VpcNatTunnel) (string, error) {
+	GlobalnetCIDR, gwName, err := r.getGlobalnetCIDR(ctx)
+	if err := r.setGlobalnetCondition(ctx, vpcTunnel, "ActiveGatewayFound",
+		fmt.Sprintf("globalnet CIDR %s from active Submariner Gateway %s", GlobalnetCIDR, gwName), err); err != nil {
+		return "", err
+	}
+	return GlobalnetCIDR, err
+}
+
+// setGlobalnetCondition records the outcome of looking up the globalnet addresses in the GlobalnetReady condition.
+// A globalnetError sets it to False with its reason, other errors leave it alone.
+func (r *VpcNatTunnelReconciler) setGlobalnetCondition(ctx context.Context, vpcTunnel *kubeovnv1.VpcNatTunnel, reason, message string, err error) error {
+	condition := metav1.Condition{
+		Type:               kubeovnv1.ConditionGlobalnetReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: vpcTunnel.Generation,
+	}
+	var selectErr *globalnetError
+	switch {
+	case errors.As(err, &selectErr):
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = selectErr.reason
+		condition.Message = selectErr.message
+	case err != nil:
+		return err
+	}
+	if meta.SetStatusCondition(&vpcTunnel.Status.Conditions, condition) {
+		return r.Status().Update(ctx, vpcTunnel)
+	}
+	return nil
+}
+
+// resolveGlobalnet returns the globalnet CIDR and the egress IPs local traffic of vpc is translated to, out of the
+// GlobalIPPool of the tunnel if it names one and from Submariner otherwise. The tunnel's own egress IPs take
+// precedence. Tunnels outside globalnet mode do not depend on any of them and get neither.
+func (r *VpcNatTunnelReconciler) resolveGlobalnet(ctx context.Context, vpcTunnel *kubeovnv1.VpcNatTunnel, vpc string) (string, []string, error) {
+	if specMode(vpcTunnel) != kubeovnv1.ModeGlobalnet {
+		return "", nil, nil
+	}
+	if r.submarinerMissing && needsSubmariner(vpcTunnel) {
+		err := &globalnetError{
+			reason: "SubmarinerNotInstalled",
+			message: "the cluster does not serve the submariner.io/v1 API, globalnet mode needs a globalIPPool and " +
+				"egressIPs without it, or use the subnet or overlay mode",
+		}
+		if err := r.setGlobalnetCondition(ctx, vpcTunnel, "", "", err); err != nil {
+			return "", nil, err
+		}
+		return "", nil, err
+	}
+	var GlobalnetCIDR string
+	var GlobalEgressIP []string
+	var err error
+	if vpcTunnel.Spec.GlobalIPPool != "" {
+		GlobalnetCIDR, GlobalEgressIP, err = r.allocateFromPool(ctx, vpcTunnel, vpc)
+		if err := r.setGlobalnetCondition(ctx, vpcTunnel, "AllocatedFromPool",
+			fmt.Sprintf("globalnet CIDR %s from GlobalIPPool %s", GlobalnetCIDR, vpcTunnel.Spec.GlobalIPPool), err); err != nil {
+			return "", nil, err
+		}
+		if err != nil {
+			return "", nil, err
+		}
+	} else {
+		GlobalnetCIDR, err = r.resolveGlobalnetCIDR(ctx, vpcTunnel)
+		if err != nil {
+			return "", nil, err
+		}
+		if !hasEgressSource(vpcTunnel) {
+			GlobalEgressIP, err = r.getGlobalEgressIP()
+			if err != nil {
+				return "", nil, err
+			}
+		}
+	}
+	if hasEgressSource(vpcTunnel) {
+		GlobalEgressIP, err = r.getTunnelEgressIP(ctx, vpcTunnel)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	return GlobalnetCIDR, GlobalEgressIP, nil
+}
+
+// refreshGlobalnet re-renders the in-flow route and the SNAT of a provisioned tunnel when Submariner, or its
+// GlobalIPPool, changed the local globalnet CIDR or the egress IPs since they were recorded in status. The rules
+// of the old values are removed in the same run.
+func (r *VpcNatTunnelReconciler) refreshGlobalnet(ctx context.Context, vpcTunnel *kubeovnv1.VpcNatTunnel) error {
+	GlobalnetCIDR, GlobalEgressIP, err := r.resolveGlobalnet(ctx, vpcTunnel, vpcTunnel.Status.Vpc)
+	if err != nil {
+		return err
+	}
+
+	var cmds []string
+	if GlobalnetCIDR != vpcTunnel.Status.GlobalnetCIDR {
+		// the old route is left to the tunnels still on it, the last one of them drops it
+		shared, err := r.inFlowRouteShared(ctx, vpcTunnel, vpcTunnel.Status.NatGwDp)
+		if err != nil {
+			return err
+		}
+		if !shared {
+			if del := bestEffort(genDelGlobalnetRoute(vpcTunnel.Status.GlobalnetCIDR, vpcTunnel.Status.OvnGwIP, "", "", nil)); del != "" {
+				cmds = append(cmds, del)
+			}
+		}
+		cmds = append(cmds, genInFlowRoute(GlobalnetCIDR, vpcTunnel.Status.OvnGwIP))
+	}
+	remoteCIDRs := statusRemoteCIDRs(vpcTunnel)
+	snat := genSnatOp(vpcTunnel, vpcTunnel.Status.NatBackend, remoteCIDRs, GlobalEgressIP, vpcTunnel.Status.OverlayMappings, vpcTunnel.Status.Ingress)
+	// the SNAT goes last, it may be an nft transaction
+	if cmd := snat.UpdateCmd(genSnatRule(vpcTunnel, remoteCIDRs, vpcTunnel.Status.GlobalEgressIP, vpcTunnel.Status.OverlayMappings, vpcTunnel.Status.Ingress)); cmd != "" {
+		cmds = append(cmds, cmd)
+	}
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	pod, err := r.getNatGwPod(vpcTunnel.Status.NatGwDp)
+	if err != nil {
+		return err
+	}
+	err = r.execCommandInPod(pod.Name, pod.Namespace, r.opts().NatGwContainer, strings.Join(cmds, ";"))
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("refreshed globalnet rules", "tunnel", vpcTunnel.Name,
+		"globalnetCIDR", GlobalnetCIDR, "globalEgressIP", GlobalEgressIP)
+
+	vpcTunnel.Status.GlobalnetCIDR = GlobalnetCIDR
+	vpcTunnel.Status.GlobalEgressIP = GlobalEgressIP
+	return r.Status().Update(ctx, vpcTunnel)
+}
+
+// inFlowRouteShared reports whether another tunnel provisioned on natGw still needs the in-flow route recorded in
+// the status of the tunnel, that is it routes the same globalnet CIDR to the same OVN gateway
+func (r *VpcNatTunnelReconciler) inFlowRouteShared(ctx context.Context, vpcTunnel *kubeovnv1.VpcNatTunnel, natGw string) (bool, error) {
+	tunnels := &kubeovnv1.VpcNatTunnelList{}
+	if err := r.List(ctx, tunnels); err != nil {
+		return false, err
+	}
+	for _, peer := range tunnels.Items {
+		if peer.UID == vpcTunnel.UID || !peer.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if !peer.Status.Initialized || peer.Status.NatGwDp != natGw || statusMode(&peer) != kubeovnv1.ModeGlobalnet {
+			continue
+		}
+		if peer.Status.GlobalnetCIDR == vpcTunnel.Status.GlobalnetCIDR && peer.Status.OvnGwIP == vpcTunnel.Status.OvnGwIP {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// tunnelTeardownCmd returns the teardown of the tunnel on the gateway it was provisioned on, leaving the in-flow
+// route to the tunnels still using that gateway
+func (r *VpcNatTunnelReconciler) tunnelTeardownCmd(ctx context.Context, vpcTunnel *kubeovnv1.VpcNatTunnel) (string, error) {
+	shared, err := r.inFlowRouteShared(ctx, vpcTunnel, provisionedNatGw(vpcTunnel))
+	if err != nil {
+		return "", err
+	}
+	return r.genTeardownCmd(vpcTunnel, shared), nil
+}
+
+// hasEgressSource reports whether the tunnel has SNAT sources of its own rather than those of the cluster or pool
+func hasEgressSource(vpcTunnel *kubeovnv1.VpcNatTunnel) bool {
+	return len(vpcTunnel.Spec.EgressIPs) != 0 || vpcTunnel.Spec.GlobalEgressIP != ""
+}
+
+// getTunnelEgressIP returns the SNAT sources of the tunnel itself: its explicit list, or the IPs allocated to the
+// Submariner GlobalEgressIP it names in its namespace
+func (r *VpcNatTunnelReconciler) getTunnelEgressIP(ctx context.Context, vpcTunnel *kubeovnv1.VpcNatTunnel) ([]string, error) {
+	if len(vpcTunnel.Spec.EgressIPs) != 0 {
+		if _, err := nat.EgressRanges(vpcTunnel.Spec.EgressIPs); err != nil {
+			return nil, err
+		}
+		return vpcTunnel.Spec.EgressIPs, nil
+	}
+	egressIP := &Submariner.GlobalEgressIP{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: vpcTunnel.Namespace, Name: vpcTunnel.Spec.GlobalEgressIP}, egressIP)
+	if err != nil {
+		return nil, err
+	}
+	// like with the ClusterGlobalEgressIP, wait for Submariner to allocate some
+	if len(egressIP.Status.AllocatedIPs) == 0 {
+		return nil, fmt.Errorf("no IPs allocated to GlobalEgressIP %s/%s yet", egressIP.Namespace, egressIP.Name)
+	}
+	if _, err := nat.EgressRanges(egressIP.Status.AllocatedIPs); err != nil {
+		return nil, fmt.Errorf("GlobalEgressIP %s/%s: %w", egressIP.Namespace, egressIP.Name, err)
+	}
+	return egressIP.Status.AllocatedIPs, nil
+}
+
+// globalEgressIPToTunnels maps a Submariner GlobalEgressIP to the tunnels of its namespace translating to its IPs
+func (r *VpcNatTunnelReconciler) globalEgressIPToTunnels(ctx context.Context, obj client.Object) []reconcile.Request {
+	tunnels := &kubeovnv1.VpcNatTunnelList{}
+	if err := r.List(ctx, tunnels, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list vpcNatTunnels for GlobalEgressIP", "globalEgressIP", obj.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, tunnel := range tunnels.Items {
+		if tunnel.Spec.GlobalEgressIP == obj.GetName() && len(tunnel.Spec.EgressIPs) == 0 {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: tunnel.Namespace, Name: tunnel.Name},
+			})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,211 @@
+package controller
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	Submariner "github.com/submariner-io/submariner/pkg/apis/submariner.io/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	kubeovnv1 "multi-vpc/api/v1"
+	"multi-vpc/internal/options"
+	"multi-vpc/internal/tunnel/factory"
+)
+
+var _ = Describe("Globalnet refresh", func() {
+	var reconciler *VpcNatTunnelReconciler
+	var gw *Submariner.Gateway
+	var egressIP *Submariner.ClusterGlobalEgressIP
+
+	newTunnel := func(name, natGw string, initialized bool) *kubeovnv1.VpcNatTunnel {
+		t := &kubeovnv1.VpcNatTunnel{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: name, UID: types.UID("uid-" + name)}}
+		t.Status.Initialized = initialized
+		t.Status.NatGwDp = natGw
+		return t
+	}
+
+	BeforeEach(func() {
+		gw = &Submariner.Gateway{ObjectMeta: metav1.ObjectMeta{Namespace: "submariner-operator", Name: "node1"}}
+		gw.Status.HAStatus = Submariner.HAStatusActive
+		gw.Status.LocalEndpoint.ClusterID = "cluster1"
+		gw.Status.LocalEndpoint.Subnets = []string{"242.0.0.0/16"}
+		egressIP = &Submariner.ClusterGlobalEgressIP{ObjectMeta: metav1.ObjectMeta{Name: "cluster-egress.submariner.io"}}
+		egressIP.Status.AllocatedIPs = []string{"242.0.0.1", "242.0.0.2"}
+
+		scheme := runtime.NewScheme()
+		Expect(kubeovnv1.AddToScheme(scheme)).To(Succeed())
+		Expect(Submariner.AddToScheme(scheme)).To(Succeed())
+		reconciler = &VpcNatTunnelReconciler{
+			Client: fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(&kubeovnv1.VpcNatTunnel{}).WithObjects(
+				gw, egressIP,
+				newTunnel("a", "gw1", true),
+				newTunnel("b", "gw2", true),
+				newTunnel("c", "gw2", false),
+			).Build(),
+			tunnelOpFact: factory.NewTunnelOpFactory(),
+		}
+	})
+
+	It("should only pass changes of the globalnet CIDR and egress IPs", func() {
+		p := reconciler.globalnetChanged()
+		updated := gw.DeepCopy()
+		updated.Status.Connections = []Submariner.Connection{{Status: Submariner.Connected}}
+		Expect(p.Update(event.UpdateEvent{ObjectOld: gw, ObjectNew: updated})).To(BeFalse())
+		updated.Status.HAStatus = Submariner.HAStatusPassive
+		Expect(p.Update(event.UpdateEvent{ObjectOld: gw, ObjectNew: updated})).To(BeTrue())
+		updated.Status.HAStatus = Submariner.HAStatusActive
+		updated.Status.LocalEndpoint.Subnets = []string{"242.1.0.0/16"}
+		Expect(p.Update(event.UpdateEvent{ObjectOld: gw, ObjectNew: updated})).To(BeTrue())
+
+		other := gw.DeepCopy()
+		other.Namespace = "default"
+		Expect(p.Create(event.CreateEvent{Object: other})).To(BeFalse())
+
+		updatedEgress := egressIP.DeepCopy()
+		updatedEgress.Status.AllocatedIPs = []string{"242.0.0.3"}
+		Expect(p.Update(event.UpdateEvent{ObjectOld: egressIP, ObjectNew: updatedEgress})).To(BeTrue())
+		updatedEgress.Name = "ns-egress"
+		Expect(p.Update(event.UpdateEvent{ObjectOld: egressIP, ObjectNew: updatedEgress})).To(BeFalse())
+
+		Expect(reconciler.allTunnels(context.Background(), gw)).To(HaveLen(3))
+	})
+
+	It("should read the globalnet CIDR from the active gateway only", func() {
+		ctx := context.Background()
+		passive := gw.DeepCopy()
+		passive.Name = "node0"
+		passive.ResourceVersion = ""
+		passive.Status.HAStatus = Submariner.HAStatusPassive
+		passive.Status.LocalEndpoint.Subnets = nil
+		Expect(reconciler.Create(ctx, passive)).To(Succeed())
+
+		vpcTunnel := newTunnel("a", "gw1", true)
+		Expect(reconciler.Get(ctx, client.ObjectKeyFromObject(vpcTunnel), vpcTunnel)).To(Succeed())
+		Expect(reconciler.resolveGlobalnetCIDR(ctx, vpcTunnel)).To(Equal("242.0.0.0/16"))
+		Expect(meta.IsStatusConditionTrue(vpcTunnel.Status.Conditions, kubeovnv1.ConditionGlobalnetReady)).To(BeTrue())
+
+		gw.Status.HAStatus = Submariner.HAStatusPassive
+		Expect(reconciler.Update(ctx, gw)).To(Succeed())
+		_, err := reconciler.resolveGlobalnetCIDR(ctx, vpcTunnel)
+		Expect(err).To(HaveOccurred())
+		condition := meta.FindStatusCondition(vpcTunnel.Status.Conditions, kubeovnv1.ConditionGlobalnetReady)
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal("NoActiveGateway"))
+
+		passive.Status.HAStatus = Submariner.HAStatusActive
+		passive.Status.LocalEndpoint.Subnets = []string{"242.1.0.0/16"}
+		Expect(reconciler.Update(ctx, passive)).To(Succeed())
+		gw.Status.HAStatus = Submariner.HAStatusActive
+		Expect(reconciler.Update(ctx, gw)).To(Succeed())
+		_, _, err = reconciler.getGlobalnetCIDR(ctx)
+		Expect(err).To(MatchError("active Submariner Gateways report different globalnet CIDRs: 242.1.0.0/16 from node0, 242.0.0.0/16 from node1"))
+	})
+
+	It("should prefer the egress IPs of the tunnel over the cluster ones", func() {
+		ctx := context.Background()
+		vpcTunnel := newTunnel("a", "gw1", true)
+		Expect(reconciler.Get(ctx, client.ObjectKeyFromObject(vpcTunnel), vpcTunnel)).To(Succeed())
+		_, GlobalEgressIP, err := reconciler.resolveGlobalnet(ctx, vpcTunnel, "vpc1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(GlobalEgressIP).To(HaveExactElements("242.0.0.1", "242.0.0.2"))
+
+		vpcTunnel.Spec.GlobalEgressIP = "tenant1"
+		_, _, err = reconciler.resolveGlobalnet(ctx, vpcTunnel, "vpc1")
+		Expect(err).To(HaveOccurred())
+		tenant := &Submariner.GlobalEgressIP{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "tenant1"}}
+		Expect(reconciler.Create(ctx, tenant)).To(Succeed())
+		_, _, err = reconciler.resolveGlobalnet(ctx, vpcTunnel, "vpc1")
+		Expect(err).To(MatchError("no IPs allocated to GlobalEgressIP ns1/tenant1 yet"))
+		tenant.Status.AllocatedIPs = []string{"242.0.0.9"}
+		Expect(reconciler.Update(ctx, tenant)).To(Succeed())
+		GlobalnetCIDR, GlobalEgressIP, err := reconciler.resolveGlobalnet(ctx, vpcTunnel, "vpc1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(GlobalnetCIDR).To(Equal("242.0.0.0/16"))
+		Expect(GlobalEgressIP).To(HaveExactElements("242.0.0.9"))
+		Expect(reconciler.Update(ctx, vpcTunnel)).To(Succeed())
+		Expect(reconciler.globalEgressIPToTunnels(ctx, tenant)).To(HaveLen(1))
+
+		// the cluster egress IPs are not needed any more
+		Expect(reconciler.Delete(ctx, egressIP)).To(Succeed())
+		vpcTunnel.Spec.GlobalEgressIP = ""
+		vpcTunnel.Spec.EgressIPs = []string{"242.0.0.20", "242.0.0.21"}
+		_, GlobalEgressIP, err = reconciler.resolveGlobalnet(ctx, vpcTunnel, "vpc1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(GlobalEgressIP).To(HaveExactElements("242.0.0.20", "242.0.0.21"))
+	})
+
+	It("should match the endpoint subnets against the configured globalnet CIDR", func() {
+		Expect(selectGlobalnetSubnet([]string{"242.0.0.0/16"}, "")).To(Equal("242.0.0.0/16"))
+		_, err := selectGlobalnetSubnet(nil, "")
+		Expect(err).To(HaveOccurred())
+		_, err = selectGlobalnetSubnet([]string{"10.96.0.0/12", "10.16.0.0/16"}, "")
+		Expect(err).To(HaveOccurred())
+		Expect(selectGlobalnetSubnet([]string{"10.96.0.0/12", "242.0.0.0/16"}, "242.0.0.1/16")).To(Equal("242.0.0.0/16"))
+		_, err = selectGlobalnetSubnet([]string{"242.1.0.0/16"}, "242.0.0.0/16")
+		Expect(err).To(HaveOccurred())
+
+		cluster := &Submariner.Cluster{ObjectMeta: metav1.ObjectMeta{Namespace: "submariner-operator", Name: "cluster1"}}
+		cluster.Spec.GlobalCIDR = []string{"242.1.0.0/16"}
+		Expect(reconciler.Create(context.Background(), cluster)).To(Succeed())
+		_, _, err = reconciler.getGlobalnetCIDR(context.Background())
+		var selectErr *globalnetError
+		Expect(errors.As(err, &selectErr)).To(BeTrue())
+		Expect(selectErr.reason).To(Equal("NoMatchingSubnet"))
+
+		reconciler.Options = options.NewOptions()
+		reconciler.Options.GlobalnetCIDR = "242.0.0.0/16"
+		GlobalnetCIDR, gwName, err := reconciler.getGlobalnetCIDR(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(GlobalnetCIDR).To(Equal("242.0.0.0/16"))
+		Expect(gwName).To(Equal("node1"))
+	})
+
+	It("should keep the in-flow route while other tunnels use the gateway", func() {
+		ctx := context.Background()
+		Expect(reconciler.inFlowRouteShared(ctx, newTunnel("a", "gw1", true), "gw1")).To(BeFalse())
+		Expect(reconciler.inFlowRouteShared(ctx, newTunnel("d", "gw2", true), "gw2")).To(BeTrue())
+
+		vpcTunnel := newTunnel("a", "gw1", true)
+		vpcTunnel.Status.InterfaceName = "mvpc-0123456789"
+		vpcTunnel.Status.GlobalnetCIDR = "242.0.0.0/16"
+		vpcTunnel.Status.OvnGwIP = "10.0.1.1"
+		Expect(reconciler.tunnelTeardownCmd(ctx, vpcTunnel)).To(ContainSubstring("ip route del 242.0.0.0/16 via 10.0.1.1 dev eth0"))
+		Expect(reconciler.genTeardownCmd(vpcTunnel, true)).NotTo(ContainSubstring("242.0.0.0/16"))
+	})
+
+	It("should only share the in-flow route with tunnels of the same globalnet CIDR", func() {
+		ctx := context.Background()
+		peer := &kubeovnv1.VpcNatTunnel{}
+		Expect(reconciler.Get(ctx, client.ObjectKey{Namespace: "ns1", Name: "b"}, peer)).To(Succeed())
+		peer.Status.GlobalnetCIDR = "242.0.1.0/24"
+		peer.Status.OvnGwIP = "10.0.1.1"
+		Expect(reconciler.Status().Update(ctx, peer)).To(Succeed())
+
+		vpcTunnel := newTunnel("d", "gw2", true)
+		vpcTunnel.Status.GlobalnetCIDR = "242.0.2.0/24"
+		vpcTunnel.Status.OvnGwIP = "10.0.1.1"
+		Expect(reconciler.inFlowRouteShared(ctx, vpcTunnel, "gw2")).To(BeFalse())
+		vpcTunnel.Status.GlobalnetCIDR = "242.0.1.0/24"
+		Expect(reconciler.inFlowRouteShared(ctx, vpcTunnel, "gw2")).To(BeTrue())
+		vpcTunnel.Status.OvnGwIP = "10.0.2.1"
+		Expect(reconciler.inFlowRouteShared(ctx, vpcTunnel, "gw2")).To(BeFalse())
+	})
+
+	It("should leave tunnels alone while Submariner is unchanged", func() {
+		vpcTunnel := newTunnel("a", "gw1", true)
+		Expect(reconciler.Get(context.Background(), client.ObjectKeyFromObject(vpcTunnel), vpcTunnel)).To(Succeed())
+		vpcTunnel.Status.GlobalnetCIDR = "242.0.0.0/16"
+		vpcTunnel.Status.GlobalEgressIP = []string{"242.0.0.2", "242.0.0.1"}
+		vpcTunnel.Status.RemoteCIDRs = []string{"242.1.0.0/16"}
+		// nothing to run, so the missing gateway pod is never looked up
+		Expect(reconciler.refreshGlobalnet(context.Background(), vpcTunnel)).To(Succeed())
+	})
+})
@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"context"
+	"slices"
+
+	Submariner "github.com/submariner-io/submariner/pkg/apis/submariner.io/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	kubeovnv1 "multi-vpc/api/v1"
+)
+
+//+kubebuilder:rbac:groups=submariner.io,resources=globalingressips,verbs=get;list;watch
+
+// resolveIngress returns the ingress rules of the spec with their global IPs filled in. The allocated IP of a
+// GlobalIngressIP takes precedence over GlobalIP. Rules without a global IP yet are left out until their
+// GlobalIngressIP gets one.
+func (r *VpcNatTunnelReconciler) resolveIngress(ctx context.Context, vpcTunnel *kubeovnv1.VpcNatTunnel) ([]kubeovnv1.IngressRule, error) {
+	var ingress []kubeovnv1.IngressRule
+	for _, rule := range vpcTunnel.Spec.Ingress {
+		if rule.GlobalIngressIP != "" && !r.submarinerMissing {
+			ip := &Submariner.GlobalIngressIP{}
+			err := r.Get(ctx, client.ObjectKey{Namespace: vpcTunnel.Namespace, Name: rule.GlobalIngressIP}, ip)
+			switch {
+			case err == nil && ip.Status.AllocatedIP != "":
+				rule.GlobalIP = ip.Status.AllocatedIP
+			case err != nil && !k8serrors.IsNotFound(err):
+				return nil, err
+			}
+		}
+		if rule.GlobalIP == "" {
+			log.FromContext(ctx).Info("no global IP for ingress rule yet", "tunnel", vpcTunnel.Name, "globalIngressIP", rule.GlobalIngressIP)
+			continue
+		}
+		ingress = append(ingress, rule)
+	}
+	return ingress, nil
+}
+
+// refreshIngress installs the ingress rules of the spec on the gateway of a provisioned tunnel when they differ from
+// the ones recorded in status. They may change with the spec or with the GlobalIngressIPs they follow.
+func (r *VpcNatTunnelReconciler) refreshIngress(ctx context.Context, vpcTunnel *kubeovnv1.VpcNatTunnel) error {
+	ingress, err := r.resolveIngress(ctx, vpcTunnel)
+	if err != nil {
+		return err
+	}
+	if slices.Equal(ingress, vpcTunnel.Status.Ingress) {
+		return nil
+	}
+
+	remoteCIDRs := statusRemoteCIDRs(vpcTunnel)
+	snat := genSnatOp(vpcTunnel, vpcTunnel.Status.NatBackend, remoteCIDRs, vpcTunnel.Status.GlobalEgressIP, vpcTunnel.Status.OverlayMappings, ingress)
+	previous := genSnatRule(vpcTunnel, remoteCIDRs, vpcTunnel.Status.GlobalEgressIP, vpcTunnel.Status.OverlayMappings, vpcTunnel.Status.Ingress)
+	if cmd := snat.UpdateCmd(previous); cmd != "" {
+		pod, err := r.getNatGwPod(vpcTunnel.Status.NatGwDp)
+		if err != nil {
+			return err
+		}
+		err = r.execCommandInPod(pod.Name, pod.Namespace, r.opts().NatGwContainer, cmd)
+		if err != nil {
+			return err
+		}
+	}
+	log.FromContext(ctx).Info("updated ingress rules", "tunnel", vpcTunnel.Name, "rules", len(ingress))
+
+	vpcTunnel.Status.Ingress = ingress
+	return r.Status().Update(ctx, vpcTunnel)
+}
+
+// globalIngressIPToTunnels maps a Submariner GlobalIngressIP to the tunnels of its namespace forwarding its IP
+func (r *VpcNatTunnelReconciler) globalIngressIPToTunnels(ctx context.Context, obj client.Object) []reconcile.Request {
+	tunnels := &kubeovnv1.VpcNatTunnelList{}
+	if err := r.List(ctx, tunnels, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list vpcNatTunnels for GlobalIngressIP", "globalIngressIP", obj.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, tunnel := range tunnels.Items {
+		for _, rule := range tunnel.Spec.Ingress {
+			if rule.GlobalIngressIP == obj.GetName() {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{Namespace: tunnel.Namespace, Name: tunnel.Name},
+				})
+				break
+			}
+		}
+	}
+	return requests
+}
@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	Submariner "github.com/submariner-io/submariner/pkg/apis/submariner.io/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kubeovnv1 "multi-vpc/api/v1"
+	natfactory "multi-vpc/internal/nat/factory"
+	"multi-vpc/internal/tunnel"
+)
+
+var _ = Describe("Ingress DNAT", func() {
+	var vpcTunnel *kubeovnv1.VpcNatTunnel
+	var reconciler *VpcNatTunnelReconciler
+	var chain string
+
+	BeforeEach(func() {
+		vpcTunnel = &kubeovnv1.VpcNatTunnel{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "ovn-gre0"}}
+		vpcTunnel.Status.InterfaceName = "mvpc-0123456789"
+		vpcTunnel.Spec.Ingress = []kubeovnv1.IngressRule{
+			{GlobalIngressIP: "web", InternalIP: "10.0.1.10", Protocol: "tcp", Port: 80, TargetPort: 8080},
+			{GlobalIngressIP: "pending", InternalIP: "10.0.1.11"},
+			{GlobalIP: "242.0.0.20", InternalIP: "10.0.1.12", Protocol: "udp"},
+		}
+		chain = tunnel.GenChainName("ns1", "ovn-gre0")
+
+		web := &Submariner.GlobalIngressIP{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "web"}}
+		web.Status.AllocatedIP = "242.0.0.10"
+		pending := &Submariner.GlobalIngressIP{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "pending"}}
+		scheme := runtime.NewScheme()
+		Expect(kubeovnv1.AddToScheme(scheme)).To(Succeed())
+		Expect(Submariner.AddToScheme(scheme)).To(Succeed())
+		reconciler = &VpcNatTunnelReconciler{
+			Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(web, pending, vpcTunnel).Build(),
+		}
+	})
+
+	It("should take the global IPs from the GlobalIngressIPs that have one", func() {
+		ingress, err := reconciler.resolveIngress(context.Background(), vpcTunnel)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ingress).To(HaveLen(2))
+		Expect(ingress[0].GlobalIP).To(Equal("242.0.0.10"))
+		Expect(ingress[1].GlobalIP).To(Equal("242.0.0.20"))
+
+		Expect(reconciler.globalIngressIPToTunnels(context.Background(), &Submariner.GlobalIngressIP{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "web"},
+		})).To(HaveLen(1))
+		Expect(reconciler.globalIngressIPToTunnels(context.Background(), &Submariner.GlobalIngressIP{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns2", Name: "web"},
+		})).To(BeEmpty())
+	})
+
+	It("should DNAT the global IPs with both backends", func() {
+		ingress, err := reconciler.resolveIngress(context.Background(), vpcTunnel)
+		Expect(err).NotTo(HaveOccurred())
+
+		cmd := genSnatOp(vpcTunnel, natfactory.IPTABLES, []string{"242.1.0.0/16"}, []string{"242.0.0.1"}, nil, ingress).CreateCmd()
+		Expect(cmd).To(ContainSubstring("iptables -t nat -A " + chain + "-IN -i mvpc-0123456789 -d 242.0.0.10 -p tcp --dport 80 -j DNAT --to-destination 10.0.1.10:8080"))
+		Expect(cmd).To(ContainSubstring("iptables -t nat -A " + chain + "-IN -i mvpc-0123456789 -d 242.0.0.20 -p udp -j DNAT --to-destination 10.0.1.12;"))
+
+		cmd = genSnatOp(vpcTunnel, natfactory.NFTABLES, []string{"242.1.0.0/16"}, []string{"242.0.0.1"}, nil, ingress).CreateCmd()
+		Expect(cmd).To(ContainSubstring("iifname \"mvpc-0123456789\" ip daddr 242.0.0.10 tcp dport 80 counter dnat to 10.0.1.10:8080"))
+		Expect(cmd).To(ContainSubstring("iifname \"mvpc-0123456789\" ip daddr 242.0.0.20 meta l4proto udp counter dnat to 10.0.1.12\n"))
+	})
+
+	It("should only rebuild the incoming chain when the ingress rules change", func() {
+		ingress, err := reconciler.resolveIngress(context.Background(), vpcTunnel)
+		Expect(err).NotTo(HaveOccurred())
+		previous := genSnatRule(vpcTunnel, []string{"242.1.0.0/16"}, []string{"242.0.0.1"}, nil, ingress[:1])
+		cmd := genSnatOp(vpcTunnel, natfactory.IPTABLES, []string{"242.1.0.0/16"}, []string{"242.0.0.1"}, nil, ingress).UpdateCmd(previous)
+		Expect(cmd).To(ContainSubstring("iptables -t nat -F " + chain + "-IN"))
+		Expect(cmd).NotTo(ContainSubstring("iptables -t nat -F " + chain + ";"))
+
+		// dropping the last rule removes the chain
+		cmd = genSnatOp(vpcTunnel, natfactory.IPTABLES, []string{"242.1.0.0/16"}, []string{"242.0.0.1"}, nil, nil).UpdateCmd(previous)
+		Expect(cmd).To(ContainSubstring("iptables -t nat -X " + chain + "-IN"))
+	})
+})
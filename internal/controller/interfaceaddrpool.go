@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	kubeovnv1 "multi-vpc/api/v1"
+	"multi-vpc/internal/globalip"
+	"multi-vpc/internal/tunnel"
+)
+
+//+kubebuilder:rbac:groups=kubeovn.ustc.io,resources=interfaceaddrpools,verbs=get;list;watch
+//+kubebuilder:rbac:groups=kubeovn.ustc.io,resources=interfaceaddrpools/status,verbs=get;update;patch
+
+const (
+	interfaceAddrPoolIndexKey = "spec.interfaceAddrPool"
+
+	defaultInterfacePrefixLength = 30
+)
+
+// allocateInterfaceBlock returns the point-to-point block of the tunnel key, allocating it in the pool status if it
+// has none yet. changed tells whether the status has to be written back.
+func allocateInterfaceBlock(pool *kubeovnv1.InterfaceAddrPool, key string) (block netip.Prefix, changed bool, err error) {
+	poolCIDR, err := netip.ParsePrefix(pool.Spec.CIDR)
+	if err != nil {
+		return netip.Prefix{}, false, fmt.Errorf("invalid CIDR %q in InterfaceAddrPool %s", pool.Spec.CIDR, pool.Name)
+	}
+	bits := pool.Spec.PrefixLength
+	if bits == 0 {
+		bits = defaultInterfacePrefixLength
+	}
+
+	var used []netip.Prefix
+	for _, a := range pool.Status.Allocations {
+		p, err := netip.ParsePrefix(a.CIDR)
+		if err != nil {
+			continue
+		}
+		if a.Tunnel == key {
+			return p, false, nil
+		}
+		used = append(used, p)
+	}
+	block, err = globalip.NextBlock(poolCIDR, bits, used)
+	if err != nil {
+		return netip.Prefix{}, false, err
+	}
+	pool.Status.Allocations = append(pool.Status.Allocations, kubeovnv1.InterfaceAddrAllocation{Tunnel: key, CIDR: block.String()})
+	return block, true, nil
+}
+
+// releaseInterfaceBlock removes the block of the tunnel key from the pool status and reports whether it had one
+func releaseInterfaceBlock(pool *kubeovnv1.InterfaceAddrPool, key string) bool {
+	allocations := pool.Status.Allocations[:0]
+	for _, a := range pool.Status.Allocations {
+		if a.Tunnel != key {
+			allocations = append(allocations, a)
+		}
+	}
+	released := len(allocations) != len(pool.Status.Allocations)
+	pool.Status.Allocations = allocations
+	return released
+}
+
+// pointToPointAddrs returns the two addresses of a block with its prefix length: both addresses of a /31, the two
+// host addresses of a /30
+func pointToPointAddrs(block netip.Prefix) (string, string) {
+	first := block.Masked().Addr()
+	if block.Bits() < 31 {
+		first = first.Next()
+	}
+	return netip.PrefixFrom(first, block.Bits()).String(), netip.PrefixFrom(first.Next(), block.Bits()).String()
+}
+
+// resolveInterfaceAddr allocates the interface block of a tunnel naming an InterfaceAddrPool and records both of its
+// addresses in the status, the first one for the tunnel, the second one for the peer. A block held in a pool the
+// tunnel no longer names is given back.
+func (r *VpcNatTunnelReconciler) resolveInterfaceAddr(ctx context.Context, vpcTunnel *kubeovnv1.VpcNatTunnel) error {
+	poolName := vpcTunnel.Spec.InterfaceAddrPool
+	if vpcTunnel.Status.InterfaceAddrPool != "" && vpcTunnel.Status.InterfaceAddrPool != poolName {
+		if err := r.releaseInterfaceAddr(ctx, vpcTunnel, vpcTunnel.Status.InterfaceAddrPool); err != nil {
+			return err
+		}
+		vpcTunnel.Status.InterfaceAddrPool, vpcTunnel.Status.AllocatedInterfaceAddr, vpcTunnel.Status.RemoteInterfaceAddr = "", "", ""
+		if err := r.Status().Update(ctx, vpcTunnel); err != nil {
+			return err
+		}
+	}
+	if poolName == "" {
+		return nil
+	}
+
+	var block netip.Prefix
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pool := &kubeovnv1.InterfaceAddrPool{}
+		err := r.Get(ctx, client.ObjectKey{Name: poolName}, pool)
+		if k8serrors.IsNotFound(err) {
+			return fmt.Errorf("InterfaceAddrPool %s not found", poolName)
+		}
+		if err != nil {
+			return err
+		}
+		var changed bool
+		block, changed, err = allocateInterfaceBlock(pool, tunnelKey(vpcTunnel))
+		if err != nil {
+			return fmt.Errorf("InterfaceAddrPool %s: %w", poolName, err)
+		}
+		if !changed {
+			return nil
+		}
+		return r.Status().Update(ctx, pool)
+	})
+	if err != nil {
+		return err
+	}
+
+	local, remote := pointToPointAddrs(block)
+	if vpcTunnel.Status.InterfaceAddrPool == poolName && vpcTunnel.Status.AllocatedInterfaceAddr == local && vpcTunnel.Status.RemoteInterfaceAddr == remote {
+		return nil
+	}
+	log.FromContext(ctx).Info("allocated interface address", "tunnel", tunnelKey(vpcTunnel), "interfaceAddr", local, "pool", poolName)
+	vpcTunnel.Status.InterfaceAddrPool, vpcTunnel.Status.AllocatedInterfaceAddr, vpcTunnel.Status.RemoteInterfaceAddr = poolName, local, remote
+	return r.Status().Update(ctx, vpcTunnel)
+}
+
+// specInterfaceAddr returns the interface address the tunnel asks for, set in the spec or allocated from its pool
+func specInterfaceAddr(vpcTunnel *kubeovnv1.VpcNatTunnel) string {
+	return tunnel.InterfaceAddr(vpcTunnel)
+}
+
+// releaseInterfaceAddr gives the interface block of the tunnel back to the named pool. A pool that is gone has
+// nothing to give back.
+func (r *VpcNatTunnelReconciler) releaseInterfaceAddr(ctx context.Context, vpcTunnel *kubeovnv1.VpcNatTunnel, poolName string) error {
+	if poolName == "" {
+		return nil
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pool := &kubeovnv1.InterfaceAddrPool{}
+		err := r.Get(ctx, client.ObjectKey{Name: poolName}, pool)
+		if err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if !releaseInterfaceBlock(pool, tunnelKey(vpcTunnel)) {
+			return nil
+		}
+		return r.Status().Update(ctx, pool)
+	})
+}
+
+// interfaceAddrPoolToTunnels maps an InterfaceAddrPool to the tunnels allocating from it
+func (r *VpcNatTunnelReconciler) interfaceAddrPoolToTunnels(ctx context.Context, obj client.Object) []reconcile.Request {
+	tunnels := &kubeovnv1.VpcNatTunnelList{}
+	if err := r.List(ctx, tunnels, client.MatchingFields{interfaceAddrPoolIndexKey: obj.GetName()}); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list vpcNatTunnels for pool", "interfaceAddrPool", obj.GetName())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(tunnels.Items))
+	for _, tunnel := range tunnels.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&tunnel)})
+	}
+	return requests
+}
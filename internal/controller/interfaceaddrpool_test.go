@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"context"
+	"net/netip"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kubeovnv1 "multi-vpc/api/v1"
+)
+
+var _ = Describe("Interface address pool", func() {
+	It("should hand out point-to-point pairs", func() {
+		local, remote := pointToPointAddrs(netip.MustParsePrefix("10.100.0.4/30"))
+		Expect([]string{local, remote}).To(Equal([]string{"10.100.0.5/30", "10.100.0.6/30"}))
+		local, remote = pointToPointAddrs(netip.MustParsePrefix("10.100.0.4/31"))
+		Expect([]string{local, remote}).To(Equal([]string{"10.100.0.4/31", "10.100.0.5/31"}))
+	})
+
+	It("should keep allocations stable and reuse released blocks", func() {
+		pool := &kubeovnv1.InterfaceAddrPool{ObjectMeta: metav1.ObjectMeta{Name: "p2p"}}
+		pool.Spec.CIDR = "10.100.0.0/29"
+
+		first, changed, err := allocateInterfaceBlock(pool, "ns1/t0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(first.String()).To(Equal("10.100.0.0/30"))
+		again, changed, err := allocateInterfaceBlock(pool, "ns1/t0")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(changed).To(BeFalse())
+		Expect(again).To(Equal(first))
+
+		second, _, err := allocateInterfaceBlock(pool, "ns1/t1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(second.String()).To(Equal("10.100.0.4/30"))
+		_, _, err = allocateInterfaceBlock(pool, "ns1/t2")
+		Expect(err).To(HaveOccurred())
+
+		Expect(releaseInterfaceBlock(pool, "ns1/t0")).To(BeTrue())
+		Expect(releaseInterfaceBlock(pool, "ns1/t0")).To(BeFalse())
+		third, _, err := allocateInterfaceBlock(pool, "ns1/t2")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(third).To(Equal(first))
+	})
+
+	It("should fill in the interface address and release it", func() {
+		ctx := context.Background()
+		scheme := runtime.NewScheme()
+		Expect(kubeovnv1.AddToScheme(scheme)).To(Succeed())
+		pool := &kubeovnv1.InterfaceAddrPool{ObjectMeta: metav1.ObjectMeta{Name: "p2p"}}
+		pool.Spec.CIDR = "10.100.0.0/24"
+		pool.Spec.PrefixLength = 31
+		vpcTunnel := &kubeovnv1.VpcNatTunnel{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "t0"}}
+		vpcTunnel.Spec.InterfaceAddrPool = "p2p"
+		reconciler := &VpcNatTunnelReconciler{
+			Client: fake.NewClientBuilder().WithScheme(scheme).
+				WithStatusSubresource(&kubeovnv1.VpcNatTunnel{}, &kubeovnv1.InterfaceAddrPool{}).
+				WithObjects(vpcTunnel).Build(),
+		}
+
+		Expect(reconciler.resolveInterfaceAddr(ctx, vpcTunnel)).To(MatchError(ContainSubstring("InterfaceAddrPool p2p not found")))
+		Expect(reconciler.Create(ctx, pool)).To(Succeed())
+		Expect(reconciler.resolveInterfaceAddr(ctx, vpcTunnel)).To(Succeed())
+
+		stored := &kubeovnv1.VpcNatTunnel{}
+		Expect(reconciler.Get(ctx, client.ObjectKeyFromObject(vpcTunnel), stored)).To(Succeed())
+		Expect(stored.Spec.InterfaceAddr).To(BeEmpty())
+		Expect(specInterfaceAddr(stored)).To(Equal("10.100.0.0/31"))
+		Expect(stored.Status.InterfaceAddrPool).To(Equal("p2p"))
+		Expect(stored.Status.RemoteInterfaceAddr).To(Equal("10.100.0.1/31"))
+		Expect(reconciler.Get(ctx, client.ObjectKeyFromObject(pool), pool)).To(Succeed())
+		Expect(pool.Status.Allocations).To(ConsistOf(kubeovnv1.InterfaceAddrAllocation{Tunnel: "ns1/t0", CIDR: "10.100.0.0/31"}))
+
+		// dropping the pool gives the block back
+		stored.Spec.InterfaceAddrPool = ""
+		Expect(reconciler.resolveInterfaceAddr(ctx, stored)).To(Succeed())
+		Expect(stored.Status.InterfaceAddrPool).To(BeEmpty())
+		Expect(stored.Status.AllocatedInterfaceAddr).To(BeEmpty())
+		Expect(reconciler.Get(ctx, client.ObjectKeyFromObject(pool), pool)).To(Succeed())
+		Expect(pool.Status.Allocations).To(BeEmpty())
+	})
+})
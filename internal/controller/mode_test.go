@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	Submariner "github.com/submariner-io/submariner/pkg/apis/submariner.io/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kubeovnv1 "multi-vpc/api/v1"
+	natfactory "multi-vpc/internal/nat/factory"
+	"multi-vpc/internal/tunnel"
+)
+
+var _ = Describe("Tunnel modes", func() {
+	var vpcTunnel *kubeovnv1.VpcNatTunnel
+	var chain string
+
+	BeforeEach(func() {
+		vpcTunnel = &kubeovnv1.VpcNatTunnel{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "ovn-gre0"}}
+		vpcTunnel.Spec.Mode = kubeovnv1.ModeOverlay
+		vpcTunnel.Spec.OverlayMappings = []kubeovnv1.OverlayMapping{{LocalCIDR: "10.0.1.0/24", OverlayCIDR: "172.31.1.0/24"}}
+		vpcTunnel.Status.InterfaceName = "mvpc-0123456789"
+		chain = tunnel.GenChainName("ns1", "ovn-gre0")
+	})
+
+	It("should not need Submariner outside globalnet mode", func() {
+		// the reconciler has no client, any lookup would panic
+		reconciler := &VpcNatTunnelReconciler{}
+		GlobalnetCIDR, GlobalEgressIP, err := reconciler.resolveGlobalnet(context.Background(), vpcTunnel, "vpc1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(GlobalnetCIDR).To(BeEmpty())
+		Expect(GlobalEgressIP).To(BeEmpty())
+
+		route := genPolicyRoute(vpcTunnel, []string{"10.1.0.0/16"}, nil, 0)
+		cmd := genGlobalnetRoute("", "10.0.1.1", route, genSnatOp(vpcTunnel, natfactory.IPTABLES, []string{"10.1.0.0/16"}, nil, nil, nil))
+		Expect(cmd).NotTo(ContainSubstring("dev eth0"))
+		Expect(cmd).To(HavePrefix(route.CreateCmd()))
+	})
+
+	It("should tell globalnet tunnels that Submariner is not installed", func() {
+		mapper := meta.NewDefaultRESTMapper(nil)
+		Expect(submarinerServed(mapper)).To(BeFalse())
+		mapper.Add(Submariner.SchemeGroupVersion.WithKind("Gateway"), meta.RESTScopeNamespace)
+		Expect(submarinerServed(mapper)).To(BeTrue())
+
+		scheme := runtime.NewScheme()
+		Expect(kubeovnv1.AddToScheme(scheme)).To(Succeed())
+		vpcTunnel.Spec.Mode = kubeovnv1.ModeGlobalnet
+		reconciler := &VpcNatTunnelReconciler{
+			Client:            fake.NewClientBuilder().WithScheme(scheme).WithStatusSubresource(vpcTunnel).WithObjects(vpcTunnel).Build(),
+			submarinerMissing: true,
+		}
+		_, _, err := reconciler.resolveGlobalnet(context.Background(), vpcTunnel, "vpc1")
+		Expect(err).To(MatchError(ContainSubstring("submariner.io/v1")))
+		condition := meta.FindStatusCondition(vpcTunnel.Status.Conditions, kubeovnv1.ConditionGlobalnetReady)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Status).To(Equal(metav1.ConditionFalse))
+		Expect(condition.Reason).To(Equal("SubmarinerNotInstalled"))
+
+		vpcTunnel.Spec.GlobalIPPool = "pool1"
+		vpcTunnel.Spec.EgressIPs = []string{"242.0.0.30"}
+		Expect(needsSubmariner(vpcTunnel)).To(BeFalse())
+	})
+
+	It("should map overlay ranges both ways with iptables", func() {
+		snat := genSnatOp(vpcTunnel, natfactory.IPTABLES, []string{"10.1.0.0/16"}, nil, vpcTunnel.Spec.OverlayMappings, nil)
+		cmd := snat.CreateCmd()
+		Expect(cmd).To(ContainSubstring("iptables -t nat -A " + chain + " -o mvpc-0123456789 -d 10.1.0.0/16 -s 10.0.1.0/24 -j NETMAP --to 172.31.1.0/24"))
+		Expect(cmd).To(ContainSubstring("iptables -t nat -A " + chain + "-IN -i mvpc-0123456789 -d 172.31.1.0/24 -j NETMAP --to 10.0.1.0/24"))
+		Expect(cmd).To(ContainSubstring("iptables -t nat -A PREROUTING -j " + chain + "-IN"))
+		Expect(snat.DeleteCmd()).To(ContainSubstring("iptables -t nat -X " + chain + "-IN"))
+
+		// dropping the mappings removes the incoming chain
+		previous := genSnatRule(vpcTunnel, []string{"10.1.0.0/16"}, nil, vpcTunnel.Spec.OverlayMappings, nil)
+		snat = genSnatOp(vpcTunnel, natfactory.IPTABLES, []string{"10.1.0.0/16"}, nil, nil, nil)
+		Expect(snat.UpdateCmd(previous)).To(ContainSubstring("iptables -t nat -D PREROUTING -j " + chain + "-IN"))
+	})
+
+	It("should map overlay ranges both ways with nftables", func() {
+		snat := genSnatOp(vpcTunnel, natfactory.NFTABLES, []string{"10.1.0.0/16"}, nil, vpcTunnel.Spec.OverlayMappings, nil)
+		cmd := snat.CreateCmd()
+		Expect(cmd).To(ContainSubstring("ip daddr 10.1.0.0/16 ip saddr 10.0.1.0/24 counter snat ip prefix to ip saddr map { 10.0.1.0/24 : 172.31.1.0/24 }"))
+		Expect(cmd).To(ContainSubstring("add chain ip multi-vpc " + chain + "-IN { type nat hook prerouting priority -100; policy accept; }"))
+		Expect(cmd).To(ContainSubstring("iifname \"mvpc-0123456789\" ip daddr 172.31.1.0/24 counter dnat ip prefix to ip daddr map { 172.31.1.0/24 : 10.0.1.0/24 }"))
+
+		previous := genSnatRule(vpcTunnel, []string{"10.1.0.0/16"}, nil, vpcTunnel.Spec.OverlayMappings, nil)
+		Expect(snat.UpdateCmd(previous)).To(BeEmpty())
+	})
+
+	It("should rebuild the tunnel when the mode changes", func() {
+		vpcTunnel.Status.Mode = kubeovnv1.ModeOverlay
+		vpcTunnel.Status.OverlayMappings = vpcTunnel.Spec.OverlayMappings
+		Expect(tunnelEndpointChanged(vpcTunnel)).To(BeFalse())
+
+		vpcTunnel.Spec.OverlayMappings = []kubeovnv1.OverlayMapping{{LocalCIDR: "10.0.1.0/24", OverlayCIDR: "172.31.2.0/24"}}
+		Expect(tunnelEndpointChanged(vpcTunnel)).To(BeTrue())
+
+		vpcTunnel.Spec.Mode = ""
+		vpcTunnel.Spec.OverlayMappings = nil
+		vpcTunnel.Status.Mode = ""
+		vpcTunnel.Status.OverlayMappings = nil
+		Expect(specMode(vpcTunnel)).To(Equal(kubeovnv1.ModeGlobalnet))
+		Expect(tunnelEndpointChanged(vpcTunnel)).To(BeFalse())
+	})
+})
@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	ovn "github.com/kubeovn/kube-ovn/pkg/apis/kubeovn/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	kubeovnv1 "multi-vpc/api/v1"
+	natfactory "multi-vpc/internal/nat/factory"
+	"multi-vpc/internal/options"
+)
+
+// detectNatBackendCmd prints the iptables variant of the gateway image and the path of nft, if installed
+const detectNatBackendCmd = "iptables -V 2>/dev/null; command -v nft 2>/dev/null || true"
+
+// selectNatBackend picks the backend for the SNAT rules of tunnels on the gateway: the gateway annotation
+// wins over the manager configuration, which is detected from the gateway image when set to auto.
+func (r *VpcNatTunnelReconciler) selectNatBackend(natGw *ovn.VpcNatGateway, pod *corev1.Pod) (string, error) {
+	backend, ok := natGw.Annotations[kubeovnv1.NatBackendAnnotation]
+	if !ok {
+		backend = r.opts().NatBackend
+	}
+	if backend == "" || backend == options.NatBackendAuto {
+		out, err := r.execCommandInPodOutput(pod.Name, pod.Namespace, r.opts().NatGwContainer, detectNatBackendCmd)
+		if err != nil {
+			return "", err
+		}
+		return detectNatBackend(out), nil
+	}
+	if !natfactory.IsRegistered(backend) {
+		return "", fmt.Errorf("unknown nat backend %q for gateway %s, must be one of %v", backend, natGw.Name, natfactory.RegisteredBackends())
+	}
+	return backend, nil
+}
+
+// detectNatBackend uses nftables on images whose iptables is iptables-nft and that ship nft, so the tunnel
+// rules never end up in the legacy tables next to nft ones. Everything else keeps iptables.
+func detectNatBackend(out string) string {
+	if strings.Contains(out, "(nf_tables)") && strings.Contains(out, "/nft") {
+		return natfactory.NFTABLES
+	}
+	return natfactory.IPTABLES
+}
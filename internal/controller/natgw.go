@@ -0,0 +1,250 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	ovn "github.com/kubeovn/kube-ovn/pkg/apis/kubeovn/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	kubeovnv1 "multi-vpc/api/v1"
+)
+
+//+kubebuilder:rbac:groups=kubeovn.io,resources=vpc-nat-gateways,verbs=get;list;watch
+
+const (
+	natGwMinBackoff = 2 * time.Second
+	natGwMaxBackoff = 5 * time.Minute
+)
+
+// natGwLocks serializes the reconciles of tunnels sharing a gateway. They decide from each other's state which
+// shared routes to keep and which leftovers to replay, see inFlowRouteShared, sharedVpcRoutes and collectLeftovers.
+type natGwLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// lock takes the locks of the given gateways in a fixed order and returns the function releasing them
+func (l *natGwLocks) lock(natGws ...string) func() {
+	natGws = slices.DeleteFunc(slices.Clone(natGws), func(natGw string) bool { return natGw == "" })
+	slices.Sort(natGws)
+	natGws = slices.Compact(natGws)
+
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = map[string]*sync.Mutex{}
+	}
+	held := make([]*sync.Mutex, 0, len(natGws))
+	for _, natGw := range natGws {
+		if l.locks[natGw] == nil {
+			l.locks[natGw] = &sync.Mutex{}
+		}
+		held = append(held, l.locks[natGw])
+	}
+	l.mu.Unlock()
+
+	for _, m := range held {
+		m.Lock()
+	}
+	return func() {
+		for i := len(held) - 1; i >= 0; i-- {
+			held[i].Unlock()
+		}
+	}
+}
+
+// natGwNotReadyError is returned when the gateway pod of a tunnel is missing, duplicated or not running.
+// Reconcile turns it into a delayed requeue instead of blocking the worker.
+type natGwNotReadyError struct {
+	natGw  string
+	reason string
+	err    error
+}
+
+func (e *natGwNotReadyError) Error() string {
+	return fmt.Sprintf("gateway %s is not ready: %v", e.natGw, e.err)
+}
+
+// Unwrap keeps k8serrors.IsNotFound working on a missing gateway pod
+func (e *natGwNotReadyError) Unwrap() error {
+	return e.err
+}
+
+// handleNatGwReadiness maps a gateway that is not ready to a requeue with exponential backoff and
+// reflects it in the GatewayReady condition. Any other result is passed through.
+func (r *VpcNatTunnelReconciler) handleNatGwReadiness(ctx context.Context, req ctrl.Request, vpcTunnel *kubeovnv1.VpcNatTunnel, result ctrl.Result, err error) (ctrl.Result, error) {
+	var notReady *natGwNotReadyError
+	if !errors.As(err, &notReady) {
+		if err == nil {
+			r.natGwBackoff.Forget(req)
+			if meta.IsStatusConditionFalse(vpcTunnel.Status.Conditions, kubeovnv1.ConditionGatewayReady) && vpcTunnel.DeletionTimestamp.IsZero() {
+				meta.SetStatusCondition(&vpcTunnel.Status.Conditions, metav1.Condition{
+					Type:               kubeovnv1.ConditionGatewayReady,
+					Status:             metav1.ConditionTrue,
+					Reason:             "PodRunning",
+					Message:            "gateway pod is running",
+					ObservedGeneration: vpcTunnel.Generation,
+				})
+				if err := r.Status().Update(ctx, vpcTunnel); err != nil {
+					return ctrl.Result{}, err
+				}
+			}
+		}
+		return result, err
+	}
+
+	delay := r.natGwBackoff.When(req)
+	ctrl.LoggerFrom(ctx).Info("gateway not ready, requeueing", "natGwDp", notReady.natGw, "reason", notReady.reason, "after", delay)
+	changed := meta.SetStatusCondition(&vpcTunnel.Status.Conditions, metav1.Condition{
+		Type:               kubeovnv1.ConditionGatewayReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             notReady.reason,
+		Message:            notReady.Error(),
+		ObservedGeneration: vpcTunnel.Generation,
+	})
+	if changed {
+		if err := r.Status().Update(ctx, vpcTunnel); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: delay}, nil
+}
+
+// getNatGw returns the kube-ovn VpcNatGateway with the given name
+func (r *VpcNatTunnelReconciler) getNatGw(ctx context.Context, name string) (*ovn.VpcNatGateway, error) {
+	gw := &ovn.VpcNatGateway{}
+	err := r.Get(ctx, client.ObjectKey{Name: name}, gw)
+	if k8serrors.IsNotFound(err) {
+		return nil, &natGwNotReadyError{natGw: name, reason: "NatGwNotFound", err: err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return gw, nil
+}
+
+// resolveNatGw looks up the kube-ovn VpcNatGateway named by a tunnel and the pod running it.
+// The pod is found through the selector of the gateway's StatefulSet rather than by label conventions.
+func (r *VpcNatTunnelReconciler) resolveNatGw(ctx context.Context, name string) (*ovn.VpcNatGateway, *corev1.Pod, error) {
+	gw, err := r.getNatGw(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sts := &appsv1.StatefulSet{}
+	err = r.Get(ctx, client.ObjectKey{Namespace: r.opts().KubeOvnNamespace, Name: r.opts().NatGwStsName(gw.Name)}, sts)
+	if k8serrors.IsNotFound(err) {
+		return gw, nil, &natGwNotReadyError{natGw: name, reason: "PodNotFound", err: err}
+	}
+	if err != nil {
+		return gw, nil, err
+	}
+	selector, err := metav1.LabelSelectorAsSelector(sts.Spec.Selector)
+	if err != nil {
+		return gw, nil, err
+	}
+
+	podList := &corev1.PodList{}
+	err = r.List(ctx, podList, client.InNamespace(sts.Namespace), client.MatchingLabelsSelector{Selector: selector})
+	if err != nil {
+		return gw, nil, err
+	}
+	pods := podList.Items
+	switch {
+	case len(pods) == 0:
+		return gw, nil, &natGwNotReadyError{natGw: name, reason: "PodNotFound", err: k8serrors.NewNotFound(corev1.Resource("pod"), name)}
+	case len(pods) != 1:
+		return gw, nil, &natGwNotReadyError{natGw: name, reason: "TooManyPods", err: fmt.Errorf("found %d gateway pods", len(pods))}
+	case pods[0].Status.Phase != corev1.PodRunning:
+		return gw, nil, &natGwNotReadyError{natGw: name, reason: "PodNotRunning", err: fmt.Errorf("pod %s is %s", pods[0].Name, pods[0].Status.Phase)}
+	}
+	return gw, &pods[0], nil
+}
+
+// getOvnGwIP returns the gateway address of the VPC subnet the gateway is attached to
+func (r *VpcNatTunnelReconciler) getOvnGwIP(ctx context.Context, gw *ovn.VpcNatGateway, pod *corev1.Pod) (string, error) {
+	if gw.Spec.Subnet == "" {
+		return r.getPodGwIP(pod)
+	}
+	subnet := &ovn.Subnet{}
+	err := r.Get(ctx, client.ObjectKey{Name: gw.Spec.Subnet}, subnet)
+	if err != nil {
+		return "", err
+	}
+	if subnet.Spec.Gateway == "" {
+		return "", fmt.Errorf("subnet %s has no gateway", subnet.Name)
+	}
+	// dual stack subnets list the IPv4 gateway first
+	return strings.Split(subnet.Spec.Gateway, ",")[0], nil
+}
+
+// networksAnnotation lists the network attachments of a pod as "<namespace>/<name>[@<interface>]"
+const networksAnnotation = "k8s.v1.cni.cncf.io/networks"
+
+// getNatGwExternIP returns the address of the gateway pod on its external network. kube-ovn annotates it
+// per external subnet and NetworkAttachmentDefinition, the configured annotation is used for gateways that do not
+// list theirs.
+func (r *VpcNatTunnelReconciler) getNatGwExternIP(gw *ovn.VpcNatGateway, pod *corev1.Pod) (string, error) {
+	for _, subnet := range gw.Spec.ExternalSubnets {
+		key := fmt.Sprintf("%s.%s.kubernetes.io/ip_address", subnet, r.externalNetworkNamespace(pod, subnet))
+		if ip, ok := pod.Annotations[key]; ok {
+			return ip, nil
+		}
+	}
+	return r.getGwExternIP(pod)
+}
+
+// externalNetworkNamespace returns the namespace of the NetworkAttachmentDefinition the pod attaches to the external
+// subnet through, which kube-ovn names after the subnet
+func (r *VpcNatTunnelReconciler) externalNetworkNamespace(pod *corev1.Pod, subnet string) string {
+	for _, network := range strings.Split(pod.Annotations[networksAnnotation], ",") {
+		network, _, _ = strings.Cut(strings.TrimSpace(network), "@")
+		if namespace, name, ok := strings.Cut(network, "/"); ok && name == subnet {
+			return namespace
+		}
+	}
+	return r.opts().ExternalNetworkNamespace
+}
+
+// setNatGwOwner makes the VpcNatGateway of the tunnel one of its owners, so the garbage collector deletes the
+// tunnel with its gateway. References to gateways the tunnel moved away from are dropped.
+func (r *VpcNatTunnelReconciler) setNatGwOwner(ctx context.Context, vpcTunnel *kubeovnv1.VpcNatTunnel, gw *ovn.VpcNatGateway) error {
+	refs := make([]metav1.OwnerReference, 0, len(vpcTunnel.OwnerReferences))
+	for _, ref := range vpcTunnel.OwnerReferences {
+		if ref.Kind == "VpcNatGateway" && ref.Name != gw.Name && strings.HasPrefix(ref.APIVersion, ovn.SchemeGroupVersion.Group+"/") {
+			continue
+		}
+		refs = append(refs, ref)
+	}
+	changed := len(refs) != len(vpcTunnel.OwnerReferences)
+	vpcTunnel.OwnerReferences = refs
+
+	owned := false
+	for _, ref := range refs {
+		if ref.UID == gw.UID {
+			owned = true
+		}
+	}
+	if !owned {
+		if err := controllerutil.SetOwnerReference(gw, vpcTunnel, r.Scheme); err != nil {
+			return err
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return r.Update(ctx, vpcTunnel)
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	ovn "github.com/kubeovn/kube-ovn/pkg/apis/kubeovn/v1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kubeovnv1 "multi-vpc/api/v1"
+	"multi-vpc/internal/options"
+)
+
+var _ = Describe("Gateway readiness", func() {
+	var (
+		ctx        context.Context
+		reconciler *VpcNatTunnelReconciler
+		tunnel     *kubeovnv1.VpcNatTunnel
+		req        ctrl.Request
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(kubeovnv1.AddToScheme(scheme)).To(Succeed())
+		tunnel = &kubeovnv1.VpcNatTunnel{ObjectMeta: metav1.ObjectMeta{Name: "t0", Namespace: "ns1"}}
+		reconciler = &VpcNatTunnelReconciler{
+			Client:       fake.NewClientBuilder().WithScheme(scheme).WithObjects(tunnel).WithStatusSubresource(tunnel).Build(),
+			natGwBackoff: workqueue.NewItemExponentialFailureRateLimiter(natGwMinBackoff, natGwMaxBackoff),
+		}
+		req = ctrl.Request{NamespacedName: types.NamespacedName{Name: "t0", Namespace: "ns1"}}
+	})
+
+	It("should requeue with growing delays while the gateway is not ready", func() {
+		notReady := &natGwNotReadyError{natGw: "gw1", reason: "PodNotRunning", err: fmt.Errorf("pod is Pending")}
+
+		result, err := reconciler.handleNatGwReadiness(ctx, req, tunnel, ctrl.Result{}, notReady)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(natGwMinBackoff))
+		Expect(meta.IsStatusConditionFalse(tunnel.Status.Conditions, kubeovnv1.ConditionGatewayReady)).To(BeTrue())
+
+		result, err = reconciler.handleNatGwReadiness(ctx, req, tunnel, ctrl.Result{}, notReady)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(2 * natGwMinBackoff))
+
+		_, err = reconciler.handleNatGwReadiness(ctx, req, tunnel, ctrl.Result{}, nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(meta.IsStatusConditionTrue(tunnel.Status.Conditions, kubeovnv1.ConditionGatewayReady)).To(BeTrue())
+		Expect(reconciler.natGwBackoff.NumRequeues(req)).To(BeZero())
+	})
+
+	It("should keep a missing gateway pod recognisable as not found", func() {
+		err := error(&natGwNotReadyError{natGw: "gw1", reason: "PodNotFound", err: k8serrors.NewNotFound(corev1.Resource("pod"), "gw1")})
+		Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+	})
+})
+
+var _ = Describe("Gateway resolution", func() {
+	var (
+		ctx        context.Context
+		reconciler *VpcNatTunnelReconciler
+		gw         *ovn.VpcNatGateway
+		tunnel     *kubeovnv1.VpcNatTunnel
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(kubeovnv1.AddToScheme(scheme)).To(Succeed())
+		Expect(ovn.AddToScheme(scheme)).To(Succeed())
+		gw = &ovn.VpcNatGateway{
+			ObjectMeta: metav1.ObjectMeta{Name: "gw1", UID: "gw1-uid"},
+			Spec:       ovn.VpcNatSpec{Vpc: "vpc1", Subnet: "net1", ExternalSubnets: []string{"ext1"}},
+		}
+		sts := &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "vpc-nat-gw-gw1", Namespace: "kube-system"},
+			Spec:       appsv1.StatefulSetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "gw1-pods"}}},
+		}
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "vpc-nat-gw-gw1-0",
+				Namespace:   "kube-system",
+				Labels:      map[string]string{"app": "gw1-pods"},
+				Annotations: map[string]string{"ext1.kube-system.kubernetes.io/ip_address": "172.18.0.10"},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+		subnet := &ovn.Subnet{ObjectMeta: metav1.ObjectMeta{Name: "net1"}, Spec: ovn.SubnetSpec{Gateway: "10.0.1.1,fd00::1"}}
+		tunnel = &kubeovnv1.VpcNatTunnel{
+			ObjectMeta: metav1.ObjectMeta{Name: "t0", Namespace: "ns1"},
+			Spec:       kubeovnv1.VpcNatTunnelSpec{NatGwDp: "gw1"},
+		}
+		reconciler = &VpcNatTunnelReconciler{
+			Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(gw, sts, pod, subnet, tunnel).Build(),
+			Scheme: scheme,
+		}
+	})
+
+	It("should find the gateway pod through its StatefulSet", func() {
+		natGw, pod, err := reconciler.resolveNatGw(ctx, "gw1")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.Name).To(Equal("vpc-nat-gw-gw1-0"))
+
+		ovnGwIP, err := reconciler.getOvnGwIP(ctx, natGw, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(ovnGwIP).To(Equal("10.0.1.1"))
+		externIP, err := reconciler.getNatGwExternIP(natGw, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(externIP).To(Equal("172.18.0.10"))
+	})
+
+	It("should take the namespace of the external network from the pod", func() {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name: "vpc-nat-gw-gw1-0",
+			Annotations: map[string]string{
+				"k8s.v1.cni.cncf.io/networks":               "ext-ns/ext1@net1",
+				"ext1.kube-system.kubernetes.io/ip_address": "172.18.0.9",
+				"ext1.ext-ns.kubernetes.io/ip_address":      "172.18.0.10",
+			},
+		}}
+		externIP, err := reconciler.getNatGwExternIP(gw, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(externIP).To(Equal("172.18.0.10"))
+
+		reconciler.Options = options.NewOptions()
+		reconciler.Options.ExternalNetworkNamespace = "ext-ns"
+		delete(pod.Annotations, "k8s.v1.cni.cncf.io/networks")
+		externIP, err = reconciler.getNatGwExternIP(gw, pod)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(externIP).To(Equal("172.18.0.10"))
+	})
+
+	It("should report a missing gateway as not found", func() {
+		_, _, err := reconciler.resolveNatGw(ctx, "gw2")
+		Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+		var notReady *natGwNotReadyError
+		Expect(errors.As(err, &notReady)).To(BeTrue())
+		Expect(notReady.reason).To(Equal("NatGwNotFound"))
+	})
+
+	It("should make the gateway own the tunnel", func() {
+		tunnel.OwnerReferences = []metav1.OwnerReference{{APIVersion: "kubeovn.io/v1", Kind: "VpcNatGateway", Name: "gw0", UID: "gw0-uid"}}
+		Expect(reconciler.setNatGwOwner(ctx, tunnel, gw)).To(Succeed())
+
+		updated := &kubeovnv1.VpcNatTunnel{}
+		Expect(reconciler.Get(ctx, types.NamespacedName{Name: "t0", Namespace: "ns1"}, updated)).To(Succeed())
+		Expect(updated.OwnerReferences).To(HaveLen(1))
+		Expect(updated.OwnerReferences[0].Name).To(Equal("gw1"))
+		Expect(updated.OwnerReferences[0].UID).To(Equal(gw.UID))
+	})
+})
+
+var _ = Describe("Gateway locks", func() {
+	It("should serialize tunnels of a shared gateway only", func() {
+		locks := &natGwLocks{}
+		unlock := locks.lock("gw2", "gw1", "")
+
+		other := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			locks.lock("gw3")()
+			close(other)
+		}()
+		Eventually(other).Should(BeClosed())
+
+		shared := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			locks.lock("gw1")()
+			close(shared)
+		}()
+		Consistently(shared, "50ms").ShouldNot(BeClosed())
+		unlock()
+		Eventually(shared).Should(BeClosed())
+	})
+})
@@ -0,0 +1,217 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	kubeovnv1 "multi-vpc/api/v1"
+	"multi-vpc/internal/options"
+	"multi-vpc/internal/tunnel/factory"
+	"multi-vpc/internal/tunnel/vxlan"
+)
+
+// Helpers shared by the VpcPeering and VpcTunnelMesh controllers, which provision VpcNatTunnels on several clusters
+
+// RemoteClientFunc builds the client of another cluster from its kubeconfig
+type RemoteClientFunc func(kubeconfig []byte, scheme *runtime.Scheme) (client.Client, error)
+
+// peeringError tells why one end of a peering cannot be provisioned yet, its reason goes into the Ready condition
+type peeringError struct {
+	reason string
+	err    error
+}
+
+func (e *peeringError) Error() string {
+	return e.err.Error()
+}
+
+func (e *peeringError) Unwrap() error {
+	return e.err
+}
+
+// remoteClients caches the clients of other clusters by kubeconfig Secret. Building one discovers the API of its
+// cluster, so it is only rebuilt when the Secret is replaced or changes.
+type remoteClients struct {
+	mu      sync.Mutex
+	clients map[types.NamespacedName]remoteClient
+}
+
+type remoteClient struct {
+	uid             types.UID
+	resourceVersion string
+	client          client.Client
+}
+
+// clusterClient returns the client of another cluster from the kubeconfig Secret namespace/name of the local one.
+// The Secret is read with reader, past the manager cache, which only holds the metadata of Secrets.
+func (rc *remoteClients) clusterClient(ctx context.Context, reader client.Reader, scheme *runtime.Scheme, newClient RemoteClientFunc, namespace, name string) (client.Client, error) {
+	key := types.NamespacedName{Namespace: namespace, Name: name}
+	secret := &corev1.Secret{}
+	err := reader.Get(ctx, key, secret)
+	if k8serrors.IsNotFound(err) {
+		return nil, &peeringError{reason: "KubeconfigNotFound", err: fmt.Errorf("kubeconfig Secret %s not found", name)}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if cached, ok := rc.clients[key]; ok && cached.uid == secret.UID && cached.resourceVersion == secret.ResourceVersion {
+		return cached.client, nil
+	}
+	kubeconfig, ok := secret.Data[kubeovnv1.KubeconfigSecretKey]
+	if !ok {
+		return nil, &peeringError{reason: "KubeconfigNotFound", err: fmt.Errorf("no %q key in Secret %s", kubeovnv1.KubeconfigSecretKey, secret.Name)}
+	}
+	if newClient == nil {
+		newClient = newRemoteClient
+	}
+	remote, err := newClient(kubeconfig, scheme)
+	if err != nil {
+		return nil, &peeringError{reason: "InvalidKubeconfig", err: fmt.Errorf("kubeconfig Secret %s: %w", secret.Name, err)}
+	}
+	if rc.clients == nil {
+		rc.clients = map[types.NamespacedName]remoteClient{}
+	}
+	rc.clients[key] = remoteClient{uid: secret.UID, resourceVersion: secret.ResourceVersion, client: remote}
+	return remote, nil
+}
+
+func newRemoteClient(kubeconfig []byte, scheme *runtime.Scheme) (client.Client, error) {
+	config, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	return client.New(config, client.Options{Scheme: scheme})
+}
+
+// resolvePeeringEnd fills in the gateway address and globalnet CIDR of one end, unless the latter is given. The
+// lookups of the tunnel controller only read objects, so they are run against the cluster of that end. side
+// prefixes the reasons of the errors and what names the end in their messages.
+func resolvePeeringEnd(ctx context.Context, c client.Client, opts *options.Options, side, what, natGwDp, globalnetCIDR string, end *kubeovnv1.PeeringEnd) error {
+	lookup := &VpcNatTunnelReconciler{Client: c, Options: opts}
+	gw, pod, err := lookup.resolveNatGw(ctx, natGwDp)
+	var notReady *natGwNotReadyError
+	if errors.As(err, &notReady) {
+		return &peeringError{reason: side + "GatewayNotReady", err: fmt.Errorf("%s: %w", what, err)}
+	}
+	if err != nil {
+		return err
+	}
+	end.GatewayIP, err = lookup.getNatGwExternIP(gw, pod)
+	if err != nil {
+		return &peeringError{reason: side + "GatewayNotReady", err: fmt.Errorf("%s: %w", what, err)}
+	}
+
+	if globalnetCIDR == "" {
+		globalnetCIDR, _, err = lookup.getGlobalnetCIDR(ctx)
+		var unavailable *globalnetError
+		if errors.As(err, &unavailable) {
+			return &peeringError{reason: side + "GlobalnetUnavailable", err: fmt.Errorf("%s: %w", what, err)}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	end.GlobalnetCIDR = globalnetCIDR
+	return nil
+}
+
+// syncManagedTunnel creates or updates the tunnel key of one end, labelled label=owner. Tunnels that exist without
+// that label were not made by the owner and are left alone. The type and the vxlan labels of a tunnel are only read
+// when its interface is created, so a tunnel that has to change them is deleted, and created again once its
+// teardown is done.
+func syncManagedTunnel(ctx context.Context, c client.Client, key types.NamespacedName, label, owner string, mutate func(*kubeovnv1.VpcNatTunnel) error) error {
+	vpcTunnel := &kubeovnv1.VpcNatTunnel{}
+	err := c.Get(ctx, key, vpcTunnel)
+	switch {
+	case k8serrors.IsNotFound(err):
+	case err != nil:
+		return err
+	case vpcTunnel.Labels[label] != owner:
+		return &peeringError{reason: "TunnelExists", err: fmt.Errorf("VpcNatTunnel %s already exists and is not managed by %s", key, owner)}
+	case !vpcTunnel.DeletionTimestamp.IsZero():
+		return &peeringError{reason: "TunnelRecreating", err: fmt.Errorf("VpcNatTunnel %s is being deleted, it is created again once it is gone", key)}
+	default:
+		desired := vpcTunnel.DeepCopy()
+		if err := mutate(desired); err != nil {
+			return err
+		}
+		if recreateNeeded(vpcTunnel, desired) {
+			if err := client.IgnoreNotFound(c.Delete(ctx, vpcTunnel)); err != nil {
+				return err
+			}
+			return &peeringError{reason: "TunnelRecreating", err: fmt.Errorf("VpcNatTunnel %s is recreated with type %s", key, desired.Spec.Type)}
+		}
+	}
+
+	vpcTunnel = &kubeovnv1.VpcNatTunnel{ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name}}
+	_, err = controllerutil.CreateOrUpdate(ctx, c, vpcTunnel, func() error {
+		if vpcTunnel.ResourceVersion != "" && vpcTunnel.Labels[label] != owner {
+			return &peeringError{reason: "TunnelExists", err: fmt.Errorf("VpcNatTunnel %s already exists and is not managed by %s", key, owner)}
+		}
+		if vpcTunnel.Labels == nil {
+			vpcTunnel.Labels = map[string]string{}
+		}
+		vpcTunnel.Labels[label] = owner
+		return mutate(vpcTunnel)
+	})
+	return err
+}
+
+// recreateNeeded reports whether going from current to desired changes what the webhook does not let change
+func recreateNeeded(current, desired *kubeovnv1.VpcNatTunnel) bool {
+	if current.Spec.Type != desired.Spec.Type {
+		return true
+	}
+	if desired.Spec.Type != factory.VXLAN {
+		return false
+	}
+	for _, label := range []string{vxlan.VidLabel, vxlan.PortLabel} {
+		if current.Labels[label] != desired.Labels[label] {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteManagedTunnel deletes the tunnel key if it carries label=owner
+func deleteManagedTunnel(ctx context.Context, c client.Client, key types.NamespacedName, label, owner string) error {
+	vpcTunnel := &kubeovnv1.VpcNatTunnel{}
+	err := c.Get(ctx, key, vpcTunnel)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if vpcTunnel.Labels[label] != owner {
+		return nil
+	}
+	return client.IgnoreNotFound(c.Delete(ctx, vpcTunnel))
+}
+
+// transitAddrs returns the interface addresses of the two ends of a tunnel: the first two host addresses of the
+// transit CIDR, with its prefix length
+func transitAddrs(transitCIDR string) (string, string, error) {
+	prefix, err := netip.ParsePrefix(transitCIDR)
+	if err != nil || !prefix.Addr().Is4() {
+		return "", "", fmt.Errorf("invalid transit CIDR %q", transitCIDR)
+	}
+	if prefix.Bits() > 30 {
+		return "", "", fmt.Errorf("transit CIDR %s holds less than two host addresses", transitCIDR)
+	}
+	first := prefix.Masked().Addr().Next()
+	second := first.Next()
+	return netip.PrefixFrom(first, prefix.Bits()).String(), netip.PrefixFrom(second, prefix.Bits()).String(), nil
+}
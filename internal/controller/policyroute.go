@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	kubeovnv1 "multi-vpc/api/v1"
+	"multi-vpc/internal/cidr"
+	"multi-vpc/internal/tunnel"
+)
+
+// policyRulePriority places the tunnel rules before the main table (32766). Rules of tunnels on one gateway
+// never match the same traffic, see findConflicts, so they can share a priority.
+const policyRulePriority = 10000
+
+// policyRoute sends traffic selected by destination, and optionally source and fwmark, through a tunnel
+// by way of a routing table of its own. Every remote prefix gets a route, and a rule per source subnet.
+type policyRoute struct {
+	Table       int
+	Dev         string
+	RemoteCIDRs []string
+	SourceCIDRs []string
+	FwMark      uint32
+}
+
+// genPolicyRoute returns the policy route of the tunnel for the given spec values
+func genPolicyRoute(vpcTunnel *kubeovnv1.VpcNatTunnel, RemoteCIDRs, SourceCIDRs []string, FwMark uint32) policyRoute {
+	return policyRoute{
+		Table:       routeTable(vpcTunnel),
+		Dev:         vpcTunnel.Status.InterfaceName,
+		RemoteCIDRs: RemoteCIDRs,
+		SourceCIDRs: SourceCIDRs,
+		FwMark:      FwMark,
+	}
+}
+
+// routeTable returns the recorded routing table of the tunnel, or the one it is about to get
+func routeTable(t *kubeovnv1.VpcNatTunnel) int {
+	if t.Status.RouteTable != 0 {
+		return t.Status.RouteTable
+	}
+	return tunnel.GenRouteTable(t.Namespace, t.Name)
+}
+
+// selectorsOverlap reports whether some packet would match the rules of both tunnels
+func selectorsOverlap(a, b *kubeovnv1.VpcNatTunnel) bool {
+	if !anyCidrsOverlap(specRemoteCIDRs(a), specRemoteCIDRs(b)) {
+		return false
+	}
+	if len(a.Spec.SourceCIDRs) != 0 && len(b.Spec.SourceCIDRs) != 0 && !anyCidrsOverlap(a.Spec.SourceCIDRs, b.Spec.SourceCIDRs) {
+		return false
+	}
+	if a.Spec.FwMark != 0 && b.Spec.FwMark != 0 && a.Spec.FwMark != b.Spec.FwMark {
+		return false
+	}
+	return true
+}
+
+// CreateCmd replaces the rules and routes of the table
+func (p policyRoute) CreateCmd() string {
+	cmds := []string{p.DeleteCmd()}
+	for _, remote := range p.RemoteCIDRs {
+		cmds = append(cmds, p.routeCmd("add", remote))
+	}
+	for _, selector := range p.selectors() {
+		cmds = append(cmds, "ip rule add "+selector)
+	}
+	return strings.Join(cmds, ";")
+}
+
+// UpdateCmd adds and removes the routes and rules of the prefixes that changed since previous was installed.
+// The table is rebuilt if the interface, table or fwmark changed. It returns an empty string if there is
+// nothing to change.
+func (p policyRoute) UpdateCmd(previous policyRoute) string {
+	if previous.Table != p.Table || previous.Dev != p.Dev || previous.FwMark != p.FwMark {
+		return p.CreateCmd()
+	}
+	var cmds []string
+	addedRules, removedRules := cidr.Diff(previous.selectors(), p.selectors())
+	for _, selector := range removedRules {
+		cmds = append(cmds, fmt.Sprintf("ip rule del %s 2>/dev/null || true", selector))
+	}
+	addedRoutes, removedRoutes := cidr.Diff(previous.RemoteCIDRs, p.RemoteCIDRs)
+	for _, remote := range removedRoutes {
+		cmds = append(cmds, p.routeCmd("del", remote)+" 2>/dev/null || true")
+	}
+	for _, remote := range addedRoutes {
+		cmds = append(cmds, p.routeCmd("replace", remote))
+	}
+	for _, selector := range addedRules {
+		cmds = append(cmds, "ip rule add "+selector)
+	}
+	return strings.Join(cmds, ";")
+}
+
+// DeleteCmd removes every rule pointing at the table and flushes it, whatever selectors they were created with
+func (p policyRoute) DeleteCmd() string {
+	return fmt.Sprintf("while ip rule del lookup %d 2>/dev/null; do :; done;ip route flush table %d 2>/dev/null || true", p.Table, p.Table)
+}
+
+func (p policyRoute) routeCmd(verb, remote string) string {
+	return fmt.Sprintf("ip route %s %s dev %s table %d", verb, remote, p.Dev, p.Table)
+}
+
+// selectors returns the arguments of the ip rules of the route, one per remote prefix and source subnet
+func (p policyRoute) selectors() []string {
+	sources := p.SourceCIDRs
+	if len(sources) == 0 {
+		sources = []string{""}
+	}
+	var selectors []string
+	for _, remote := range p.RemoteCIDRs {
+		for _, source := range sources {
+			selector := "to " + remote
+			if source != "" {
+				selector += " from " + source
+			}
+			if p.FwMark != 0 {
+				selector += fmt.Sprintf(" fwmark %#x", p.FwMark)
+			}
+			selectors = append(selectors, selector+fmt.Sprintf(" lookup %d priority %d", p.Table, policyRulePriority))
+		}
+	}
+	return selectors
+}
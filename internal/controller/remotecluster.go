@@ -0,0 +1,194 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+
+	Submariner "github.com/submariner-io/submariner/pkg/apis/submariner.io/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	kubeovnv1 "multi-vpc/api/v1"
+)
+
+//+kubebuilder:rbac:groups=submariner.io,resources=endpoints,verbs=get;list;watch
+//+kubebuilder:rbac:groups=submariner.io,resources=clusters,verbs=get;list;watch
+
+// remoteClusterIDIndexKey indexes VpcNatTunnels by Spec.RemoteClusterID
+const remoteClusterIDIndexKey = "spec.remoteClusterID"
+
+// remoteEndpointError tells why the peer of a tunnel could not be resolved from Submariner, its reason goes into
+// the RemoteEndpointReady condition
+type remoteEndpointError struct {
+	reason  string
+	message string
+}
+
+func (e *remoteEndpointError) Error() string {
+	return e.message
+}
+
+// getRemoteGlobalnetCIDR returns the globalnet CIDR of cluster clusterID, read from the Endpoint and Cluster the
+// broker syncs into the Submariner namespace. The addresses of the Endpoint are those of the Submariner gateway node,
+// not of the peer vpc-nat-gw, whose external address lives in the network namespace of its pod and is not published
+// through the broker. The remote IP of the tunnel is therefore not taken from it.
+func (r *VpcNatTunnelReconciler) getRemoteGlobalnetCIDR(ctx context.Context, clusterID string) (string, error) {
+	endpointList := &Submariner.EndpointList{}
+	err := r.List(ctx, endpointList, client.InNamespace(r.opts().SubmarinerNamespace))
+	if err != nil {
+		return "", err
+	}
+	var endpoints []Submariner.Endpoint
+	for _, endpoint := range endpointList.Items {
+		if endpoint.Spec.ClusterID == clusterID {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].Name < endpoints[j].Name })
+	switch len(endpoints) {
+	case 0:
+		return "", &remoteEndpointError{
+			reason:  "EndpointNotFound",
+			message: fmt.Sprintf("no Submariner Endpoint of cluster %s in namespace %s", clusterID, r.opts().SubmarinerNamespace),
+		}
+	case 1:
+	default:
+		// only the active gateway of a cluster is synced, several show up while it fails over
+		return "", &remoteEndpointError{
+			reason:  "SeveralEndpoints",
+			message: fmt.Sprintf("%d Submariner Endpoints of cluster %s, waiting for the failover to settle", len(endpoints), clusterID),
+		}
+	}
+	endpoint := endpoints[0]
+
+	configured := ""
+	cluster := &Submariner.Cluster{}
+	err = r.Get(ctx, client.ObjectKey{Namespace: r.opts().SubmarinerNamespace, Name: clusterID}, cluster)
+	if client.IgnoreNotFound(err) != nil {
+		return "", err
+	}
+	if err == nil && len(cluster.Spec.GlobalCIDR) != 0 {
+		configured = cluster.Spec.GlobalCIDR[0]
+	}
+	GlobalnetCIDR, err := selectGlobalnetSubnet(endpoint.Spec.Subnets, configured)
+	if err != nil {
+		return "", &remoteEndpointError{
+			reason:  "NoMatchingSubnet",
+			message: fmt.Sprintf("Submariner Endpoint %s: %v", endpoint.Name, err),
+		}
+	}
+	return GlobalnetCIDR, nil
+}
+
+// resolveRemoteCluster reads the globalnet CIDR of the RemoteClusterID of a tunnel and records it in the status when
+// it moved, which makes the rest of the reconcile rebuild the tunnel. The last CIDR read stays in use while the
+// Endpoint cannot be found. The outcome is recorded in the RemoteEndpointReady condition.
+func (r *VpcNatTunnelReconciler) resolveRemoteCluster(ctx context.Context, vpcTunnel *kubeovnv1.VpcNatTunnel) error {
+	if vpcTunnel.Spec.RemoteClusterID == "" {
+		removed := meta.RemoveStatusCondition(&vpcTunnel.Status.Conditions, kubeovnv1.ConditionRemoteEndpointReady)
+		if removed || vpcTunnel.Status.ResolvedRemoteGlobalnetCIDR != "" {
+			vpcTunnel.Status.ResolvedRemoteGlobalnetCIDR = ""
+			return r.Status().Update(ctx, vpcTunnel)
+		}
+		return nil
+	}
+
+	var GlobalnetCIDR string
+	var err error
+	if r.submarinerMissing {
+		err = &remoteEndpointError{
+			reason:  "SubmarinerNotInstalled",
+			message: "the cluster does not serve the submariner.io/v1 API, set remoteGlobalnetCIDR instead of remoteClusterID",
+		}
+	} else {
+		GlobalnetCIDR, err = r.getRemoteGlobalnetCIDR(ctx, vpcTunnel.Spec.RemoteClusterID)
+	}
+	condition := metav1.Condition{
+		Type:               kubeovnv1.ConditionRemoteEndpointReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "EndpointFound",
+		Message:            fmt.Sprintf("cluster %s has globalnet CIDR %s", vpcTunnel.Spec.RemoteClusterID, GlobalnetCIDR),
+		ObservedGeneration: vpcTunnel.Generation,
+	}
+	var resolveErr *remoteEndpointError
+	switch {
+	case errors.As(err, &resolveErr):
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = resolveErr.reason
+		condition.Message = resolveErr.message
+	case err != nil:
+		return err
+	}
+
+	changed := meta.SetStatusCondition(&vpcTunnel.Status.Conditions, condition)
+	if err == nil && vpcTunnel.Status.ResolvedRemoteGlobalnetCIDR != GlobalnetCIDR {
+		log.FromContext(ctx).Info("remote globalnet CIDR moved", "tunnel", tunnelKey(vpcTunnel), "remoteGlobalnetCIDR", GlobalnetCIDR)
+		vpcTunnel.Status.ResolvedRemoteGlobalnetCIDR = GlobalnetCIDR
+		changed = true
+	}
+	if changed {
+		if err := r.Status().Update(ctx, vpcTunnel); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// specRemoteGlobalnetCIDR returns the globalnet CIDR of the peer the spec asks for, set by hand or resolved from
+// its RemoteClusterID
+func specRemoteGlobalnetCIDR(vpcTunnel *kubeovnv1.VpcNatTunnel) string {
+	if vpcTunnel.Spec.RemoteClusterID != "" {
+		return vpcTunnel.Status.ResolvedRemoteGlobalnetCIDR
+	}
+	return vpcTunnel.Spec.RemoteGlobalnetCIDR
+}
+
+// remoteEndpointChanged passes the Endpoints of the Submariner namespace, and only the updates of the fields the
+// tunnels read from them
+func (r *VpcNatTunnelReconciler) remoteEndpointChanged() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool { return e.Object.GetNamespace() == r.opts().SubmarinerNamespace },
+		DeleteFunc: func(e event.DeleteEvent) bool { return e.Object.GetNamespace() == r.opts().SubmarinerNamespace },
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.ObjectNew.GetNamespace() != r.opts().SubmarinerNamespace {
+				return false
+			}
+			oldObj, okOld := e.ObjectOld.(*Submariner.Endpoint)
+			newObj, okNew := e.ObjectNew.(*Submariner.Endpoint)
+			if !okOld || !okNew {
+				return false
+			}
+			return oldObj.Spec.ClusterID != newObj.Spec.ClusterID || !slices.Equal(oldObj.Spec.Subnets, newObj.Spec.Subnets)
+		},
+		GenericFunc: func(e event.GenericEvent) bool { return false },
+	}
+}
+
+// remoteEndpointToTunnels maps a Submariner Endpoint to the tunnels peering with its cluster
+func (r *VpcNatTunnelReconciler) remoteEndpointToTunnels(ctx context.Context, obj client.Object) []reconcile.Request {
+	endpoint, ok := obj.(*Submariner.Endpoint)
+	if !ok || endpoint.Spec.ClusterID == "" {
+		return nil
+	}
+	tunnels := &kubeovnv1.VpcNatTunnelList{}
+	if err := r.List(ctx, tunnels, client.MatchingFields{remoteClusterIDIndexKey: endpoint.Spec.ClusterID}); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list vpcNatTunnels for Endpoint", "endpoint", endpoint.Name)
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(tunnels.Items))
+	for _, tunnel := range tunnels.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: tunnel.Namespace, Name: tunnel.Name},
+		})
+	}
+	return requests
+}
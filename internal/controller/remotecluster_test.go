@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	Submariner "github.com/submariner-io/submariner/pkg/apis/submariner.io/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	kubeovnv1 "multi-vpc/api/v1"
+)
+
+var _ = Describe("Remote endpoint discovery", func() {
+	var (
+		ctx        context.Context
+		reconciler *VpcNatTunnelReconciler
+		vpcTunnel  *kubeovnv1.VpcNatTunnel
+		endpoint   *Submariner.Endpoint
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(kubeovnv1.AddToScheme(scheme)).To(Succeed())
+		Expect(Submariner.AddToScheme(scheme)).To(Succeed())
+		vpcTunnel = &kubeovnv1.VpcNatTunnel{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "t0"}}
+		vpcTunnel.Spec.RemoteClusterID = "cluster2"
+		vpcTunnel.Spec.RemoteIP = "172.20.0.5"
+		endpoint = &Submariner.Endpoint{ObjectMeta: metav1.ObjectMeta{Namespace: "submariner-operator", Name: "cluster2-submariner-cable-cluster2-172-19-0-2"}}
+		endpoint.Spec.ClusterID = "cluster2"
+		endpoint.Spec.PrivateIP = "172.19.0.2"
+		endpoint.Spec.PublicIP = "203.0.113.2"
+		endpoint.Spec.Subnets = []string{"242.1.0.0/16"}
+		cluster := &Submariner.Cluster{ObjectMeta: metav1.ObjectMeta{Namespace: "submariner-operator", Name: "cluster2"}}
+		cluster.Spec.ClusterID = "cluster2"
+		cluster.Spec.GlobalCIDR = []string{"242.1.0.0/16"}
+		reconciler = &VpcNatTunnelReconciler{
+			Client: fake.NewClientBuilder().WithScheme(scheme).
+				WithStatusSubresource(&kubeovnv1.VpcNatTunnel{}).
+				WithObjects(vpcTunnel, cluster).
+				WithIndex(&kubeovnv1.VpcNatTunnel{}, remoteClusterIDIndexKey, func(obj client.Object) []string {
+					return []string{obj.(*kubeovnv1.VpcNatTunnel).Spec.RemoteClusterID}
+				}).Build(),
+		}
+	})
+
+	It("should wait for the Endpoint of the remote cluster", func() {
+		err := reconciler.resolveRemoteCluster(ctx, vpcTunnel)
+		Expect(err).To(MatchError(ContainSubstring("no Submariner Endpoint of cluster cluster2")))
+		condition := meta.FindStatusCondition(vpcTunnel.Status.Conditions, kubeovnv1.ConditionRemoteEndpointReady)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Reason).To(Equal("EndpointNotFound"))
+		Expect(specRemoteGlobalnetCIDR(vpcTunnel)).To(BeEmpty())
+	})
+
+	It("should fill in and follow the remote globalnet CIDR", func() {
+		Expect(reconciler.Create(ctx, endpoint)).To(Succeed())
+		Expect(reconciler.resolveRemoteCluster(ctx, vpcTunnel)).To(Succeed())
+		stored := &kubeovnv1.VpcNatTunnel{}
+		Expect(reconciler.Get(ctx, client.ObjectKeyFromObject(vpcTunnel), stored)).To(Succeed())
+		Expect(stored.Spec.RemoteGlobalnetCIDR).To(BeEmpty())
+		Expect(stored.Status.ResolvedRemoteGlobalnetCIDR).To(Equal("242.1.0.0/16"))
+		Expect(specRemoteCIDRs(stored)).To(Equal([]string{"242.1.0.0/16"}))
+		// the Endpoint addresses are those of the Submariner gateway node, not of the peer vpc-nat-gw
+		Expect(stored.Spec.RemoteIP).To(Equal("172.20.0.5"))
+		Expect(meta.IsStatusConditionTrue(stored.Status.Conditions, kubeovnv1.ConditionRemoteEndpointReady)).To(BeTrue())
+		Expect(reconciler.remoteEndpointToTunnels(ctx, endpoint)).To(HaveLen(1))
+
+		updated := endpoint.DeepCopy()
+		updated.Spec.NATEnabled = true
+		Expect(reconciler.remoteEndpointChanged().Update(event.UpdateEvent{ObjectOld: endpoint, ObjectNew: updated})).To(BeFalse())
+		updated.Spec.Subnets = []string{"242.2.0.0/16"}
+		Expect(reconciler.remoteEndpointChanged().Update(event.UpdateEvent{ObjectOld: endpoint, ObjectNew: updated})).To(BeTrue())
+
+		// the CIDR last read stays in use while the Endpoint is gone
+		Expect(reconciler.Delete(ctx, endpoint)).To(Succeed())
+		Expect(reconciler.resolveRemoteCluster(ctx, stored)).To(HaveOccurred())
+		Expect(specRemoteGlobalnetCIDR(stored)).To(Equal("242.1.0.0/16"))
+	})
+
+	It("should ignore Endpoint updates that change nothing the tunnels use", func() {
+		updated := endpoint.DeepCopy()
+		updated.Spec.Hostname = "node2"
+		Expect(reconciler.remoteEndpointChanged().Update(event.UpdateEvent{ObjectOld: endpoint, ObjectNew: updated})).To(BeFalse())
+	})
+
+	It("should wait while several Endpoints of the cluster are synced", func() {
+		Expect(reconciler.Create(ctx, endpoint)).To(Succeed())
+		second := endpoint.DeepCopy()
+		second.ResourceVersion = ""
+		second.Name = "cluster2-submariner-cable-cluster2-172-19-0-3"
+		Expect(reconciler.Create(ctx, second)).To(Succeed())
+		err := reconciler.resolveRemoteCluster(ctx, vpcTunnel)
+		Expect(err).To(HaveOccurred())
+		Expect(meta.FindStatusCondition(vpcTunnel.Status.Conditions, kubeovnv1.ConditionRemoteEndpointReady).Reason).To(Equal("SeveralEndpoints"))
+	})
+})
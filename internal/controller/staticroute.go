@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	ovn "github.com/kubeovn/kube-ovn/pkg/apis/kubeovn/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	kubeovnv1 "multi-vpc/api/v1"
+	"multi-vpc/internal/cidr"
+)
+
+//+kubebuilder:rbac:groups=kubeovn.io,resources=vpcs,verbs=get;list;watch;update;patch
+
+// syncVpcRoute routes the remote prefixes of the tunnel from its VPC to the LAN IP of its gateway.
+// The routes recorded in status are removed first if the tunnel moved to another VPC or gateway, otherwise only
+// the prefixes dropped from the spec are.
+func (r *VpcNatTunnelReconciler) syncVpcRoute(ctx context.Context, vpcTunnel *kubeovnv1.VpcNatTunnel, natGw *ovn.VpcNatGateway) error {
+	if natGw.Spec.LanIP == "" {
+		return fmt.Errorf("gateway %s has no lanIp", natGw.Name)
+	}
+	stale := statusRemoteCIDRs(vpcTunnel)
+	if vpcTunnel.Status.Vpc == natGw.Spec.Vpc && vpcTunnel.Status.LanIP == natGw.Spec.LanIP {
+		_, stale = cidr.Diff(stale, specRemoteCIDRs(vpcTunnel))
+	}
+	err := r.removeVpcRoutes(ctx, vpcTunnel, vpcTunnel.Status.Vpc, stale, vpcTunnel.Status.LanIP)
+	if err != nil {
+		return err
+	}
+	return r.addVpcRoutes(ctx, natGw.Spec.Vpc, specRemoteCIDRs(vpcTunnel), natGw.Spec.LanIP)
+}
+
+// addVpcRoutes adds a destination route for each of cidrs via nextHop to the VPC unless it is already there, and
+// records the ones it added in the TunnelRoutesAnnotation of the VPC. kube-ovn writes the VPC too, so conflicting
+// writes are retried.
+func (r *VpcNatTunnelReconciler) addVpcRoutes(ctx context.Context, vpcName string, cidrs []string, nextHop string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		vpc := &ovn.Vpc{}
+		err := r.Get(ctx, client.ObjectKey{Name: vpcName}, vpc)
+		if err != nil {
+			return err
+		}
+		managed := managedVpcRoutes(vpc)
+		changed := false
+		for _, prefix := range cidrs {
+			if findVpcRoute(vpc.Spec.StaticRoutes, prefix, nextHop) >= 0 {
+				continue
+			}
+			vpc.Spec.StaticRoutes = append(vpc.Spec.StaticRoutes, &ovn.StaticRoute{
+				Policy:    ovn.PolicyDst,
+				CIDR:      prefix,
+				NextHopIP: nextHop,
+			})
+			if route := vpcRouteKey(prefix, nextHop); !slices.Contains(managed, route) {
+				managed = append(managed, route)
+			}
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+		setManagedVpcRoutes(vpc, managed)
+		return r.Update(ctx, vpc)
+	})
+}
+
+// removeVpcRoutes removes the routes added by addVpcRoutes for the tunnel. Routes that were not added by the
+// operator, that another tunnel provisioned on the same VPC and LAN IP still routes, or whose VPC is gone, are
+// left alone.
+func (r *VpcNatTunnelReconciler) removeVpcRoutes(ctx context.Context, vpcTunnel *kubeovnv1.VpcNatTunnel, vpcName string, cidrs []string, nextHop string) error {
+	if vpcName == "" || len(cidrs) == 0 || nextHop == "" {
+		return nil
+	}
+	shared, err := r.sharedVpcRoutes(ctx, vpcTunnel, vpcName, nextHop)
+	if err != nil {
+		return err
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		vpc := &ovn.Vpc{}
+		err := r.Get(ctx, client.ObjectKey{Name: vpcName}, vpc)
+		if err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		managed := managedVpcRoutes(vpc)
+		changed := false
+		for _, prefix := range cidrs {
+			route := vpcRouteKey(prefix, nextHop)
+			if shared[prefix] || !slices.Contains(managed, route) {
+				continue
+			}
+			managed = slices.DeleteFunc(managed, func(m string) bool { return m == route })
+			if i := findVpcRoute(vpc.Spec.StaticRoutes, prefix, nextHop); i >= 0 {
+				vpc.Spec.StaticRoutes = append(vpc.Spec.StaticRoutes[:i], vpc.Spec.StaticRoutes[i+1:]...)
+			}
+			changed = true
+		}
+		if !changed {
+			return nil
+		}
+		setManagedVpcRoutes(vpc, managed)
+		return r.Update(ctx, vpc)
+	})
+}
+
+// sharedVpcRoutes returns the remote prefixes of the other tunnels provisioned on the VPC with the same LAN IP
+func (r *VpcNatTunnelReconciler) sharedVpcRoutes(ctx context.Context, vpcTunnel *kubeovnv1.VpcNatTunnel, vpcName, nextHop string) (map[string]bool, error) {
+	tunnels := &kubeovnv1.VpcNatTunnelList{}
+	if err := r.List(ctx, tunnels); err != nil {
+		return nil, err
+	}
+	shared := map[string]bool{}
+	for _, peer := range tunnels.Items {
+		if peer.UID == vpcTunnel.UID || !peer.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if peer.Status.Vpc != vpcName || peer.Status.LanIP != nextHop {
+			continue
+		}
+		for _, prefix := range statusRemoteCIDRs(&peer) {
+			shared[prefix] = true
+		}
+	}
+	return shared, nil
+}
+
+func vpcRouteKey(cidr, nextHop string) string {
+	return cidr + " via " + nextHop
+}
+
+// managedVpcRoutes returns the routes listed in the TunnelRoutesAnnotation of the VPC
+func managedVpcRoutes(vpc *ovn.Vpc) []string {
+	var routes []string
+	for _, route := range strings.Split(vpc.Annotations[kubeovnv1.TunnelRoutesAnnotation], ",") {
+		if route = strings.TrimSpace(route); route != "" {
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+func setManagedVpcRoutes(vpc *ovn.Vpc, routes []string) {
+	if len(routes) == 0 {
+		delete(vpc.Annotations, kubeovnv1.TunnelRoutesAnnotation)
+		return
+	}
+	if vpc.Annotations == nil {
+		vpc.Annotations = map[string]string{}
+	}
+	vpc.Annotations[kubeovnv1.TunnelRoutesAnnotation] = strings.Join(routes, ",")
+}
+
+func findVpcRoute(routes []*ovn.StaticRoute, cidr, nextHop string) int {
+	for i, route := range routes {
+		if route == nil || route.CIDR != cidr || route.NextHopIP != nextHop {
+			continue
+		}
+		if route.Policy != "" && route.Policy != ovn.PolicyDst {
+			continue
+		}
+		return i
+	}
+	return -1
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	ovn "github.com/kubeovn/kube-ovn/pkg/apis/kubeovn/v1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	kubeovnv1 "multi-vpc/api/v1"
+)
+
+var _ = Describe("VPC static routes", func() {
+	var (
+		ctx        context.Context
+		reconciler *VpcNatTunnelReconciler
+		natGw      *ovn.VpcNatGateway
+		tunnel     *kubeovnv1.VpcNatTunnel
+	)
+
+	getRoutes := func() []*ovn.StaticRoute {
+		vpc := &ovn.Vpc{}
+		Expect(reconciler.Get(ctx, client.ObjectKey{Name: "vpc1"}, vpc)).To(Succeed())
+		return vpc.Spec.StaticRoutes
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(ovn.AddToScheme(scheme)).To(Succeed())
+		Expect(kubeovnv1.AddToScheme(scheme)).To(Succeed())
+		vpc := &ovn.Vpc{
+			ObjectMeta: metav1.ObjectMeta{Name: "vpc1"},
+			Spec: ovn.VpcSpec{StaticRoutes: []*ovn.StaticRoute{
+				{Policy: ovn.PolicyDst, CIDR: "0.0.0.0/0", NextHopIP: "10.0.1.254"},
+			}},
+		}
+		reconciler = &VpcNatTunnelReconciler{
+			Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(vpc).Build(),
+		}
+		natGw = &ovn.VpcNatGateway{
+			ObjectMeta: metav1.ObjectMeta{Name: "gw1"},
+			Spec:       ovn.VpcNatSpec{Vpc: "vpc1", LanIP: "10.0.1.254"},
+		}
+		tunnel = &kubeovnv1.VpcNatTunnel{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "t0", UID: "t0"},
+			Spec:       kubeovnv1.VpcNatTunnelSpec{NatGwDp: "gw1", RemoteGlobalnetCIDR: "242.1.0.0/16"},
+		}
+	})
+
+	It("should route the remote globalnet CIDR to the gateway once", func() {
+		Expect(reconciler.syncVpcRoute(ctx, tunnel, natGw)).To(Succeed())
+		Expect(reconciler.syncVpcRoute(ctx, tunnel, natGw)).To(Succeed())
+		routes := getRoutes()
+		Expect(routes).To(HaveLen(2))
+		Expect(*routes[1]).To(Equal(ovn.StaticRoute{Policy: ovn.PolicyDst, CIDR: "242.1.0.0/16", NextHopIP: "10.0.1.254"}))
+	})
+
+	It("should retry when kube-ovn writes the VPC meanwhile", func() {
+		conflicts := 1
+		reconciler.Client = interceptor.NewClient(reconciler.Client.(client.WithWatch), interceptor.Funcs{
+			Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+				if _, ok := obj.(*ovn.Vpc); ok && conflicts > 0 {
+					conflicts--
+					return k8serrors.NewConflict(ovn.SchemeGroupVersion.WithResource("vpcs").GroupResource(), obj.GetName(), nil)
+				}
+				return c.Update(ctx, obj, opts...)
+			},
+		})
+		Expect(reconciler.syncVpcRoute(ctx, tunnel, natGw)).To(Succeed())
+		Expect(getRoutes()).To(HaveLen(2))
+	})
+
+	It("should replace the route when the remote CIDR changes and remove it on delete", func() {
+		Expect(reconciler.syncVpcRoute(ctx, tunnel, natGw)).To(Succeed())
+		tunnel.Status.Vpc = "vpc1"
+		tunnel.Status.LanIP = "10.0.1.254"
+		tunnel.Status.RemoteGlobalnetCIDR = "242.1.0.0/16"
+
+		tunnel.Spec.RemoteGlobalnetCIDR = "242.2.0.0/16"
+		Expect(reconciler.syncVpcRoute(ctx, tunnel, natGw)).To(Succeed())
+		routes := getRoutes()
+		Expect(routes).To(HaveLen(2))
+		Expect(routes[1].CIDR).To(Equal("242.2.0.0/16"))
+
+		Expect(reconciler.removeVpcRoutes(ctx, tunnel, "vpc1", []string{"242.2.0.0/16"}, "10.0.1.254")).To(Succeed())
+		Expect(getRoutes()).To(HaveLen(1))
+		Expect(reconciler.removeVpcRoutes(ctx, tunnel, "vpc2", []string{"242.2.0.0/16"}, "10.0.1.254")).To(Succeed())
+	})
+
+	It("should keep a prefix another tunnel on the gateway still routes", func() {
+		Expect(reconciler.syncVpcRoute(ctx, tunnel, natGw)).To(Succeed())
+		tunnel.Status.Vpc = "vpc1"
+		tunnel.Status.LanIP = "10.0.1.254"
+		tunnel.Status.RemoteCIDRs = specRemoteCIDRs(tunnel)
+		sibling := &kubeovnv1.VpcNatTunnel{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "t1", UID: "t1"}}
+		sibling.Spec = tunnel.Spec
+		sibling.Status = tunnel.Status
+		Expect(reconciler.Create(ctx, sibling)).To(Succeed())
+		Expect(reconciler.syncVpcRoute(ctx, sibling, natGw)).To(Succeed())
+		Expect(getRoutes()).To(HaveLen(2))
+
+		Expect(reconciler.removeVpcRoutes(ctx, tunnel, "vpc1", statusRemoteCIDRs(tunnel), "10.0.1.254")).To(Succeed())
+		Expect(getRoutes()).To(HaveLen(2))
+
+		Expect(reconciler.Delete(ctx, sibling)).To(Succeed())
+		Expect(reconciler.removeVpcRoutes(ctx, sibling, "vpc1", statusRemoteCIDRs(sibling), "10.0.1.254")).To(Succeed())
+		Expect(getRoutes()).To(HaveLen(1))
+		vpc := &ovn.Vpc{}
+		Expect(reconciler.Get(ctx, client.ObjectKey{Name: "vpc1"}, vpc)).To(Succeed())
+		Expect(vpc.Annotations).NotTo(HaveKey(kubeovnv1.TunnelRoutesAnnotation))
+	})
+
+	It("should leave routes it did not add", func() {
+		tunnel.Spec.RemoteGlobalnetCIDR = "0.0.0.0/0"
+		Expect(reconciler.syncVpcRoute(ctx, tunnel, natGw)).To(Succeed())
+		Expect(reconciler.removeVpcRoutes(ctx, tunnel, "vpc1", specRemoteCIDRs(tunnel), "10.0.1.254")).To(Succeed())
+		Expect(getRoutes()).To(HaveLen(1))
+	})
+
+	It("should only add and remove the prefixes that changed", func() {
+		tunnel.Spec.RemoteCIDRs = []string{"10.96.0.0/12"}
+		Expect(reconciler.syncVpcRoute(ctx, tunnel, natGw)).To(Succeed())
+		Expect(getRoutes()).To(HaveLen(3))
+		tunnel.Status.Vpc = "vpc1"
+		tunnel.Status.LanIP = "10.0.1.254"
+		tunnel.Status.RemoteCIDRs = specRemoteCIDRs(tunnel)
+
+		tunnel.Spec.RemoteCIDRs = []string{"242.1.0.0/16", "10.112.0.0/12"}
+		Expect(reconciler.syncVpcRoute(ctx, tunnel, natGw)).To(Succeed())
+		var cidrs []string
+		for _, route := range getRoutes()[1:] {
+			cidrs = append(cidrs, route.CIDR)
+		}
+		Expect(cidrs).To(Equal([]string{"242.1.0.0/16", "10.112.0.0/12"}))
+	})
+})
@@ -0,0 +1,181 @@
+package controller
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	kubeovnv1 "multi-vpc/api/v1"
+)
+
+// leftoverConfigMap, in the kube-ovn namespace, records teardown commands that could not be run on a gateway, keyed by
+// "<natGwDp>.<interfaceName>". They are replayed the next time a tunnel is provisioned on that gateway.
+const leftoverConfigMap = "vpc-nat-tunnel-leftovers"
+
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+
+// provisionedNatGw returns the gateway holding the tunnel state, which is the one recorded in status
+// unless the tunnel never finished provisioning.
+func provisionedNatGw(vpcTunnel *kubeovnv1.VpcNatTunnel) string {
+	if vpcTunnel.Status.NatGwDp != "" {
+		return vpcTunnel.Status.NatGwDp
+	}
+	return vpcTunnel.Spec.NatGwDp
+}
+
+// genTeardownCmd removes everything the tunnel may have created on its gateway. Every step tolerates
+// objects that are already gone, so it can be rerun on half-provisioned tunnels. The in-flow route is kept
+// if keepInFlowRoute is set, see tunnelTeardownCmd.
+func (r *VpcNatTunnelReconciler) genTeardownCmd(vpcTunnel *kubeovnv1.VpcNatTunnel, keepInFlowRoute bool) string {
+	var mainTableDev string
+	if vpcTunnel.Status.RouteTable == 0 {
+		mainTableDev = vpcTunnel.Status.InterfaceName
+	}
+	GlobalnetCIDR := vpcTunnel.Status.GlobalnetCIDR
+	if keepInFlowRoute {
+		GlobalnetCIDR = ""
+	}
+	cmds := []string{bestEffort(
+		genDelGlobalnetRoute(GlobalnetCIDR, vpcTunnel.Status.OvnGwIP, vpcTunnel.Status.RemoteGlobalnetCIDR, mainTableDev, vpcTunnel.Status.GlobalEgressIP),
+		r.genDeleteTunnelCmd(vpcTunnel),
+	)}
+	// the policy routing and SNAT teardowns tolerate missing objects by themselves and are not split into steps.
+	// The SNAT one may be an nft transaction, which has to come last.
+	if vpcTunnel.Status.RouteTable != 0 {
+		cmds = append(cmds, genPolicyRoute(vpcTunnel, statusRemoteCIDRs(vpcTunnel), vpcTunnel.Status.SourceCIDRs, vpcTunnel.Status.FwMark).DeleteCmd())
+	}
+	cmds = append(cmds, genSnatOp(vpcTunnel, vpcTunnel.Status.NatBackend, statusRemoteCIDRs(vpcTunnel), vpcTunnel.Status.GlobalEgressIP, vpcTunnel.Status.OverlayMappings, vpcTunnel.Status.Ingress).DeleteCmd())
+	return strings.Join(cmds, ";")
+}
+
+// bestEffort joins shell commands so that each of them is run even if the previous ones failed
+func bestEffort(cmds ...string) string {
+	var steps []string
+	for _, cmd := range cmds {
+		for _, step := range strings.Split(cmd, ";") {
+			if step = strings.TrimSpace(step); step != "" {
+				steps = append(steps, step+" 2>/dev/null || true")
+			}
+		}
+	}
+	return strings.Join(steps, ";")
+}
+
+// teardown removes the tunnel from its gateway. A missing gateway pod is not an error: the pod's network
+// namespace, and every interface, route and rule in it, went away with the pod.
+func (r *VpcNatTunnelReconciler) teardown(ctx context.Context, vpcTunnel *kubeovnv1.VpcNatTunnel) error {
+	if vpcTunnel.Status.InterfaceName == "" {
+		if !vpcTunnel.Status.Initialized {
+			// nothing was run on the gateway yet
+			return nil
+		}
+		// provisioned before interface names were recorded, the interface is named after the tunnel
+		vpcTunnel.Status.InterfaceName = vpcTunnel.Name
+	}
+
+	natGw := provisionedNatGw(vpcTunnel)
+	pod, err := r.getNatGwPod(natGw)
+	if k8serrors.IsNotFound(err) {
+		log.FromContext(ctx).Info("gateway pod is gone, skipping tunnel teardown", "tunnel", vpcTunnel.Name, "natGwDp", natGw)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cmd, err := r.tunnelTeardownCmd(ctx, vpcTunnel)
+	if err != nil {
+		return err
+	}
+	return r.execCommandInPod(pod.Name, pod.Namespace, r.opts().NatGwContainer, cmd)
+}
+
+// leftoverTeardownCmd returns the teardown recorded for a tunnel that is force deleted. It is replayed later, when
+// other tunnels on the gateway may have come to depend on the in-flow route, so the route is left out: it is only
+// ever removed by the teardown of the last tunnel using it, or with the gateway pod.
+func (r *VpcNatTunnelReconciler) leftoverTeardownCmd(vpcTunnel *kubeovnv1.VpcNatTunnel) string {
+	return r.genTeardownCmd(vpcTunnel, true)
+}
+
+// recordLeftover remembers a teardown command that has to be run on natGw later. The ConfigMap is shared by all
+// gateways, whose tunnels are reconciled in parallel, so conflicting writes are retried.
+func (r *VpcNatTunnelReconciler) recordLeftover(ctx context.Context, natGw, ifName, cmd string) error {
+	if natGw == "" || ifName == "" || cmd == "" {
+		return nil
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm := &corev1.ConfigMap{}
+		err := r.Get(ctx, client.ObjectKey{Namespace: r.opts().KubeOvnNamespace, Name: leftoverConfigMap}, cm)
+		if k8serrors.IsNotFound(err) {
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: r.opts().KubeOvnNamespace, Name: leftoverConfigMap},
+				Data:       map[string]string{natGw + "." + ifName: cmd},
+			}
+			err = r.Create(ctx, cm)
+			if k8serrors.IsAlreadyExists(err) {
+				// created meanwhile for another gateway, retry the update
+				return k8serrors.NewConflict(corev1.Resource("configmaps"), leftoverConfigMap, err)
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[natGw+"."+ifName] = cmd
+		return r.Update(ctx, cm)
+	})
+}
+
+// collectLeftovers runs the teardown commands recorded for natGw in its pod and forgets the ones that succeeded.
+// It has to run before any interface is created on the gateway, since a leftover may share its name. A command that
+// fails is kept for the next attempt and does not hold back the others or the provisioning.
+func (r *VpcNatTunnelReconciler) collectLeftovers(ctx context.Context, natGw string, pod *corev1.Pod) error {
+	cm := &corev1.ConfigMap{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: r.opts().KubeOvnNamespace, Name: leftoverConfigMap}, cm)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	done := map[string]string{}
+	for key, cmd := range cm.Data {
+		i := strings.LastIndex(key, ".")
+		if i < 0 || key[:i] != natGw {
+			continue
+		}
+		err = r.execCommandInPod(pod.Name, pod.Namespace, r.opts().NatGwContainer, cmd)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "unable to run leftover teardown, keeping it", "natGwDp", natGw, "leftover", key)
+			continue
+		}
+		done[key] = cmd
+	}
+	if len(done) == 0 {
+		return nil
+	}
+	// entries of other gateways may have been written meanwhile
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		err := r.Get(ctx, client.ObjectKey{Namespace: r.opts().KubeOvnNamespace, Name: leftoverConfigMap}, cm)
+		if err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		changed := false
+		for key, cmd := range done {
+			if cm.Data[key] == cmd {
+				delete(cm.Data, key)
+				changed = true
+			}
+		}
+		if !changed {
+			return nil
+		}
+		return r.Update(ctx, cm)
+	})
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kubeovnv1 "multi-vpc/api/v1"
+	natfactory "multi-vpc/internal/nat/factory"
+	"multi-vpc/internal/tunnel"
+	"multi-vpc/internal/tunnel/factory"
+)
+
+var _ = Describe("Tunnel teardown", func() {
+	reconcilerForTeardown := func() *VpcNatTunnelReconciler {
+		return &VpcNatTunnelReconciler{tunnelOpFact: factory.NewTunnelOpFactory()}
+	}
+
+	It("should not panic on a half-provisioned tunnel", func() {
+		Expect(genDelGlobalnetRoute("", "", "242.1.0.0/16", "mvpc-0123456789", nil)).
+			To(Equal("ip route del 242.1.0.0/16 dev mvpc-0123456789"))
+		Expect(genDelGlobalnetRoute("", "", "", "", nil)).To(BeEmpty())
+	})
+
+	It("should replace an interface left by an attempt that was not recorded", func() {
+		vpcTunnel := &kubeovnv1.VpcNatTunnel{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "ovn-gre0"}}
+		vpcTunnel.Status.InterfaceName = "mvpc-0123456789"
+		Expect(reconcilerForTeardown().genCreateTunnelCmd(vpcTunnel)).
+			To(HavePrefix("ip tunnel del mvpc-0123456789 2>/dev/null || true;ip tunnel add mvpc-0123456789 "))
+		vpcTunnel.Spec.Type = factory.VXLAN
+		Expect(reconcilerForTeardown().genCreateTunnelCmd(vpcTunnel)).
+			To(HavePrefix("ip link del mvpc-0123456789 2>/dev/null || true;ip link add mvpc-0123456789 "))
+	})
+
+	It("should leave the in-flow route out of a recorded leftover", func() {
+		vpcTunnel := &kubeovnv1.VpcNatTunnel{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "ovn-gre0"}}
+		vpcTunnel.Status.InterfaceName = "mvpc-0123456789"
+		vpcTunnel.Status.GlobalnetCIDR = "242.0.0.0/16"
+		vpcTunnel.Status.OvnGwIP = "10.0.1.1"
+		vpcTunnel.Status.RemoteGlobalnetCIDR = "242.1.0.0/16"
+		vpcTunnel.Status.RouteTable = 70183
+
+		cmd := reconcilerForTeardown().leftoverTeardownCmd(vpcTunnel)
+		Expect(cmd).To(ContainSubstring("ip tunnel del mvpc-0123456789"))
+		Expect(cmd).NotTo(ContainSubstring("ip route del 242.0.0.0/16"))
+	})
+
+	It("should remove the whole chain of the tunnel", func() {
+		vpcTunnel := &kubeovnv1.VpcNatTunnel{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "ovn-gre0"}}
+		vpcTunnel.Status.InterfaceName = "mvpc-0123456789"
+		route := genPolicyRoute(vpcTunnel, []string{"242.1.0.0/16"}, nil, 0)
+		chain := tunnel.GenChainName("ns1", "ovn-gre0")
+		Expect(chain).To(HavePrefix(tunnel.ChainPrefix))
+
+		snat := genSnatOp(vpcTunnel, "", []string{"242.1.0.0/16"}, []string{"242.0.0.1", "242.0.0.2"}, nil, nil)
+		Expect(genGlobalnetRoute("242.0.0.0/16", "10.0.1.1", route, snat)).
+			To(ContainSubstring("iptables -t nat -A " + chain + " -o mvpc-0123456789 -d 242.1.0.0/16 -j SNAT --to-source 242.0.0.1-242.0.0.2"))
+		Expect(snat.DeleteCmd()).To(ContainSubstring("iptables -t nat -X " + chain))
+
+		snat = genSnatOp(vpcTunnel, natfactory.NFTABLES, []string{"242.1.0.0/16"}, []string{"242.0.0.1", "242.0.0.2"}, nil, nil)
+		Expect(genGlobalnetRoute("242.0.0.0/16", "10.0.1.1", route, snat)).
+			To(And(HavePrefix("ip route replace 242.0.0.0/16 via 10.0.1.1 dev eth0;"), ContainSubstring("nft -f - <<'EOF'\n"), HaveSuffix("\nEOF\n"),
+				ContainSubstring("add rule ip multi-vpc "+chain+" oifname \"mvpc-0123456789\" ip daddr 242.1.0.0/16 counter snat to 242.0.0.1-242.0.0.2")))
+		Expect(snat.DeleteCmd()).To(ContainSubstring("delete chain ip multi-vpc " + chain))
+	})
+
+	It("should route each tunnel through a table of its own", func() {
+		vpcTunnel := &kubeovnv1.VpcNatTunnel{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "ovn-gre0"}}
+		vpcTunnel.Status.InterfaceName = "mvpc-0123456789"
+		table := tunnel.GenRouteTable("ns1", "ovn-gre0")
+		Expect(table).To(BeNumerically(">", 255))
+
+		route := genPolicyRoute(vpcTunnel, []string{"242.1.0.0/16"}, []string{"10.0.1.0/24"}, 0x10)
+		Expect(route.CreateCmd()).To(HaveSuffix(fmt.Sprintf(
+			"ip route add 242.1.0.0/16 dev mvpc-0123456789 table %d;ip rule add to 242.1.0.0/16 from 10.0.1.0/24 fwmark 0x10 lookup %d priority 10000", table, table)))
+
+		vpcTunnel.Status.Initialized = true
+		vpcTunnel.Status.RemoteGlobalnetCIDR = "242.1.0.0/16"
+		Expect(reconcilerForTeardown().genTeardownCmd(vpcTunnel, false)).NotTo(ContainSubstring("ip route flush table"))
+		Expect(reconcilerForTeardown().genTeardownCmd(vpcTunnel, false)).To(ContainSubstring("ip route del 242.1.0.0/16 dev mvpc-0123456789"))
+		vpcTunnel.Status.RouteTable = table
+		Expect(reconcilerForTeardown().genTeardownCmd(vpcTunnel, false)).To(ContainSubstring(fmt.Sprintf("ip route flush table %d", table)))
+		Expect(reconcilerForTeardown().genTeardownCmd(vpcTunnel, false)).NotTo(ContainSubstring("ip route del 242.1.0.0/16 dev"))
+	})
+
+	It("should only touch the prefixes that changed", func() {
+		vpcTunnel := &kubeovnv1.VpcNatTunnel{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "ovn-gre0"}}
+		vpcTunnel.Status.InterfaceName = "mvpc-0123456789"
+		vpcTunnel.Status.RouteTable = tunnel.GenRouteTable("ns1", "ovn-gre0")
+		vpcTunnel.Status.GlobalEgressIP = []string{"242.0.0.1", "242.0.0.2"}
+		vpcTunnel.Status.RemoteCIDRs = []string{"242.1.0.0/16", "10.96.0.0/12"}
+		vpcTunnel.Status.SourceCIDRs = []string{"10.0.1.0/24"}
+		vpcTunnel.Spec.RemoteGlobalnetCIDR = "242.1.0.0/16"
+		vpcTunnel.Spec.RemoteCIDRs = []string{"10.112.0.0/12"}
+		vpcTunnel.Spec.SourceCIDRs = []string{"10.0.1.0/24"}
+
+		table := vpcTunnel.Status.RouteTable
+		chain := tunnel.GenChainName("ns1", "ovn-gre0")
+		cmds := strings.Split(genUpdatePrefixesCmd(vpcTunnel), ";")
+		Expect(cmds).To(ContainElements(
+			fmt.Sprintf("ip rule del to 10.96.0.0/12 from 10.0.1.0/24 lookup %d priority 10000 2>/dev/null || true", table),
+			fmt.Sprintf("ip route del 10.96.0.0/12 dev mvpc-0123456789 table %d 2>/dev/null || true", table),
+			fmt.Sprintf("ip route replace 10.112.0.0/12 dev mvpc-0123456789 table %d", table),
+			fmt.Sprintf("ip rule add to 10.112.0.0/12 from 10.0.1.0/24 lookup %d priority 10000", table),
+			"iptables -t nat -D "+chain+" -o mvpc-0123456789 -d 10.96.0.0/12 -j SNAT --to-source 242.0.0.1-242.0.0.2 2>/dev/null || true",
+		))
+		for _, cmd := range cmds {
+			Expect(cmd).NotTo(ContainSubstring("242.1.0.0/16"))
+			Expect(cmd).NotTo(ContainSubstring("flush"))
+		}
+
+		vpcTunnel.Spec.RemoteCIDRs = []string{"10.96.0.0/12"}
+		Expect(tunnelSpecChanged(vpcTunnel)).To(BeFalse())
+		Expect(genUpdatePrefixesCmd(vpcTunnel)).To(BeEmpty())
+	})
+
+	It("should pick nftables only on iptables-nft images shipping nft", func() {
+		Expect(detectNatBackend("iptables v1.8.7 (nf_tables)\n/usr/sbin/nft\n")).To(Equal(natfactory.NFTABLES))
+		Expect(detectNatBackend("iptables v1.8.7 (nf_tables)\n")).To(Equal(natfactory.IPTABLES))
+		Expect(detectNatBackend("iptables v1.8.7 (legacy)\n/usr/sbin/nft\n")).To(Equal(natfactory.IPTABLES))
+	})
+
+	It("should keep going when a step fails", func() {
+		Expect(bestEffort("ip route del 242.1.0.0/16 dev t0;", "ip link del t0")).
+			To(Equal("ip route del 242.1.0.0/16 dev t0 2>/dev/null || true;ip link del t0 2>/dev/null || true"))
+		Expect(bestEffort("")).To(BeEmpty())
+	})
+})
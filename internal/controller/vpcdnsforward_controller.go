@@ -27,6 +27,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	kubeovnv1 "multi-vpc/api/v1"
+	"multi-vpc/internal/options"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -37,6 +38,9 @@ type VpcDnsForwardReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
 	Config *rest.Config
+
+	// Options names the kube-ovn objects, defaults to options.NewOptions()
+	Options *options.Options
 }
 
 //+kubebuilder:rbac:groups=kubeovn.ustc.io,resources=vpcdnsforwards,verbs=get;list;watch;create;update;patch;delete
@@ -100,12 +104,19 @@ func (r *VpcDnsForwardReconciler) handleDelete(ctx context.Context, vpcDns *kube
 	return ctrl.Result{}, nil
 }
 
+func (r *VpcDnsForwardReconciler) opts() *options.Options {
+	if r.Options != nil {
+		return r.Options
+	}
+	return defaultOptions
+}
+
 // 检查 Vpc-Dns 的 Corefile
 func (r *VpcDnsForwardReconciler) checkDnsCorefile(ctx context.Context) (bool, error) {
 	cm := corev1.ConfigMap{}
 	err := r.Get(ctx, client.ObjectKey{
 		Name:      "vpc-dns-corefile",
-		Namespace: "kube-system",
+		Namespace: r.opts().KubeOvnNamespace,
 	}, &cm)
 	if err != nil {
 		return false, err
@@ -122,7 +133,7 @@ func (r *VpcDnsForwardReconciler) updateDnsCorefile(ctx context.Context) error {
 	cm := corev1.ConfigMap{}
 	err := r.Get(ctx, client.ObjectKey{
 		Name:      "vpc-dns-corefile",
-		Namespace: "kube-system",
+		Namespace: r.opts().KubeOvnNamespace,
 	}, &cm)
 	if err != nil {
 		return err
@@ -130,8 +141,8 @@ func (r *VpcDnsForwardReconciler) updateDnsCorefile(ctx context.Context) error {
 	// 获取 CoreDNS 的 svc
 	var coreDnsSvc corev1.Service
 	err = r.Client.Get(ctx, client.ObjectKey{
-		Name:      "kube-dns",
-		Namespace: "kube-system",
+		Name:      r.opts().DnsServiceName,
+		Namespace: r.opts().DnsServiceNamespace,
 	}, &coreDnsSvc)
 	if err != nil {
 		return err
@@ -153,7 +164,7 @@ func (r *VpcDnsForwardReconciler) updateDnsCorefile(ctx context.Context) error {
 		var vpcDnsDeployment appsv1.Deployment
 		err = r.Client.Get(ctx, client.ObjectKey{
 			Name:      "vpc-dns-" + vpcDns.Name,
-			Namespace: "kube-system",
+			Namespace: r.opts().KubeOvnNamespace,
 		}, &vpcDnsDeployment)
 		if err != nil {
 			return err
@@ -185,8 +196,8 @@ func (r *VpcDnsForwardReconciler) createDnsConnection(ctx context.Context, vpcDn
 	// 获取 CoreDNS 的 svc
 	var coreDnsSvc corev1.Service
 	err = r.Client.Get(ctx, client.ObjectKey{
-		Name:      "kube-dns",
-		Namespace: "kube-system",
+		Name:      r.opts().DnsServiceName,
+		Namespace: r.opts().DnsServiceNamespace,
 	}, &coreDnsSvc)
 	if err != nil {
 		return err
@@ -208,7 +219,7 @@ func (r *VpcDnsForwardReconciler) createDnsConnection(ctx context.Context, vpcDn
 	var ovnDnsDeployment appsv1.Deployment
 	err = r.Client.Get(ctx, client.ObjectKey{
 		Name:      "vpc-dns-" + ovnDns.Name,
-		Namespace: "kube-system",
+		Namespace: r.opts().KubeOvnNamespace,
 	}, &ovnDnsDeployment)
 	if err != nil {
 		return err
@@ -216,7 +227,7 @@ func (r *VpcDnsForwardReconciler) createDnsConnection(ctx context.Context, vpcDn
 	// 获取默认子网 subnet
 	var subnet ovn.Subnet
 	err = r.Client.Get(ctx, client.ObjectKey{
-		Name: "ovn-default",
+		Name: r.opts().DefaultSubnet,
 	}, &subnet)
 	if err != nil {
 		return err
@@ -246,8 +257,8 @@ func (r *VpcDnsForwardReconciler) deleteDnsConnection(ctx context.Context, vpcDn
 	// 获取 CoreDNS 的 svc
 	var coreDnsSvc corev1.Service
 	err := r.Client.Get(ctx, client.ObjectKey{
-		Name:      "kube-dns",
-		Namespace: "kube-system",
+		Name:      r.opts().DnsServiceName,
+		Namespace: r.opts().DnsServiceNamespace,
 	}, &coreDnsSvc)
 	if err != nil {
 		return err
@@ -269,7 +280,7 @@ func (r *VpcDnsForwardReconciler) deleteDnsConnection(ctx context.Context, vpcDn
 	var ovnDnsDeployment appsv1.Deployment
 	err = r.Client.Get(ctx, client.ObjectKey{
 		Name:      "vpc-dns-" + ovnDns.Name,
-		Namespace: "kube-system",
+		Namespace: r.opts().KubeOvnNamespace,
 	}, &ovnDnsDeployment)
 	if err != nil {
 		return err
@@ -277,7 +288,7 @@ func (r *VpcDnsForwardReconciler) deleteDnsConnection(ctx context.Context, vpcDn
 	// 获取对应的 subnet
 	var subnet ovn.Subnet
 	err = r.Client.Get(ctx, client.ObjectKey{
-		Name: "ovn-default",
+		Name: r.opts().DefaultSubnet,
 	}, &subnet)
 	if err != nil {
 		return err
@@ -21,28 +21,43 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
-	"time"
-
-	// appsv1 "k8s.io/api/apps/v1"
 
+	ovn "github.com/kubeovn/kube-ovn/pkg/apis/kubeovn/v1"
 	Submariner "github.com/submariner-io/submariner/pkg/apis/submariner.io/v1"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	crcontroller "sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	kubeovnv1 "multi-vpc/api/v1"
+	"multi-vpc/internal/cidr"
+	"multi-vpc/internal/nat"
+	natfactory "multi-vpc/internal/nat/factory"
+	"multi-vpc/internal/options"
+	"multi-vpc/internal/tunnel"
 	"multi-vpc/internal/tunnel/factory"
 )
 
+// natGwDpIndexKey indexes VpcNatTunnels by Spec.NatGwDp
+const natGwDpIndexKey = "spec.natGwDp"
+
 // VpcNatTunnelReconciler reconciles a VpcNatTunnel object
 type VpcNatTunnelReconciler struct {
 	client.Client
@@ -50,6 +65,17 @@ type VpcNatTunnelReconciler struct {
 	Config       *rest.Config
 	KubeClient   kubernetes.Interface
 	tunnelOpFact *factory.TunnelOperationFactory
+
+	// Options names the kube-ovn and Submariner objects, defaults to options.NewOptions()
+	Options *options.Options
+	// MaxConcurrentReconciles is the number of tunnels reconciled in parallel, defaults to 1
+	MaxConcurrentReconciles int
+	// natGwBackoff spaces out retries of tunnels whose gateway is not ready
+	natGwBackoff workqueue.RateLimiter
+	// submarinerMissing is set when the cluster does not serve the Submariner API, see submarinerServed
+	submarinerMissing bool
+	// natGwLocks keeps tunnels of the same gateway from being reconciled in parallel
+	natGwLocks natGwLocks
 }
 
 //+kubebuilder:rbac:groups=kubeovn.ustc.io,resources=vpcnattunnels,verbs=get;list;watch;create;update;patch;delete
@@ -59,6 +85,7 @@ type VpcNatTunnelReconciler struct {
 //+kubebuilder:rbac:groups=core,resources=pods/exec,verbs=get;create
 //+kubebuilder:rbac:groups=submariner.io,resources=gateways,verbs=get;list;watch;
 //+kubebuilder:rbac:groups=submariner.io,resources=clusterglobalegressips,verbs=get;list;watch
+//+kubebuilder:rbac:groups=submariner.io,resources=globalegressips,verbs=get;list;watch
 //+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
@@ -80,17 +107,37 @@ func (r *VpcNatTunnelReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		log.Log.Error(err, "unable to fetch vpcNatTunnel")
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
+	// a tunnel moving between gateways touches both
+	defer r.natGwLocks.lock(vpcTunnel.Spec.NatGwDp, vpcTunnel.Status.NatGwDp)()
+	var result ctrl.Result
 	if !vpcTunnel.ObjectMeta.DeletionTimestamp.IsZero() {
-		return r.handleDelete(ctx, vpcTunnel)
+		result, err = r.handleDelete(ctx, vpcTunnel)
+	} else {
+		result, err = r.handleCreateOrUpdate(ctx, vpcTunnel)
 	}
-	return r.handleCreateOrUpdate(ctx, vpcTunnel)
+	return r.handleNatGwReadiness(ctx, req, vpcTunnel, result, err)
+}
+
+// defaultOptions is used by reconcilers created without Options
+var defaultOptions = options.NewOptions()
 
+func (r *VpcNatTunnelReconciler) opts() *options.Options {
+	if r.Options != nil {
+		return r.Options
+	}
+	return defaultOptions
 }
 
 func (r *VpcNatTunnelReconciler) execCommandInPod(podName, namespace, containerName, command string) error {
+	_, err := r.execCommandInPodOutput(podName, namespace, containerName, command)
+	return err
+}
+
+// execCommandInPodOutput runs command in the container and returns its stdout
+func (r *VpcNatTunnelReconciler) execCommandInPodOutput(podName, namespace, containerName, command string) (string, error) {
 	clientset, err := kubernetes.NewForConfig(r.Config)
 	if err != nil {
-		return err
+		return "", err
 	}
 	cmd := []string{
 		"sh",
@@ -116,7 +163,7 @@ func (r *VpcNatTunnelReconciler) execCommandInPod(podName, namespace, containerN
 	var stdout, stderr bytes.Buffer
 	exec, err := remotecommand.NewSPDYExecutor(r.Config, "POST", req.URL())
 	if err != nil {
-		return err
+		return "", err
 	}
 	err = exec.StreamWithContext(context.TODO(), remotecommand.StreamOptions{
 		Stdin:  nil,
@@ -124,89 +171,182 @@ func (r *VpcNatTunnelReconciler) execCommandInPod(podName, namespace, containerN
 		Stderr: &stderr,
 	})
 	if err != nil {
-		return err
+		return "", err
 	}
 	// return strings.TrimSpace(stdout.String()), strings.TrimSpace(stderr.String()), err
 	if strings.TrimSpace(stderr.String()) != "" {
-		return fmt.Errorf(strings.TrimSpace(stderr.String()))
+		return "", fmt.Errorf(strings.TrimSpace(stderr.String()))
 	}
-	return nil
+	return stdout.String(), nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *VpcNatTunnelReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.Config = mgr.GetConfig()
 	r.tunnelOpFact = factory.NewTunnelOpFactory()
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&kubeovnv1.VpcNatTunnel{}).
-		Complete(r)
-}
+	r.natGwBackoff = workqueue.NewItemExponentialFailureRateLimiter(natGwMinBackoff, natGwMaxBackoff)
 
-func GenNatGwStsName(name string) string {
-	return fmt.Sprintf("vpc-nat-gw-%s", name)
-}
+	// index tunnels by the gateway they live on, so gateway events only enqueue dependent tunnels
+	err := mgr.GetFieldIndexer().IndexField(context.Background(), &kubeovnv1.VpcNatTunnel{}, natGwDpIndexKey, func(obj client.Object) []string {
+		tunnel := obj.(*kubeovnv1.VpcNatTunnel)
+		if tunnel.Spec.NatGwDp == "" {
+			return nil
+		}
+		return []string{tunnel.Spec.NatGwDp}
+	})
+	if err != nil {
+		return err
+	}
 
-func (r *VpcNatTunnelReconciler) getNatGwPod(name string) (*corev1.Pod, error) {
-	podList := &corev1.PodList{}
-	matchLabels := map[string]string{"app": GenNatGwStsName(name), "ovn.kubernetes.io/vpc-nat-gw": "true"}
-	listOpts := []client.ListOption{
-		client.InNamespace("kube-system"),
-		client.MatchingLabels(matchLabels),
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &kubeovnv1.VpcNatTunnel{}, globalIPPoolIndexKey, func(obj client.Object) []string {
+		tunnel := obj.(*kubeovnv1.VpcNatTunnel)
+		if tunnel.Spec.GlobalIPPool == "" {
+			return nil
+		}
+		return []string{tunnel.Spec.GlobalIPPool}
+	})
+	if err != nil {
+		return err
 	}
-	err := r.List(context.TODO(), podList, listOpts...)
+
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &kubeovnv1.VpcNatTunnel{}, remoteClusterIDIndexKey, func(obj client.Object) []string {
+		tunnel := obj.(*kubeovnv1.VpcNatTunnel)
+		if tunnel.Spec.RemoteClusterID == "" {
+			return nil
+		}
+		return []string{tunnel.Spec.RemoteClusterID}
+	})
 	if err != nil {
-		return nil, err
+		return err
 	}
-	pods := podList.Items
-	switch {
-	case len(pods) == 0:
-		return nil, k8serrors.NewNotFound(corev1.Resource("pod"), name)
-	case len(pods) != 1:
-		time.Sleep(5 * time.Second)
-		return nil, fmt.Errorf("too many pod")
-	case pods[0].Status.Phase != "Running":
-		time.Sleep(5 * time.Second)
-		return nil, fmt.Errorf("pod is not active now")
+
+	err = mgr.GetFieldIndexer().IndexField(context.Background(), &kubeovnv1.VpcNatTunnel{}, interfaceAddrPoolIndexKey, func(obj client.Object) []string {
+		tunnel := obj.(*kubeovnv1.VpcNatTunnel)
+		if tunnel.Spec.InterfaceAddrPool == "" {
+			return nil
+		}
+		return []string{tunnel.Spec.InterfaceAddrPool}
+	})
+	if err != nil {
+		return err
+	}
+
+	served, err := submarinerServed(mgr.GetRESTMapper())
+	if err != nil {
+		return err
+	}
+	r.submarinerMissing = !served
+
+	isNatGw := predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return obj.GetNamespace() == r.opts().KubeOvnNamespace && obj.GetLabels()[r.opts().NatGwLabel] == "true"
+	})
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&kubeovnv1.VpcNatTunnel{}).
+		Watches(&kubeovnv1.VpcNatTunnel{},
+			handler.EnqueueRequestsFromMapFunc(r.conflictingPeers)).
+		Watches(&ovn.VpcNatGateway{},
+			handler.EnqueueRequestsFromMapFunc(r.natGwToTunnels)).
+		Watches(&appsv1.StatefulSet{},
+			handler.EnqueueRequestsFromMapFunc(r.natGwStatefulSetToTunnels),
+			builder.WithPredicates(isNatGw)).
+		Watches(&corev1.Pod{},
+			handler.EnqueueRequestsFromMapFunc(r.natGwPodToTunnels),
+			builder.WithPredicates(isNatGw)).
+		Watches(&kubeovnv1.GlobalIPPool{},
+			handler.EnqueueRequestsFromMapFunc(r.globalIPPoolToTunnels)).
+		Watches(&kubeovnv1.InterfaceAddrPool{},
+			handler.EnqueueRequestsFromMapFunc(r.interfaceAddrPoolToTunnels)).
+		WithOptions(crcontroller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles})
+	if r.submarinerMissing {
+		// the sources of kinds the cluster does not serve never sync, which would stop the manager
+		mgr.GetLogger().Info("submariner.io/v1 is not served, Submariner objects are not watched")
+		return b.Complete(r)
 	}
+	return b.
+		Watches(&Submariner.Gateway{},
+			handler.EnqueueRequestsFromMapFunc(r.allTunnels),
+			builder.WithPredicates(r.globalnetChanged())).
+		Watches(&Submariner.ClusterGlobalEgressIP{},
+			handler.EnqueueRequestsFromMapFunc(r.allTunnels),
+			builder.WithPredicates(r.globalnetChanged())).
+		Watches(&Submariner.GlobalEgressIP{},
+			handler.EnqueueRequestsFromMapFunc(r.globalEgressIPToTunnels)).
+		Watches(&Submariner.GlobalIngressIP{},
+			handler.EnqueueRequestsFromMapFunc(r.globalIngressIPToTunnels)).
+		Watches(&Submariner.Endpoint{},
+			handler.EnqueueRequestsFromMapFunc(r.remoteEndpointToTunnels),
+			builder.WithPredicates(r.remoteEndpointChanged())).
+		Watches(&Submariner.Cluster{},
+			handler.EnqueueRequestsFromMapFunc(r.allTunnels),
+			builder.WithPredicates(r.globalnetChanged())).
+		Complete(r)
+}
 
-	return &pods[0], nil
+// natGwToTunnels maps a kube-ovn VpcNatGateway to the tunnels using it
+func (r *VpcNatTunnelReconciler) natGwToTunnels(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.tunnelsOnNatGw(ctx, obj.GetName())
 }
 
-func (r *VpcNatTunnelReconciler) getGlobalnetCIDR() (string, error) {
-	submGwlist := &Submariner.GatewayList{}
-	err := r.List(context.TODO(), submGwlist, client.InNamespace("submariner-operator"))
-	if err != nil {
-		return "", err
+// natGwStatefulSetToTunnels maps a vpc-nat-gw StatefulSet to the tunnels using that gateway
+func (r *VpcNatTunnelReconciler) natGwStatefulSetToTunnels(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.tunnelsOnNatGw(ctx, strings.TrimPrefix(obj.GetName(), r.opts().NatGwPrefix))
+}
+
+// natGwPodToTunnels maps a vpc-nat-gw pod to the tunnels using that gateway
+func (r *VpcNatTunnelReconciler) natGwPodToTunnels(ctx context.Context, obj client.Object) []reconcile.Request {
+	app, ok := obj.GetLabels()["app"]
+	if !ok {
+		return nil
 	}
+	return r.tunnelsOnNatGw(ctx, strings.TrimPrefix(app, r.opts().NatGwPrefix))
+}
 
-	submGws := submGwlist.Items
-	switch {
-	case len(submGws) == 0:
-		return "", fmt.Errorf("no Submariner.Gateway")
-	default:
-		return submGws[0].Status.LocalEndpoint.Subnets[0], nil
+func (r *VpcNatTunnelReconciler) tunnelsOnNatGw(ctx context.Context, natGw string) []reconcile.Request {
+	tunnels := &kubeovnv1.VpcNatTunnelList{}
+	if err := r.List(ctx, tunnels, client.MatchingFields{natGwDpIndexKey: natGw}); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list vpcNatTunnels for gateway", "natGwDp", natGw)
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(tunnels.Items))
+	for _, tunnel := range tunnels.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: tunnel.Namespace, Name: tunnel.Name},
+		})
 	}
+	return requests
+}
+
+func (r *VpcNatTunnelReconciler) getNatGwPod(name string) (*corev1.Pod, error) {
+	_, pod, err := r.resolveNatGw(context.TODO(), name)
+	return pod, err
 }
 
 func (r *VpcNatTunnelReconciler) getGlobalEgressIP() ([]string, error) {
 	submGlobalEgressIP := &Submariner.ClusterGlobalEgressIP{}
-	err := r.Get(context.TODO(), client.ObjectKey{Name: "cluster-egress.submariner.io"}, submGlobalEgressIP)
+	err := r.Get(context.TODO(), client.ObjectKey{Name: r.opts().ClusterGlobalEgressIP}, submGlobalEgressIP)
 	if err != nil {
 		return nil, err
 	}
+	// SNAT to no address would be a broken rule, wait for Submariner to allocate some
+	if len(submGlobalEgressIP.Status.AllocatedIPs) == 0 {
+		return nil, fmt.Errorf("no IPs allocated to ClusterGlobalEgressIP %s yet", submGlobalEgressIP.Name)
+	}
+	if _, err := nat.EgressRanges(submGlobalEgressIP.Status.AllocatedIPs); err != nil {
+		return nil, fmt.Errorf("ClusterGlobalEgressIP %s: %w", submGlobalEgressIP.Name, err)
+	}
 	return submGlobalEgressIP.Status.AllocatedIPs, nil
 }
 
 func (r *VpcNatTunnelReconciler) getGwExternIP(pod *corev1.Pod) (string, error) {
-	if ExternIP, ok := pod.Annotations["ovn-vpc-external-network.kube-system.kubernetes.io/ip_address"]; ok {
+	if ExternIP, ok := pod.Annotations[r.opts().ExternalIPAnnotation]; ok {
 		return ExternIP, nil
 	} else {
-		return "", fmt.Errorf("no ovn-vpc-external-network ip")
+		return "", fmt.Errorf("no %s annotation on pod %s", r.opts().ExternalIPAnnotation, pod.Name)
 	}
 }
 
 func (r *VpcNatTunnelReconciler) getPodGwIP(pod *corev1.Pod) (string, error) {
-	if gw, ok := pod.Annotations["ovn.kubernetes.io/gateway"]; ok {
+	if gw, ok := pod.Annotations[r.opts().GatewayIPAnnotation]; ok {
 		return gw, nil
 	} else {
 		return "", fmt.Errorf("no ovn gateway")
@@ -221,24 +361,86 @@ func (r *VpcNatTunnelReconciler) genCreateTunnelCmd(tunnel *kubeovnv1.VpcNatTunn
 	// return createCmd + ";" + setUpCmd + ";" + addrCmd
 }
 
-func genGlobalnetRoute(GlobalnetCIDR string, ovnGwIP string, RemoteGlobalnetCIDR string, tunnelName string, GlobalEgressIP []string) string {
+func genGlobalnetRoute(GlobalnetCIDR string, ovnGwIP string, route policyRoute, snat nat.SnatOperation) string {
+	// 跨集群流量经隧道自己的路由表路由至隧道
+	OutFlowRoute := route.CreateCmd()
+	if GlobalnetCIDR == "" {
+		// outside globalnet mode the traffic comes in for VPC subnets, which kube-ovn routes on the gateway already
+		return OutFlowRoute + ";" + snat.CreateCmd()
+	}
 	// 入流量转发给ovn网关(逻辑交换机)
-	InFlowRoute := fmt.Sprintf("ip route add %s via %s dev eth0", GlobalnetCIDR, ovnGwIP)
-	// 跨集群流量路由至隧道
-	OutFlowRoute := fmt.Sprintf("ip route add %s dev %s", RemoteGlobalnetCIDR, tunnelName)
+	InFlowRoute := genInFlowRoute(GlobalnetCIDR, ovnGwIP)
 
 	// 创建snat，将跨集群流量数据包源地址修改为ClusterGlobalEgressIP(globalnet cidr前8个)
-	SNAT := fmt.Sprintf("iptables -t nat -A POSTROUTING -d %s -j SNAT --to-source %s-%s", RemoteGlobalnetCIDR, GlobalEgressIP[0], GlobalEgressIP[len(GlobalEgressIP)-1])
-	return InFlowRoute + ";" + OutFlowRoute + ";" + SNAT
+	// kube-ovn IptablesSnatRule/IptablesEIP cannot express this rule: kube-ovn always installs its SNAT as
+	// "-A SHARED_SNAT -o net1 -s <internalCIDR>" and takes EIPs from the external subnet, while this traffic leaves
+	// through the tunnel interface, is matched on its destination and translated to globalnet egress IPs.
+	// The rules live in a chain of their own, which is rebuilt from scratch every time.
+	// The SNAT goes last, an nft transaction has to end the script.
+	return InFlowRoute + ";" + OutFlowRoute + ";" + snat.CreateCmd()
 	// return InFlowRoute
 }
 
+// genUpdatePrefixesCmd moves the routes, rules and SNAT of the tunnel from the prefixes recorded in status to
+// those of the spec
+func genUpdatePrefixesCmd(vpcTunnel *kubeovnv1.VpcNatTunnel) string {
+	previous := genPolicyRoute(vpcTunnel, statusRemoteCIDRs(vpcTunnel), vpcTunnel.Status.SourceCIDRs, vpcTunnel.Status.FwMark)
+	route := genPolicyRoute(vpcTunnel, specRemoteCIDRs(vpcTunnel), vpcTunnel.Spec.SourceCIDRs, vpcTunnel.Spec.FwMark)
+	snat := genSnatOp(vpcTunnel, vpcTunnel.Status.NatBackend, specRemoteCIDRs(vpcTunnel), vpcTunnel.Status.GlobalEgressIP, vpcTunnel.Status.OverlayMappings, vpcTunnel.Status.Ingress)
+	var cmds []string
+	// the SNAT goes last, it may be an nft transaction
+	previousSnat := genSnatRule(vpcTunnel, statusRemoteCIDRs(vpcTunnel), vpcTunnel.Status.GlobalEgressIP, vpcTunnel.Status.OverlayMappings, vpcTunnel.Status.Ingress)
+	for _, cmd := range []string{route.UpdateCmd(previous), snat.UpdateCmd(previousSnat)} {
+		if cmd != "" {
+			cmds = append(cmds, cmd)
+		}
+	}
+	return strings.Join(cmds, ";")
+}
+
+// genDelGlobalnetRoute undoes the main table routes of genGlobalnetRoute. Parts whose inputs were never recorded
+// in status are skipped, since a half-provisioned tunnel may not have them. tunnelName is only needed for
+// tunnels provisioned before policy routing, which routed RemoteGlobalnetCIDR in the main table.
 func genDelGlobalnetRoute(GlobalnetCIDR string, ovnGwIP string, RemoteGlobalnetCIDR string, tunnelName string, GlobalEgressIP []string) string {
-	InFlowRoute := fmt.Sprintf("ip route del %s via %s dev eth0", GlobalnetCIDR, ovnGwIP)
-	OutFlowRoute := fmt.Sprintf("ip route del %s dev %s", RemoteGlobalnetCIDR, tunnelName)
+	var cmds []string
+	if GlobalnetCIDR != "" && ovnGwIP != "" {
+		cmds = append(cmds, fmt.Sprintf("ip route del %s via %s dev eth0", GlobalnetCIDR, ovnGwIP))
+	}
+	if RemoteGlobalnetCIDR != "" && tunnelName != "" {
+		cmds = append(cmds, fmt.Sprintf("ip route del %s dev %s", RemoteGlobalnetCIDR, tunnelName))
+	}
+	if RemoteGlobalnetCIDR != "" && len(GlobalEgressIP) != 0 {
+		// tunnels provisioned before per-tunnel chains appended their SNAT to POSTROUTING directly
+		cmds = append(cmds, fmt.Sprintf("iptables -t nat -D POSTROUTING -d %s -j SNAT --to-source %s-%s", RemoteGlobalnetCIDR, GlobalEgressIP[0], GlobalEgressIP[len(GlobalEgressIP)-1]))
+	}
+	return strings.Join(cmds, ";")
+}
 
-	SNAT := fmt.Sprintf("iptables -t nat -D POSTROUTING -d %s -j SNAT --to-source %s-%s", RemoteGlobalnetCIDR, GlobalEgressIP[0], GlobalEgressIP[len(GlobalEgressIP)-1])
-	return InFlowRoute + ";" + OutFlowRoute + ";" + SNAT
+// genSnatOp returns the SNAT of the tunnel on the given NAT backend
+func genSnatOp(vpcTunnel *kubeovnv1.VpcNatTunnel, backend string, RemoteCIDRs []string, GlobalEgressIP []string, mappings []kubeovnv1.OverlayMapping, ingress []kubeovnv1.IngressRule) nat.SnatOperation {
+	return natfactory.CreateSnatOperation(backend, genSnatRule(vpcTunnel, RemoteCIDRs, GlobalEgressIP, mappings, ingress))
+}
+
+func genSnatRule(vpcTunnel *kubeovnv1.VpcNatTunnel, RemoteCIDRs []string, GlobalEgressIP []string, mappings []kubeovnv1.OverlayMapping, ingress []kubeovnv1.IngressRule) nat.SnatRule {
+	rule := nat.SnatRule{
+		Chain:        tunnel.GenChainName(vpcTunnel.Namespace, vpcTunnel.Name),
+		OutInterface: vpcTunnel.Status.InterfaceName,
+		RemoteCIDRs:  RemoteCIDRs,
+		EgressIPs:    GlobalEgressIP,
+	}
+	for _, m := range mappings {
+		rule.Mappings = append(rule.Mappings, nat.Mapping{Local: m.LocalCIDR, Overlay: m.OverlayCIDR})
+	}
+	for _, in := range ingress {
+		rule.Ingress = append(rule.Ingress, nat.Dnat{
+			GlobalIP:   in.GlobalIP,
+			InternalIP: in.InternalIP,
+			Protocol:   in.Protocol,
+			Port:       in.Port,
+			TargetPort: in.TargetPort,
+		})
+	}
+	return rule
 }
 
 func (r *VpcNatTunnelReconciler) genDeleteTunnelCmd(tunnel *kubeovnv1.VpcNatTunnel) string {
@@ -256,54 +458,119 @@ func (r *VpcNatTunnelReconciler) handleCreateOrUpdate(ctx context.Context, vpcTu
 		}
 	}
 
+	err := r.resolveRemoteCluster(ctx, vpcTunnel)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	err = r.resolveInterfaceAddr(ctx, vpcTunnel)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	natGw, err := r.getNatGw(ctx, vpcTunnel.Spec.NatGwDp)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	err = r.setNatGwOwner(ctx, vpcTunnel, natGw)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if vpcTunnel.Status.InterfaceName == "" {
+		if vpcTunnel.Status.Initialized {
+			// provisioned before interface names were recorded, the interface is named after the tunnel
+			vpcTunnel.Status.InterfaceName = vpcTunnel.Name
+		} else {
+			vpcTunnel.Status.InterfaceName = tunnel.GenInterfaceName(vpcTunnel.Namespace, vpcTunnel.Name)
+		}
+		err := r.Status().Update(ctx, vpcTunnel)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if !vpcTunnel.Status.Initialized || tunnelSpecChanged(vpcTunnel) {
+		conflict, err := r.checkConflicts(ctx, vpcTunnel)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if conflict {
+			// wait until the conflicting tunnel changes, see conflictingPeers
+			return ctrl.Result{}, nil
+		}
+	}
+
 	if !vpcTunnel.Status.Initialized {
 		// add tunnel
-		podnext, err := r.getNatGwPod(vpcTunnel.Spec.NatGwDp) // find pod named Spec.NatGwDp
+		natGw, podnext, err := r.resolveNatGw(ctx, vpcTunnel.Spec.NatGwDp)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
 
 		// find local cluster GlobalnetCIDR
-		GlobalnetCIDR, err := r.getGlobalnetCIDR()
+		GlobalnetCIDR, GlobalEgressIP, err := r.resolveGlobalnet(ctx, vpcTunnel, natGw.Spec.Vpc)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
 		vpcTunnel.Status.GlobalnetCIDR = GlobalnetCIDR
-		ovnGwIP, err := r.getPodGwIP(podnext)
+		vpcTunnel.Status.GlobalEgressIP = GlobalEgressIP
+		ovnGwIP, err := r.getOvnGwIP(ctx, natGw, podnext)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
 		vpcTunnel.Status.OvnGwIP = ovnGwIP
-		GlobalEgressIP, err := r.getGlobalEgressIP()
+		GwExternIP, err := r.getNatGwExternIP(natGw, podnext)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
-		vpcTunnel.Status.GlobalEgressIP = GlobalEgressIP
-		GwExternIP, err := r.getGwExternIP(podnext)
+		vpcTunnel.Status.InternalIP = GwExternIP
+		natBackend, err := r.selectNatBackend(natGw, podnext)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+
+		ingress, err := r.resolveIngress(ctx, vpcTunnel)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
-		vpcTunnel.Status.InternalIP = GwExternIP
 
-		err = r.execCommandInPod(podnext.Name, podnext.Namespace, "vpc-nat-gw", r.genCreateTunnelCmd(vpcTunnel))
+		err = r.collectLeftovers(ctx, vpcTunnel.Spec.NatGwDp, podnext)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
-		err = r.execCommandInPod(podnext.Name, podnext.Namespace, "vpc-nat-gw", genGlobalnetRoute(GlobalnetCIDR, ovnGwIP, vpcTunnel.Spec.RemoteGlobalnetCIDR, vpcTunnel.Name, GlobalEgressIP))
+		err = r.execCommandInPod(podnext.Name, podnext.Namespace, r.opts().NatGwContainer, r.genCreateTunnelCmd(vpcTunnel))
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		err = r.execCommandInPod(podnext.Name, podnext.Namespace, r.opts().NatGwContainer, genGlobalnetRoute(GlobalnetCIDR, ovnGwIP, genPolicyRoute(vpcTunnel, specRemoteCIDRs(vpcTunnel), vpcTunnel.Spec.SourceCIDRs, vpcTunnel.Spec.FwMark), genSnatOp(vpcTunnel, natBackend, specRemoteCIDRs(vpcTunnel), GlobalEgressIP, vpcTunnel.Spec.OverlayMappings, ingress)))
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		err = r.syncVpcRoute(ctx, vpcTunnel, natGw)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
 
 		vpcTunnel.Status.Initialized = true
+		vpcTunnel.Status.Vpc = natGw.Spec.Vpc
+		vpcTunnel.Status.LanIP = natGw.Spec.LanIP
+		vpcTunnel.Status.NatBackend = natBackend
 		vpcTunnel.Status.RemoteIP = vpcTunnel.Spec.RemoteIP
-		vpcTunnel.Status.RemoteGlobalnetCIDR = vpcTunnel.Spec.RemoteGlobalnetCIDR
-		vpcTunnel.Status.InterfaceAddr = vpcTunnel.Spec.InterfaceAddr
+		vpcTunnel.Status.RemoteGlobalnetCIDR = specRemoteGlobalnetCIDR(vpcTunnel)
+		vpcTunnel.Status.RemoteCIDRs = specRemoteCIDRs(vpcTunnel)
+		vpcTunnel.Status.SourceCIDRs = vpcTunnel.Spec.SourceCIDRs
+		vpcTunnel.Status.FwMark = vpcTunnel.Spec.FwMark
+		vpcTunnel.Status.Mode = specMode(vpcTunnel)
+		vpcTunnel.Status.OverlayMappings = vpcTunnel.Spec.OverlayMappings
+		vpcTunnel.Status.GlobalIPPool = specGlobalIPPool(vpcTunnel)
+		vpcTunnel.Status.Ingress = ingress
+		vpcTunnel.Status.RouteTable = routeTable(vpcTunnel)
+		vpcTunnel.Status.InterfaceAddr = specInterfaceAddr(vpcTunnel)
 		vpcTunnel.Status.NatGwDp = vpcTunnel.Spec.NatGwDp
 		vpcTunnel.Status.Type = vpcTunnel.Spec.Type
 		r.Status().Update(ctx, vpcTunnel)
 
-	} else if vpcTunnel.Status.Initialized && (vpcTunnel.Status.RemoteIP != vpcTunnel.Spec.RemoteIP || vpcTunnel.Status.InterfaceAddr != vpcTunnel.Spec.InterfaceAddr ||
-		vpcTunnel.Status.NatGwDp != vpcTunnel.Spec.NatGwDp || vpcTunnel.Status.RemoteGlobalnetCIDR != vpcTunnel.Spec.RemoteGlobalnetCIDR) {
+	} else if vpcTunnel.Status.Initialized && tunnelSpecChanged(vpcTunnel) {
 		if vpcTunnel.Status.Type != vpcTunnel.Spec.Type {
 			log.Log.Error(errors.New("tunnel type should not change"), fmt.Sprintf("tunnel :%#v\n", vpcTunnel))
 			vpcTunnel.Spec.Type = vpcTunnel.Status.Type
@@ -312,109 +579,257 @@ func (r *VpcNatTunnelReconciler) handleCreateOrUpdate(ctx context.Context, vpcTu
 				return ctrl.Result{}, err
 			}
 		}
-		if vpcTunnel.Status.NatGwDp == vpcTunnel.Spec.NatGwDp { // NatGwDp not change
+		// tunnels routed in the main table are rebuilt, which moves them to a table of their own
+		if !tunnelEndpointChanged(vpcTunnel) && vpcTunnel.Status.RouteTable != 0 { // only the prefixes changed
+			podnext, err := r.getNatGwPod(vpcTunnel.Spec.NatGwDp)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			// prefixes kept in the lists stay routed while the others are added and removed
+			if cmd := genUpdatePrefixesCmd(vpcTunnel); cmd != "" {
+				err = r.execCommandInPod(podnext.Name, podnext.Namespace, r.opts().NatGwContainer, cmd)
+				if err != nil {
+					return ctrl.Result{}, err
+				}
+			}
+			err = r.syncVpcRoute(ctx, vpcTunnel, natGw)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+
+			vpcTunnel.Status.RemoteGlobalnetCIDR = specRemoteGlobalnetCIDR(vpcTunnel)
+			vpcTunnel.Status.RemoteCIDRs = specRemoteCIDRs(vpcTunnel)
+			vpcTunnel.Status.SourceCIDRs = vpcTunnel.Spec.SourceCIDRs
+			vpcTunnel.Status.Vpc = natGw.Spec.Vpc
+			vpcTunnel.Status.LanIP = natGw.Spec.LanIP
+			r.Status().Update(ctx, vpcTunnel)
+
+		} else if vpcTunnel.Status.NatGwDp == vpcTunnel.Spec.NatGwDp && !globalnetSourceChanged(vpcTunnel) { // NatGwDp not change
 			podnext, err := r.getNatGwPod(vpcTunnel.Spec.NatGwDp) // find pod named Spec.NatGwDp
 			if err != nil {
 				return ctrl.Result{}, err
 			}
-			err = r.execCommandInPod(podnext.Name, podnext.Namespace, "vpc-nat-gw", genDelGlobalnetRoute(vpcTunnel.Status.GlobalnetCIDR, vpcTunnel.Status.OvnGwIP, vpcTunnel.Status.RemoteGlobalnetCIDR, vpcTunnel.Name, vpcTunnel.Status.GlobalEgressIP))
+			ingress, err := r.resolveIngress(ctx, vpcTunnel)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			// the in-flow route is put back right after
+			err = r.execCommandInPod(podnext.Name, podnext.Namespace, r.opts().NatGwContainer, r.genTeardownCmd(vpcTunnel, true))
 			if err != nil {
 				return ctrl.Result{}, err
 			}
-			err = r.execCommandInPod(podnext.Name, podnext.Namespace, "vpc-nat-gw", r.genDeleteTunnelCmd(vpcTunnel))
+			err = r.execCommandInPod(podnext.Name, podnext.Namespace, r.opts().NatGwContainer, r.genCreateTunnelCmd(vpcTunnel))
 			if err != nil {
 				return ctrl.Result{}, err
 			}
-			err = r.execCommandInPod(podnext.Name, podnext.Namespace, "vpc-nat-gw", r.genCreateTunnelCmd(vpcTunnel))
+			err = r.execCommandInPod(podnext.Name, podnext.Namespace, r.opts().NatGwContainer, genGlobalnetRoute(vpcTunnel.Status.GlobalnetCIDR, vpcTunnel.Status.OvnGwIP, genPolicyRoute(vpcTunnel, specRemoteCIDRs(vpcTunnel), vpcTunnel.Spec.SourceCIDRs, vpcTunnel.Spec.FwMark), genSnatOp(vpcTunnel, vpcTunnel.Status.NatBackend, specRemoteCIDRs(vpcTunnel), vpcTunnel.Status.GlobalEgressIP, vpcTunnel.Spec.OverlayMappings, ingress)))
 			if err != nil {
 				return ctrl.Result{}, err
 			}
-			err = r.execCommandInPod(podnext.Name, podnext.Namespace, "vpc-nat-gw", genGlobalnetRoute(vpcTunnel.Status.GlobalnetCIDR, vpcTunnel.Status.OvnGwIP, vpcTunnel.Spec.RemoteGlobalnetCIDR, vpcTunnel.Name, vpcTunnel.Status.GlobalEgressIP))
+			err = r.syncVpcRoute(ctx, vpcTunnel, natGw)
 			if err != nil {
 				return ctrl.Result{}, err
 			}
 
 			vpcTunnel.Status.RemoteIP = vpcTunnel.Spec.RemoteIP
-			vpcTunnel.Status.RemoteGlobalnetCIDR = vpcTunnel.Spec.RemoteGlobalnetCIDR
-			vpcTunnel.Status.InterfaceAddr = vpcTunnel.Spec.InterfaceAddr
+			vpcTunnel.Status.RemoteGlobalnetCIDR = specRemoteGlobalnetCIDR(vpcTunnel)
+			vpcTunnel.Status.RemoteCIDRs = specRemoteCIDRs(vpcTunnel)
+			vpcTunnel.Status.SourceCIDRs = vpcTunnel.Spec.SourceCIDRs
+			vpcTunnel.Status.FwMark = vpcTunnel.Spec.FwMark
+			vpcTunnel.Status.Mode = specMode(vpcTunnel)
+			vpcTunnel.Status.OverlayMappings = vpcTunnel.Spec.OverlayMappings
+			vpcTunnel.Status.GlobalIPPool = specGlobalIPPool(vpcTunnel)
+			vpcTunnel.Status.Ingress = ingress
+			vpcTunnel.Status.RouteTable = routeTable(vpcTunnel)
+			vpcTunnel.Status.InterfaceAddr = specInterfaceAddr(vpcTunnel)
 			vpcTunnel.Status.NatGwDp = vpcTunnel.Spec.NatGwDp
+			vpcTunnel.Status.Vpc = natGw.Spec.Vpc
+			vpcTunnel.Status.LanIP = natGw.Spec.LanIP
 			r.Status().Update(ctx, vpcTunnel)
 
-		} else { // change the gw pod
+		} else { // change the gw pod, or where the globalnet addresses come from
 			// update
 			podlast, err := r.getNatGwPod(vpcTunnel.Status.NatGwDp) // find pod named Status.NatGwDp
+			switch {
+			case k8serrors.IsNotFound(err):
+				// the old gateway is gone, and its tunnel state with it
+			case err != nil:
+				return ctrl.Result{}, err
+			default:
+				cmd, err := r.tunnelTeardownCmd(ctx, vpcTunnel)
+				if err != nil {
+					return ctrl.Result{}, err
+				}
+				err = r.execCommandInPod(podlast.Name, podlast.Namespace, r.opts().NatGwContainer, cmd)
+				if err != nil {
+					return ctrl.Result{}, err
+				}
+			}
+
+			natGw, podnext, err := r.resolveNatGw(ctx, vpcTunnel.Spec.NatGwDp)
 			if err != nil {
 				return ctrl.Result{}, err
 			}
-			err = r.execCommandInPod(podlast.Name, podlast.Namespace, "vpc-nat-gw", genDelGlobalnetRoute(vpcTunnel.Status.GlobalnetCIDR, vpcTunnel.Status.OvnGwIP, vpcTunnel.Spec.RemoteGlobalnetCIDR, vpcTunnel.Name, vpcTunnel.Status.GlobalEgressIP))
+
+			// find local cluster GlobalnetCIDR
+			GlobalnetCIDR, GlobalEgressIP, err := r.resolveGlobalnet(ctx, vpcTunnel, natGw.Spec.Vpc)
 			if err != nil {
 				return ctrl.Result{}, err
 			}
-			err = r.execCommandInPod(podlast.Name, podlast.Namespace, "vpc-nat-gw", r.genDeleteTunnelCmd(vpcTunnel))
+			vpcTunnel.Status.GlobalnetCIDR = GlobalnetCIDR
+			vpcTunnel.Status.GlobalEgressIP = GlobalEgressIP
+			ovnGwIP, err := r.getOvnGwIP(ctx, natGw, podnext)
 			if err != nil {
 				return ctrl.Result{}, err
 			}
-
-			podnext, err := r.getNatGwPod(vpcTunnel.Spec.NatGwDp) // find pod named Status.NatGwDp
+			vpcTunnel.Status.OvnGwIP = ovnGwIP
+			GwExternIP, err := r.getNatGwExternIP(natGw, podnext)
 			if err != nil {
 				return ctrl.Result{}, err
 			}
-
-			// find local cluster GlobalnetCIDR
-			GlobalnetCIDR, err := r.getGlobalnetCIDR()
+			vpcTunnel.Status.InternalIP = GwExternIP
+			natBackend, err := r.selectNatBackend(natGw, podnext)
 			if err != nil {
 				return ctrl.Result{}, err
 			}
-			vpcTunnel.Status.GlobalnetCIDR = GlobalnetCIDR
-			ovnGwIP, err := r.getPodGwIP(podnext)
+
+			ingress, err := r.resolveIngress(ctx, vpcTunnel)
 			if err != nil {
 				return ctrl.Result{}, err
 			}
-			vpcTunnel.Status.OvnGwIP = ovnGwIP
-			GlobalEgressIP, err := r.getGlobalEgressIP()
+
+			err = r.collectLeftovers(ctx, vpcTunnel.Spec.NatGwDp, podnext)
 			if err != nil {
 				return ctrl.Result{}, err
 			}
-			vpcTunnel.Status.GlobalEgressIP = GlobalEgressIP
-			GwExternIP, err := r.getGwExternIP(podnext)
+			err = r.execCommandInPod(podnext.Name, podnext.Namespace, r.opts().NatGwContainer, r.genCreateTunnelCmd(vpcTunnel))
 			if err != nil {
 				return ctrl.Result{}, err
 			}
-			vpcTunnel.Status.InternalIP = GwExternIP
-
-			err = r.execCommandInPod(podnext.Name, podnext.Namespace, "vpc-nat-gw", r.genCreateTunnelCmd(vpcTunnel))
+			err = r.execCommandInPod(podnext.Name, podnext.Namespace, r.opts().NatGwContainer, genGlobalnetRoute(GlobalnetCIDR, ovnGwIP, genPolicyRoute(vpcTunnel, specRemoteCIDRs(vpcTunnel), vpcTunnel.Spec.SourceCIDRs, vpcTunnel.Spec.FwMark), genSnatOp(vpcTunnel, natBackend, specRemoteCIDRs(vpcTunnel), GlobalEgressIP, vpcTunnel.Spec.OverlayMappings, ingress)))
 			if err != nil {
 				return ctrl.Result{}, err
 			}
-			err = r.execCommandInPod(podnext.Name, podnext.Namespace, "vpc-nat-gw", genGlobalnetRoute(GlobalnetCIDR, ovnGwIP, vpcTunnel.Spec.RemoteGlobalnetCIDR, vpcTunnel.Name, GlobalEgressIP))
+			err = r.syncVpcRoute(ctx, vpcTunnel, natGw)
 			if err != nil {
 				return ctrl.Result{}, err
 			}
 
+			if vpcTunnel.Status.GlobalIPPool != specGlobalIPPool(vpcTunnel) {
+				// the addresses of the old pool are no longer in use on any gateway
+				err = r.releaseFromPool(ctx, vpcTunnel, vpcTunnel.Status.GlobalIPPool)
+				if err != nil {
+					return ctrl.Result{}, err
+				}
+			}
+
 			vpcTunnel.Status.RemoteIP = vpcTunnel.Spec.RemoteIP
-			vpcTunnel.Status.RemoteGlobalnetCIDR = vpcTunnel.Spec.RemoteGlobalnetCIDR
-			vpcTunnel.Status.InterfaceAddr = vpcTunnel.Spec.InterfaceAddr
+			vpcTunnel.Status.RemoteGlobalnetCIDR = specRemoteGlobalnetCIDR(vpcTunnel)
+			vpcTunnel.Status.RemoteCIDRs = specRemoteCIDRs(vpcTunnel)
+			vpcTunnel.Status.SourceCIDRs = vpcTunnel.Spec.SourceCIDRs
+			vpcTunnel.Status.FwMark = vpcTunnel.Spec.FwMark
+			vpcTunnel.Status.Mode = specMode(vpcTunnel)
+			vpcTunnel.Status.OverlayMappings = vpcTunnel.Spec.OverlayMappings
+			vpcTunnel.Status.GlobalIPPool = specGlobalIPPool(vpcTunnel)
+			vpcTunnel.Status.Ingress = ingress
+			vpcTunnel.Status.RouteTable = routeTable(vpcTunnel)
+			vpcTunnel.Status.InterfaceAddr = specInterfaceAddr(vpcTunnel)
 			vpcTunnel.Status.NatGwDp = vpcTunnel.Spec.NatGwDp
+			vpcTunnel.Status.Vpc = natGw.Spec.Vpc
+			vpcTunnel.Status.LanIP = natGw.Spec.LanIP
+			vpcTunnel.Status.NatBackend = natBackend
 			r.Status().Update(ctx, vpcTunnel)
 		}
+	} else {
+		if statusMode(vpcTunnel) == kubeovnv1.ModeGlobalnet {
+			// Submariner or the pool may have changed the globalnet CIDR or the egress IPs since the tunnel was provisioned
+			err = r.refreshGlobalnet(ctx, vpcTunnel)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		// the ingress rules change with the spec and with the GlobalIngressIPs they follow
+		err = r.refreshIngress(ctx, vpcTunnel)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
 	}
 	return ctrl.Result{}, nil
 }
 
+// tunnelSpecChanged reports whether the spec differs from what was provisioned on the gateway
+func tunnelSpecChanged(vpcTunnel *kubeovnv1.VpcNatTunnel) bool {
+	return tunnelEndpointChanged(vpcTunnel) || !cidr.Equal(statusRemoteCIDRs(vpcTunnel), specRemoteCIDRs(vpcTunnel)) ||
+		!cidr.Equal(vpcTunnel.Status.SourceCIDRs, vpcTunnel.Spec.SourceCIDRs)
+}
+
+// tunnelEndpointChanged reports whether the tunnel has to be rebuilt, rather than have its prefixes updated
+func tunnelEndpointChanged(vpcTunnel *kubeovnv1.VpcNatTunnel) bool {
+	return vpcTunnel.Status.RemoteIP != vpcTunnel.Spec.RemoteIP || vpcTunnel.Status.InterfaceAddr != specInterfaceAddr(vpcTunnel) ||
+		vpcTunnel.Status.NatGwDp != vpcTunnel.Spec.NatGwDp || vpcTunnel.Status.FwMark != vpcTunnel.Spec.FwMark ||
+		globalnetSourceChanged(vpcTunnel) || !slices.Equal(vpcTunnel.Status.OverlayMappings, vpcTunnel.Spec.OverlayMappings)
+}
+
+// specMode returns the mode the spec asks for, globalnet unless set
+func specMode(vpcTunnel *kubeovnv1.VpcNatTunnel) string {
+	if vpcTunnel.Spec.Mode == "" {
+		return kubeovnv1.ModeGlobalnet
+	}
+	return vpcTunnel.Spec.Mode
+}
+
+// statusMode returns the mode the tunnel was provisioned in. Tunnels provisioned before modes used globalnet.
+func statusMode(vpcTunnel *kubeovnv1.VpcNatTunnel) string {
+	if vpcTunnel.Status.Mode == "" {
+		return kubeovnv1.ModeGlobalnet
+	}
+	return vpcTunnel.Status.Mode
+}
+
+// specRemoteCIDRs returns the remote prefixes the spec asks to route through the tunnel
+func specRemoteCIDRs(vpcTunnel *kubeovnv1.VpcNatTunnel) []string {
+	return cidr.Dedup([]string{specRemoteGlobalnetCIDR(vpcTunnel)}, vpcTunnel.Spec.RemoteCIDRs)
+}
+
+// statusRemoteCIDRs returns the remote prefixes provisioned for the tunnel. Tunnels provisioned before prefix
+// lists only recorded RemoteGlobalnetCIDR.
+func statusRemoteCIDRs(vpcTunnel *kubeovnv1.VpcNatTunnel) []string {
+	if len(vpcTunnel.Status.RemoteCIDRs) != 0 {
+		return vpcTunnel.Status.RemoteCIDRs
+	}
+	return cidr.Dedup([]string{vpcTunnel.Status.RemoteGlobalnetCIDR})
+}
+
 func (r *VpcNatTunnelReconciler) handleDelete(ctx context.Context, vpcTunnel *kubeovnv1.VpcNatTunnel) (ctrl.Result, error) {
 	if containsString(vpcTunnel.ObjectMeta.Finalizers, "tunnel.finalizer.ustc.io") {
-		// TODO: implement clean up the GRE tunnel before deletion
-		pod, err := r.getNatGwPod(vpcTunnel.Spec.NatGwDp)
+		err := r.teardown(ctx, vpcTunnel)
 		if err != nil {
-			return ctrl.Result{}, err
+			if vpcTunnel.Annotations[kubeovnv1.ForceDeleteAnnotation] != "true" {
+				return ctrl.Result{}, err
+			}
+			log.FromContext(ctx).Error(err, "force deleting tunnel, its gateway state is left for garbage collection", "tunnel", vpcTunnel.Name)
+			err = r.recordLeftover(ctx, provisionedNatGw(vpcTunnel), vpcTunnel.Status.InterfaceName, r.leftoverTeardownCmd(vpcTunnel))
+			if err != nil {
+				return ctrl.Result{}, err
+			}
 		}
-		err = r.execCommandInPod(pod.Name, pod.Namespace, "vpc-nat-gw", genDelGlobalnetRoute(vpcTunnel.Status.GlobalnetCIDR, vpcTunnel.Status.OvnGwIP, vpcTunnel.Status.RemoteGlobalnetCIDR, vpcTunnel.Name, vpcTunnel.Status.GlobalEgressIP))
+
+		err = r.removeVpcRoutes(ctx, vpcTunnel, vpcTunnel.Status.Vpc, statusRemoteCIDRs(vpcTunnel), vpcTunnel.Status.LanIP)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
-		err = r.execCommandInPod(pod.Name, pod.Namespace, "vpc-nat-gw", r.genDeleteTunnelCmd(vpcTunnel))
-		if err != nil {
-			return ctrl.Result{}, err
+		// a tunnel that never finished provisioning may hold addresses of the pool named in its spec only
+		for _, pool := range []string{vpcTunnel.Status.GlobalIPPool, vpcTunnel.Spec.GlobalIPPool} {
+			err = r.releaseFromPool(ctx, vpcTunnel, pool)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		for _, pool := range []string{vpcTunnel.Status.InterfaceAddrPool, vpcTunnel.Spec.InterfaceAddrPool} {
+			err = r.releaseInterfaceAddr(ctx, vpcTunnel, pool)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
 		}
 
 		controllerutil.RemoveFinalizer(vpcTunnel, "tunnel.finalizer.ustc.io")
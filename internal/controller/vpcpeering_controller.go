@@ -0,0 +1,307 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	kubeovnv1 "multi-vpc/api/v1"
+	"multi-vpc/internal/options"
+)
+
+const (
+	peeringFinalizer = "peering.finalizer.ustc.io"
+
+	// the remote cluster is not watched, its end is checked again after peeringResyncPeriod
+	peeringResyncPeriod = 5 * time.Minute
+	// peeringRetryPeriod spaces out retries of peerings waiting on one of their ends
+	peeringRetryPeriod = 30 * time.Second
+)
+
+// VpcPeeringReconciler reconciles a VpcPeering object
+type VpcPeeringReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Options names the kube-ovn and Submariner objects, on both clusters. Defaults to options.NewOptions()
+	Options *options.Options
+	// RemoteClient builds the client of the remote cluster from its kubeconfig, defaults to newRemoteClient
+	RemoteClient RemoteClientFunc
+	// APIReader reads the kubeconfig Secrets, whose data the manager cache does not hold. Defaults to the Client
+	APIReader client.Reader
+	// remoteClients keeps the clients of the remote clusters across reconciles
+	remoteClients remoteClients
+}
+
+//+kubebuilder:rbac:groups=kubeovn.ustc.io,resources=vpcpeerings,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=kubeovn.ustc.io,resources=vpcpeerings/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=kubeovn.ustc.io,resources=vpcpeerings/finalizers,verbs=update
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+
+// Reconcile provisions the VpcNatTunnels of both ends of a VpcPeering, each pointing at the gateway and globalnet
+// CIDR of the other, and removes the remote one when the peering is deleted. The local one is owned by the peering.
+func (r *VpcPeeringReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	peering := &kubeovnv1.VpcPeering{}
+	err := r.Get(ctx, req.NamespacedName, peering)
+	if err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !peering.DeletionTimestamp.IsZero() {
+		return r.handleDelete(ctx, peering)
+	}
+
+	if !controllerutil.ContainsFinalizer(peering, peeringFinalizer) {
+		controllerutil.AddFinalizer(peering, peeringFinalizer)
+		if err := r.Update(ctx, peering); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	err = r.syncPeering(ctx, peering)
+	condition := metav1.Condition{
+		Type:    kubeovnv1.ConditionPeeringReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  "TunnelsSynced",
+		Message: fmt.Sprintf("tunnels %s and %s are in sync", peering.Status.Local.Tunnel, peering.Status.Remote.Tunnel),
+	}
+	var notReady *peeringError
+	switch {
+	case errors.As(err, &notReady):
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = notReady.reason
+		condition.Message = err.Error()
+	case err != nil:
+		return ctrl.Result{}, err
+	}
+	meta.SetStatusCondition(&peering.Status.Conditions, condition)
+	if err := r.Status().Update(ctx, peering); err != nil {
+		return ctrl.Result{}, err
+	}
+	if notReady != nil {
+		log.FromContext(ctx).Info("peering not ready", "reason", notReady.reason, "error", notReady.err)
+		return ctrl.Result{RequeueAfter: peeringRetryPeriod}, nil
+	}
+	return ctrl.Result{RequeueAfter: peeringResyncPeriod}, nil
+}
+
+// syncPeering looks up both ends and creates or updates their tunnels, recording what it found in the status. With
+// an InterfaceAddrPool the remote tunnel waits for the block the local one is allocated.
+func (r *VpcPeeringReconciler) syncPeering(ctx context.Context, peering *kubeovnv1.VpcPeering) error {
+	var localAddr, remoteAddr string
+	if peering.Spec.InterfaceAddrPool == "" {
+		var err error
+		localAddr, remoteAddr, err = transitAddrs(peering.Spec.TransitCIDR)
+		if err != nil {
+			return &peeringError{reason: "InvalidTransitCIDR", err: err}
+		}
+	}
+	remote, err := r.remoteClient(ctx, peering)
+	if err != nil {
+		return err
+	}
+
+	local := kubeovnv1.PeeringEnd{Tunnel: localTunnelKey(peering).String(), InterfaceAddr: localAddr}
+	if err := resolvePeeringEnd(ctx, r.Client, r.Options, "Local", "local end", peering.Spec.NatGwDp, peering.Spec.GlobalnetCIDR, &local); err != nil {
+		return err
+	}
+	peer := kubeovnv1.PeeringEnd{Tunnel: remoteTunnelKey(peering).String(), InterfaceAddr: remoteAddr}
+	if err := resolvePeeringEnd(ctx, remote, r.Options, "Remote", "remote end", peering.Spec.Remote.NatGwDp, peering.Spec.Remote.GlobalnetCIDR, &peer); err != nil {
+		return err
+	}
+	if err := r.deleteStaleRemoteTunnel(ctx, peering); err != nil {
+		return err
+	}
+	peering.Status.Local, peering.Status.Remote = local, peer
+	peering.Status.RemoteKubeconfigSecret = peering.Spec.Remote.KubeconfigSecret
+
+	if err := r.syncTunnel(ctx, r.Client, peering, localTunnelKey(peering), peering.Spec.NatGwDp, local, peer, true); err != nil {
+		return err
+	}
+	if peering.Spec.InterfaceAddrPool != "" {
+		if err := r.allocatedAddrs(ctx, peering, &local, &peer); err != nil {
+			return err
+		}
+		peering.Status.Local, peering.Status.Remote = local, peer
+	}
+	return r.syncTunnel(ctx, remote, peering, remoteTunnelKey(peering), peering.Spec.Remote.NatGwDp, peer, local, false)
+}
+
+// handleDelete removes the remote tunnel, the local one goes with the peering through its owner reference. With the
+// force-delete annotation the peering is released even if the remote cluster cannot be reached.
+func (r *VpcPeeringReconciler) handleDelete(ctx context.Context, peering *kubeovnv1.VpcPeering) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(peering, peeringFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	err := r.deleteStaleRemoteTunnel(ctx, peering)
+	if err == nil {
+		err = r.deleteRemoteTunnel(ctx, peering)
+	}
+	if err != nil {
+		if peering.Annotations[kubeovnv1.ForceDeleteAnnotation] != "true" {
+			return ctrl.Result{}, err
+		}
+		log.FromContext(ctx).Error(err, "remote tunnel left behind by forced delete", "tunnel", remoteTunnelKey(peering))
+	}
+	controllerutil.RemoveFinalizer(peering, peeringFinalizer)
+	return ctrl.Result{}, r.Update(ctx, peering)
+}
+
+func (r *VpcPeeringReconciler) deleteRemoteTunnel(ctx context.Context, peering *kubeovnv1.VpcPeering) error {
+	remote, err := r.remoteClient(ctx, peering)
+	if err != nil {
+		return err
+	}
+	return deleteManagedTunnel(ctx, remote, remoteTunnelKey(peering), kubeovnv1.VpcPeeringLabel, peering.Name)
+}
+
+// deleteStaleRemoteTunnel deletes the remote tunnel recorded in status if the spec moved it to another namespace
+// or cluster. Peerings that did not record their Secret were provisioned with the current one.
+func (r *VpcPeeringReconciler) deleteStaleRemoteTunnel(ctx context.Context, peering *kubeovnv1.VpcPeering) error {
+	secret := peering.Status.RemoteKubeconfigSecret
+	if secret == "" {
+		secret = peering.Spec.Remote.KubeconfigSecret
+	}
+	previous := peering.Status.Remote.Tunnel
+	if previous == "" || (previous == remoteTunnelKey(peering).String() && secret == peering.Spec.Remote.KubeconfigSecret) {
+		return nil
+	}
+	namespace, name, ok := strings.Cut(previous, "/")
+	if !ok {
+		return nil
+	}
+	remote, err := r.clusterClient(ctx, peering.Namespace, secret)
+	if err != nil {
+		return err
+	}
+	key := types.NamespacedName{Namespace: namespace, Name: name}
+	if err := deleteManagedTunnel(ctx, remote, key, kubeovnv1.VpcPeeringLabel, peering.Name); err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("deleted stale remote tunnel", "tunnel", key, "kubeconfigSecret", secret)
+	peering.Status.Remote, peering.Status.RemoteKubeconfigSecret = kubeovnv1.PeeringEnd{}, ""
+	return nil
+}
+
+// allocatedAddrs takes the interface addresses of both ends from the block the local tunnel was allocated from the
+// InterfaceAddrPool of the peering
+func (r *VpcPeeringReconciler) allocatedAddrs(ctx context.Context, peering *kubeovnv1.VpcPeering, local, peer *kubeovnv1.PeeringEnd) error {
+	localTunnel := &kubeovnv1.VpcNatTunnel{}
+	if err := r.Get(ctx, localTunnelKey(peering), localTunnel); err != nil {
+		return err
+	}
+	if localTunnel.Status.InterfaceAddrPool != peering.Spec.InterfaceAddrPool || localTunnel.Status.RemoteInterfaceAddr == "" {
+		return &peeringError{reason: "InterfaceAddrPending", err: fmt.Errorf("VpcNatTunnel %s has no block of InterfaceAddrPool %s yet", localTunnelKey(peering), peering.Spec.InterfaceAddrPool)}
+	}
+	local.InterfaceAddr, peer.InterfaceAddr = localTunnel.Status.AllocatedInterfaceAddr, localTunnel.Status.RemoteInterfaceAddr
+	return nil
+}
+
+// remoteClient returns the client of the remote cluster from the kubeconfig Secret of the peering
+func (r *VpcPeeringReconciler) remoteClient(ctx context.Context, peering *kubeovnv1.VpcPeering) (client.Client, error) {
+	return r.clusterClient(ctx, peering.Namespace, peering.Spec.Remote.KubeconfigSecret)
+}
+
+func (r *VpcPeeringReconciler) clusterClient(ctx context.Context, namespace, secret string) (client.Client, error) {
+	reader := r.APIReader
+	if reader == nil {
+		reader = r.Client
+	}
+	return r.remoteClients.clusterClient(ctx, reader, r.Scheme, r.RemoteClient, namespace, secret)
+}
+
+// syncTunnel creates or updates the tunnel of one end, the local one is owned by the peering
+func (r *VpcPeeringReconciler) syncTunnel(ctx context.Context, c client.Client, peering *kubeovnv1.VpcPeering, key types.NamespacedName, natGwDp string, self, peer kubeovnv1.PeeringEnd, owned bool) error {
+	return syncManagedTunnel(ctx, c, key, kubeovnv1.VpcPeeringLabel, peering.Name, func(vpcTunnel *kubeovnv1.VpcNatTunnel) error {
+		vpcTunnel.Spec.NatGwDp = natGwDp
+		vpcTunnel.Spec.InterfaceAddr = self.InterfaceAddr
+		if owned {
+			vpcTunnel.Spec.InterfaceAddrPool = peering.Spec.InterfaceAddrPool
+		}
+		vpcTunnel.Spec.RemoteIP = peer.GatewayIP
+		vpcTunnel.Spec.RemoteGlobalnetCIDR = peer.GlobalnetCIDR
+		vpcTunnel.Spec.Type = peering.Spec.Type
+		if vpcTunnel.Spec.Type == "" {
+			vpcTunnel.Spec.Type = "gre"
+		}
+		if owned {
+			return controllerutil.SetControllerReference(peering, vpcTunnel, r.Scheme)
+		}
+		return nil
+	})
+}
+
+func localTunnelKey(peering *kubeovnv1.VpcPeering) types.NamespacedName {
+	return types.NamespacedName{Namespace: peering.Namespace, Name: peering.Name}
+}
+
+func remoteTunnelKey(peering *kubeovnv1.VpcPeering) types.NamespacedName {
+	namespace := peering.Spec.Remote.Namespace
+	if namespace == "" {
+		namespace = peering.Namespace
+	}
+	return types.NamespacedName{Namespace: namespace, Name: peering.Name}
+}
+
+// SetupWithManager sets up the controller with the Manager. Only the metadata of Secrets is watched and cached,
+// and the status updates of the local tunnel do not concern the peering.
+func (r *VpcPeeringReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.APIReader == nil {
+		r.APIReader = mgr.GetAPIReader()
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&kubeovnv1.VpcPeering{}).
+		Owns(&kubeovnv1.VpcNatTunnel{},
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.secretToPeerings),
+			builder.OnlyMetadata).
+		Complete(r)
+}
+
+// secretToPeerings maps a kubeconfig Secret to the peerings using it
+func (r *VpcPeeringReconciler) secretToPeerings(ctx context.Context, obj client.Object) []reconcile.Request {
+	peerings := &kubeovnv1.VpcPeeringList{}
+	if err := r.List(ctx, peerings, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list peerings", "secret", obj.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, peering := range peerings.Items {
+		if peering.Spec.Remote.KubeconfigSecret == obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&peering)})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,351 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	ovn "github.com/kubeovn/kube-ovn/pkg/apis/kubeovn/v1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	Submariner "github.com/submariner-io/submariner/pkg/apis/submariner.io/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kubeovnv1 "multi-vpc/api/v1"
+)
+
+// peeringGateway returns the objects of a running vpc-nat-gw named gw1 with the given external address
+func peeringGateway(externIP string) []client.Object {
+	return []client.Object{
+		&ovn.VpcNatGateway{
+			ObjectMeta: metav1.ObjectMeta{Name: "gw1"},
+			Spec:       ovn.VpcNatSpec{Vpc: "vpc1", ExternalSubnets: []string{"ext1"}},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "vpc-nat-gw-gw1", Namespace: "kube-system"},
+			Spec:       appsv1.StatefulSetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "gw1"}}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "vpc-nat-gw-gw1-0",
+				Namespace:   "kube-system",
+				Labels:      map[string]string{"app": "gw1"},
+				Annotations: map[string]string{"ext1.kube-system.kubernetes.io/ip_address": externIP},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+	}
+}
+
+var _ = Describe("VpcPeering", func() {
+	var (
+		ctx        context.Context
+		scheme     *runtime.Scheme
+		remote     client.Client
+		reconciler *VpcPeeringReconciler
+		peering    *kubeovnv1.VpcPeering
+		req        ctrl.Request
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme = runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(kubeovnv1.AddToScheme(scheme)).To(Succeed())
+		Expect(ovn.AddToScheme(scheme)).To(Succeed())
+		Expect(Submariner.AddToScheme(scheme)).To(Succeed())
+
+		peering = &kubeovnv1.VpcPeering{
+			ObjectMeta: metav1.ObjectMeta{Name: "p1", Namespace: "ns1", UID: "p1-uid"},
+			Spec: kubeovnv1.VpcPeeringSpec{
+				NatGwDp:       "gw1",
+				TransitCIDR:   "10.100.0.0/30",
+				GlobalnetCIDR: "242.0.0.0/16",
+				Remote: kubeovnv1.PeeringRemote{
+					KubeconfigSecret: "cluster2",
+					Namespace:        "ns2",
+					NatGwDp:          "gw1",
+					GlobalnetCIDR:    "242.1.0.0/16",
+				},
+			},
+		}
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster2", Namespace: "ns1"},
+			Data:       map[string][]byte{kubeovnv1.KubeconfigSecretKey: []byte("kubeconfig of cluster2")},
+		}
+		local := fake.NewClientBuilder().WithScheme(scheme).
+			WithStatusSubresource(&kubeovnv1.VpcPeering{}, &kubeovnv1.VpcNatTunnel{}).
+			WithObjects(append(peeringGateway("172.18.0.10"), peering, secret)...).Build()
+		remote = fake.NewClientBuilder().WithScheme(scheme).WithObjects(peeringGateway("172.19.0.20")...).Build()
+		reconciler = &VpcPeeringReconciler{
+			Client: local,
+			Scheme: scheme,
+			RemoteClient: func(kubeconfig []byte, _ *runtime.Scheme) (client.Client, error) {
+				Expect(string(kubeconfig)).To(Equal("kubeconfig of cluster2"))
+				return remote, nil
+			},
+		}
+		req = ctrl.Request{NamespacedName: types.NamespacedName{Name: "p1", Namespace: "ns1"}}
+	})
+
+	It("should provision mirrored tunnels on both clusters", func() {
+		result, err := reconciler.Reconcile(ctx, req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(peeringResyncPeriod))
+
+		localTunnel := &kubeovnv1.VpcNatTunnel{}
+		Expect(reconciler.Get(ctx, types.NamespacedName{Name: "p1", Namespace: "ns1"}, localTunnel)).To(Succeed())
+		Expect(localTunnel.Spec).To(Equal(kubeovnv1.VpcNatTunnelSpec{
+			NatGwDp:             "gw1",
+			InterfaceAddr:       "10.100.0.1/30",
+			RemoteIP:            "172.19.0.20",
+			RemoteGlobalnetCIDR: "242.1.0.0/16",
+			Type:                "gre",
+		}))
+		Expect(metav1.IsControlledBy(localTunnel, peering)).To(BeTrue())
+
+		remoteTunnel := &kubeovnv1.VpcNatTunnel{}
+		Expect(remote.Get(ctx, types.NamespacedName{Name: "p1", Namespace: "ns2"}, remoteTunnel)).To(Succeed())
+		Expect(remoteTunnel.Spec).To(Equal(kubeovnv1.VpcNatTunnelSpec{
+			NatGwDp:             "gw1",
+			InterfaceAddr:       "10.100.0.2/30",
+			RemoteIP:            "172.18.0.10",
+			RemoteGlobalnetCIDR: "242.0.0.0/16",
+			Type:                "gre",
+		}))
+		Expect(remoteTunnel.Labels).To(HaveKeyWithValue(kubeovnv1.VpcPeeringLabel, "p1"))
+
+		stored := &kubeovnv1.VpcPeering{}
+		Expect(reconciler.Get(ctx, req.NamespacedName, stored)).To(Succeed())
+		Expect(stored.Finalizers).To(ContainElement(peeringFinalizer))
+		Expect(meta.IsStatusConditionTrue(stored.Status.Conditions, kubeovnv1.ConditionPeeringReady)).To(BeTrue())
+		Expect(stored.Status.Remote.Tunnel).To(Equal("ns2/p1"))
+	})
+
+	It("should put hand-edited tunnels back in sync", func() {
+		_, err := reconciler.Reconcile(ctx, req)
+		Expect(err).NotTo(HaveOccurred())
+
+		remoteTunnel := &kubeovnv1.VpcNatTunnel{}
+		Expect(remote.Get(ctx, types.NamespacedName{Name: "p1", Namespace: "ns2"}, remoteTunnel)).To(Succeed())
+		remoteTunnel.Spec.RemoteIP = "172.18.0.99"
+		Expect(remote.Update(ctx, remoteTunnel)).To(Succeed())
+		pod := &corev1.Pod{}
+		Expect(remote.Get(ctx, types.NamespacedName{Name: "vpc-nat-gw-gw1-0", Namespace: "kube-system"}, pod)).To(Succeed())
+		pod.Annotations["ext1.kube-system.kubernetes.io/ip_address"] = "172.19.0.21"
+		Expect(remote.Update(ctx, pod)).To(Succeed())
+
+		_, err = reconciler.Reconcile(ctx, req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(remote.Get(ctx, types.NamespacedName{Name: "p1", Namespace: "ns2"}, remoteTunnel)).To(Succeed())
+		Expect(remoteTunnel.Spec.RemoteIP).To(Equal("172.18.0.10"))
+		localTunnel := &kubeovnv1.VpcNatTunnel{}
+		Expect(reconciler.Get(ctx, types.NamespacedName{Name: "p1", Namespace: "ns1"}, localTunnel)).To(Succeed())
+		Expect(localTunnel.Spec.RemoteIP).To(Equal("172.19.0.21"))
+	})
+
+	It("should wait for the remote globalnet CIDR and leave foreign tunnels alone", func() {
+		stored := &kubeovnv1.VpcPeering{}
+		Expect(reconciler.Get(ctx, req.NamespacedName, stored)).To(Succeed())
+		stored.Spec.Remote.GlobalnetCIDR = ""
+		Expect(reconciler.Update(ctx, stored)).To(Succeed())
+
+		result, err := reconciler.Reconcile(ctx, req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(peeringRetryPeriod))
+		Expect(reconciler.Get(ctx, req.NamespacedName, stored)).To(Succeed())
+		condition := meta.FindStatusCondition(stored.Status.Conditions, kubeovnv1.ConditionPeeringReady)
+		Expect(condition).NotTo(BeNil())
+		Expect(condition.Reason).To(Equal("RemoteGlobalnetUnavailable"))
+
+		stored.Spec.Remote.GlobalnetCIDR = "242.1.0.0/16"
+		Expect(reconciler.Update(ctx, stored)).To(Succeed())
+		foreign := &kubeovnv1.VpcNatTunnel{ObjectMeta: metav1.ObjectMeta{Name: "p1", Namespace: "ns2"}}
+		foreign.Spec.RemoteIP = "172.20.0.1"
+		Expect(remote.Create(ctx, foreign)).To(Succeed())
+
+		_, err = reconciler.Reconcile(ctx, req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reconciler.Get(ctx, req.NamespacedName, stored)).To(Succeed())
+		Expect(meta.FindStatusCondition(stored.Status.Conditions, kubeovnv1.ConditionPeeringReady).Reason).To(Equal("TunnelExists"))
+		Expect(remote.Get(ctx, client.ObjectKeyFromObject(foreign), foreign)).To(Succeed())
+		Expect(foreign.Spec.RemoteIP).To(Equal("172.20.0.1"))
+	})
+
+	It("should delete the remote tunnel with the peering", func() {
+		_, err := reconciler.Reconcile(ctx, req)
+		Expect(err).NotTo(HaveOccurred())
+
+		stored := &kubeovnv1.VpcPeering{}
+		Expect(reconciler.Get(ctx, req.NamespacedName, stored)).To(Succeed())
+		Expect(reconciler.Delete(ctx, stored)).To(Succeed())
+		_, err = reconciler.Reconcile(ctx, req)
+		Expect(err).NotTo(HaveOccurred())
+
+		err = remote.Get(ctx, types.NamespacedName{Name: "p1", Namespace: "ns2"}, &kubeovnv1.VpcNatTunnel{})
+		Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+		err = reconciler.Get(ctx, req.NamespacedName, stored)
+		Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("should delete the remote tunnel it leaves behind when the remote end moves", func() {
+		_, err := reconciler.Reconcile(ctx, req)
+		Expect(err).NotTo(HaveOccurred())
+
+		stored := &kubeovnv1.VpcPeering{}
+		Expect(reconciler.Get(ctx, req.NamespacedName, stored)).To(Succeed())
+		stored.Spec.Remote.Namespace = "ns3"
+		Expect(reconciler.Update(ctx, stored)).To(Succeed())
+		_, err = reconciler.Reconcile(ctx, req)
+		Expect(err).NotTo(HaveOccurred())
+		err = remote.Get(ctx, types.NamespacedName{Name: "p1", Namespace: "ns2"}, &kubeovnv1.VpcNatTunnel{})
+		Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+		Expect(remote.Get(ctx, types.NamespacedName{Name: "p1", Namespace: "ns3"}, &kubeovnv1.VpcNatTunnel{})).To(Succeed())
+
+		// another cluster: the old tunnel is deleted through the Secret it was provisioned with
+		cluster3 := fake.NewClientBuilder().WithScheme(scheme).WithObjects(peeringGateway("172.20.0.30")...).Build()
+		reconciler.RemoteClient = func(kubeconfig []byte, _ *runtime.Scheme) (client.Client, error) {
+			if string(kubeconfig) == "kubeconfig of cluster3" {
+				return cluster3, nil
+			}
+			return remote, nil
+		}
+		Expect(reconciler.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster3", Namespace: "ns1"},
+			Data:       map[string][]byte{kubeovnv1.KubeconfigSecretKey: []byte("kubeconfig of cluster3")},
+		})).To(Succeed())
+		Expect(reconciler.Get(ctx, req.NamespacedName, stored)).To(Succeed())
+		stored.Spec.Remote.KubeconfigSecret = "cluster3"
+		Expect(reconciler.Update(ctx, stored)).To(Succeed())
+		_, err = reconciler.Reconcile(ctx, req)
+		Expect(err).NotTo(HaveOccurred())
+		err = remote.Get(ctx, types.NamespacedName{Name: "p1", Namespace: "ns3"}, &kubeovnv1.VpcNatTunnel{})
+		Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+		Expect(cluster3.Get(ctx, types.NamespacedName{Name: "p1", Namespace: "ns3"}, &kubeovnv1.VpcNatTunnel{})).To(Succeed())
+		Expect(reconciler.Get(ctx, req.NamespacedName, stored)).To(Succeed())
+		Expect(stored.Status.RemoteKubeconfigSecret).To(Equal("cluster3"))
+	})
+
+	It("should recreate both tunnels when the type changes", func() {
+		_, err := reconciler.Reconcile(ctx, req)
+		Expect(err).NotTo(HaveOccurred())
+
+		stored := &kubeovnv1.VpcPeering{}
+		Expect(reconciler.Get(ctx, req.NamespacedName, stored)).To(Succeed())
+		stored.Spec.Type = "vxlan"
+		Expect(reconciler.Update(ctx, stored)).To(Succeed())
+		result, err := reconciler.Reconcile(ctx, req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(peeringRetryPeriod))
+		Expect(reconciler.Get(ctx, req.NamespacedName, stored)).To(Succeed())
+		Expect(meta.FindStatusCondition(stored.Status.Conditions, kubeovnv1.ConditionPeeringReady).Reason).To(Equal("TunnelRecreating"))
+		err = reconciler.Get(ctx, types.NamespacedName{Name: "p1", Namespace: "ns1"}, &kubeovnv1.VpcNatTunnel{})
+		Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+
+		// the local tunnel is created again, the remote one goes the same way
+		result, err = reconciler.Reconcile(ctx, req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(peeringRetryPeriod))
+		err = remote.Get(ctx, types.NamespacedName{Name: "p1", Namespace: "ns2"}, &kubeovnv1.VpcNatTunnel{})
+		Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+		result, err = reconciler.Reconcile(ctx, req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(peeringResyncPeriod))
+		localTunnel := &kubeovnv1.VpcNatTunnel{}
+		Expect(reconciler.Get(ctx, types.NamespacedName{Name: "p1", Namespace: "ns1"}, localTunnel)).To(Succeed())
+		Expect(localTunnel.Spec.Type).To(Equal("vxlan"))
+		remoteTunnel := &kubeovnv1.VpcNatTunnel{}
+		Expect(remote.Get(ctx, types.NamespacedName{Name: "p1", Namespace: "ns2"}, remoteTunnel)).To(Succeed())
+		Expect(remoteTunnel.Spec.Type).To(Equal("vxlan"))
+	})
+
+	It("should only build the remote client again when the Secret changes", func() {
+		built := 0
+		reconciler.RemoteClient = func(_ []byte, _ *runtime.Scheme) (client.Client, error) {
+			built++
+			return remote, nil
+		}
+		_, err := reconciler.Reconcile(ctx, req)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = reconciler.Reconcile(ctx, req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(built).To(Equal(1))
+
+		secret := &corev1.Secret{}
+		Expect(reconciler.Get(ctx, types.NamespacedName{Name: "cluster2", Namespace: "ns1"}, secret)).To(Succeed())
+		secret.Data[kubeovnv1.KubeconfigSecretKey] = []byte("rotated kubeconfig of cluster2")
+		Expect(reconciler.Update(ctx, secret)).To(Succeed())
+		_, err = reconciler.Reconcile(ctx, req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(built).To(Equal(2))
+	})
+
+	It("should give the remote tunnel the other address of the block allocated to the local one", func() {
+		stored := &kubeovnv1.VpcPeering{}
+		Expect(reconciler.Get(ctx, req.NamespacedName, stored)).To(Succeed())
+		stored.Spec.TransitCIDR = ""
+		stored.Spec.InterfaceAddrPool = "p2p"
+		Expect(reconciler.Update(ctx, stored)).To(Succeed())
+
+		result, err := reconciler.Reconcile(ctx, req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(peeringRetryPeriod))
+		Expect(reconciler.Get(ctx, req.NamespacedName, stored)).To(Succeed())
+		Expect(meta.FindStatusCondition(stored.Status.Conditions, kubeovnv1.ConditionPeeringReady).Reason).To(Equal("InterfaceAddrPending"))
+		localTunnel := &kubeovnv1.VpcNatTunnel{}
+		Expect(reconciler.Get(ctx, types.NamespacedName{Name: "p1", Namespace: "ns1"}, localTunnel)).To(Succeed())
+		Expect(localTunnel.Spec.InterfaceAddr).To(BeEmpty())
+		Expect(localTunnel.Spec.InterfaceAddrPool).To(Equal("p2p"))
+		err = remote.Get(ctx, types.NamespacedName{Name: "p1", Namespace: "ns2"}, &kubeovnv1.VpcNatTunnel{})
+		Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+
+		// what the tunnel controller records once it has allocated the block
+		localTunnel.Status.InterfaceAddrPool = "p2p"
+		localTunnel.Status.AllocatedInterfaceAddr = "10.100.0.4/31"
+		localTunnel.Status.RemoteInterfaceAddr = "10.100.0.5/31"
+		Expect(reconciler.Status().Update(ctx, localTunnel)).To(Succeed())
+		result, err = reconciler.Reconcile(ctx, req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(peeringResyncPeriod))
+		remoteTunnel := &kubeovnv1.VpcNatTunnel{}
+		Expect(remote.Get(ctx, types.NamespacedName{Name: "p1", Namespace: "ns2"}, remoteTunnel)).To(Succeed())
+		Expect(remoteTunnel.Spec.InterfaceAddr).To(Equal("10.100.0.5/31"))
+		Expect(remoteTunnel.Spec.InterfaceAddrPool).To(BeEmpty())
+		Expect(reconciler.Get(ctx, req.NamespacedName, stored)).To(Succeed())
+		Expect(stored.Status.Local.InterfaceAddr).To(Equal("10.100.0.4/31"))
+		Expect(stored.Status.Remote.InterfaceAddr).To(Equal("10.100.0.5/31"))
+	})
+
+	It("should hand out the first two host addresses of the transit CIDR", func() {
+		local, peer, err := transitAddrs("10.100.0.8/29")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(local).To(Equal("10.100.0.9/29"))
+		Expect(peer).To(Equal("10.100.0.10/29"))
+		_, _, err = transitAddrs("10.100.0.0/31")
+		Expect(err).To(HaveOccurred())
+	})
+})
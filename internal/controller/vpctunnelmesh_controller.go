@@ -0,0 +1,500 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	kubeovnv1 "multi-vpc/api/v1"
+	"multi-vpc/internal/globalip"
+	"multi-vpc/internal/options"
+	"multi-vpc/internal/tunnel/factory"
+	"multi-vpc/internal/tunnel/vxlan"
+)
+
+const (
+	meshFinalizer = "mesh.finalizer.ustc.io"
+
+	// meshLinkBits is the size of the transit block of a tunnel pair, room for the two interface addresses
+	meshLinkBits   = 30
+	defaultVNIBase = 1000
+	maxVNI         = 1<<24 - 1
+)
+
+// VpcTunnelMeshReconciler reconciles a VpcTunnelMesh object
+type VpcTunnelMeshReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Options names the kube-ovn and Submariner objects, on every cluster. Defaults to options.NewOptions()
+	Options *options.Options
+	// RemoteClient builds the clients of the clusters of the members, defaults to newRemoteClient
+	RemoteClient RemoteClientFunc
+	// APIReader reads the kubeconfig Secrets, whose data the manager cache does not hold. Defaults to the Client
+	APIReader client.Reader
+	// remoteClients keeps the clients of the clusters of the members across reconciles
+	remoteClients remoteClients
+}
+
+//+kubebuilder:rbac:groups=kubeovn.ustc.io,resources=vpctunnelmeshes,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=kubeovn.ustc.io,resources=vpctunnelmeshes/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=kubeovn.ustc.io,resources=vpctunnelmeshes/finalizers,verbs=update
+
+// Reconcile provisions a tunnel pair for every link of the topology of a VpcTunnelMesh, each tunnel pointing at the
+// gateway and globalnet CIDR of the other member, and deletes the pairs of links that went away.
+func (r *VpcTunnelMeshReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	mesh := &kubeovnv1.VpcTunnelMesh{}
+	err := r.Get(ctx, req.NamespacedName, mesh)
+	if err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !mesh.DeletionTimestamp.IsZero() {
+		return r.handleDelete(ctx, mesh)
+	}
+
+	if !controllerutil.ContainsFinalizer(mesh, meshFinalizer) {
+		controllerutil.AddFinalizer(mesh, meshFinalizer)
+		if err := r.Update(ctx, mesh); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	err = r.syncMesh(ctx, mesh)
+	condition := metav1.Condition{
+		Type:    kubeovnv1.ConditionPeeringReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  "TunnelsSynced",
+		Message: fmt.Sprintf("%d tunnel pairs are in sync", len(mesh.Status.Links)),
+	}
+	var notReady *peeringError
+	switch {
+	case errors.As(err, &notReady):
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = notReady.reason
+		condition.Message = err.Error()
+	case err != nil:
+		return ctrl.Result{}, err
+	}
+	meta.SetStatusCondition(&mesh.Status.Conditions, condition)
+	if err := r.Status().Update(ctx, mesh); err != nil {
+		return ctrl.Result{}, err
+	}
+	if notReady != nil {
+		log.FromContext(ctx).Info("mesh not ready", "reason", notReady.reason, "error", notReady.err)
+		return ctrl.Result{RequeueAfter: peeringRetryPeriod}, nil
+	}
+	return ctrl.Result{RequeueAfter: peeringResyncPeriod}, nil
+}
+
+// syncMesh deletes the tunnels of links that are gone or whose members moved, then creates or updates the tunnels
+// of every link whose members could both be resolved. The first member that could not be is reported.
+func (r *VpcTunnelMeshReconciler) syncMesh(ctx context.Context, mesh *kubeovnv1.VpcTunnelMesh) error {
+	links, err := planMeshLinks(mesh)
+	if err != nil {
+		return &peeringError{reason: "InvalidTopology", err: err}
+	}
+	clients := map[string]client.Client{}
+
+	// look up every member first, so a member that is down does not hold back the links between the others
+	var firstErr error
+	members := make([]kubeovnv1.MeshMemberStatus, 0, len(mesh.Spec.Members))
+	resolved := map[string]*kubeovnv1.MeshMemberStatus{}
+	for _, member := range mesh.Spec.Members {
+		status := kubeovnv1.MeshMemberStatus{
+			Name:             member.Name,
+			KubeconfigSecret: member.KubeconfigSecret,
+			Namespace:        meshMemberNamespace(mesh, member.Namespace),
+		}
+		err := r.resolveMember(ctx, mesh, member, clients, &status)
+		var notReady *peeringError
+		switch {
+		case errors.As(err, &notReady):
+			if firstErr == nil {
+				firstErr = err
+			}
+		case err != nil:
+			return err
+		default:
+			resolved[member.Name] = &status
+		}
+		members = append(members, status)
+	}
+
+	if err := checkMeshGlobalnet(links, resolved); err != nil {
+		return &peeringError{reason: "InvalidTopology", err: err}
+	}
+	if err := r.deleteStaleLinks(ctx, mesh, links, clients); err != nil {
+		return err
+	}
+	mesh.Status.Links, mesh.Status.Members = links, members
+
+	for _, link := range links {
+		a, b := resolved[link.A], resolved[link.B]
+		if a == nil || b == nil {
+			continue
+		}
+		addrA, addrB, err := transitAddrs(link.TransitCIDR)
+		if err != nil {
+			return err
+		}
+		for _, end := range []struct {
+			self, peer *kubeovnv1.MeshMemberStatus
+			addr       string
+		}{{a, b, addrA}, {b, a, addrB}} {
+			c, err := r.memberClient(ctx, mesh, end.self.KubeconfigSecret, clients)
+			if err != nil {
+				return err
+			}
+			err = r.syncTunnel(ctx, mesh, c, link, end.self, end.peer, end.addr)
+			var notReady *peeringError
+			switch {
+			case errors.As(err, &notReady):
+				if firstErr == nil {
+					firstErr = err
+				}
+			case err != nil:
+				return err
+			}
+		}
+	}
+	return firstErr
+}
+
+// checkMeshGlobalnet rejects links whose members resolved to the same globalnet CIDR. Members on one cluster read
+// the same CIDR from its Submariner Gateway, so the route to the peer would overlap the in-flow route of the gateway.
+func checkMeshGlobalnet(links []kubeovnv1.MeshLink, resolved map[string]*kubeovnv1.MeshMemberStatus) error {
+	for _, link := range links {
+		a, b := resolved[link.A], resolved[link.B]
+		if a == nil || b == nil || a.GlobalnetCIDR != b.GlobalnetCIDR {
+			continue
+		}
+		return fmt.Errorf("members %s and %s both resolve to globalnet CIDR %s, set globalnetCIDR on the members "+
+			"or give their VPCs their own GlobalIPPool", link.A, link.B, a.GlobalnetCIDR)
+	}
+	return nil
+}
+
+// resolveMember connects to the cluster of a member and fills in the address and globalnet CIDR of its gateway
+func (r *VpcTunnelMeshReconciler) resolveMember(ctx context.Context, mesh *kubeovnv1.VpcTunnelMesh, member kubeovnv1.MeshMember, clients map[string]client.Client, status *kubeovnv1.MeshMemberStatus) error {
+	c, err := r.memberClient(ctx, mesh, member.KubeconfigSecret, clients)
+	if err != nil {
+		return err
+	}
+	end := kubeovnv1.PeeringEnd{}
+	err = resolvePeeringEnd(ctx, c, r.Options, "Member", "member "+member.Name, member.NatGwDp, member.GlobalnetCIDR, &end)
+	if err != nil {
+		return err
+	}
+	status.GatewayIP, status.GlobalnetCIDR = end.GatewayIP, end.GlobalnetCIDR
+	return nil
+}
+
+// syncTunnel creates or updates the tunnel of member self towards member peer
+func (r *VpcTunnelMeshReconciler) syncTunnel(ctx context.Context, mesh *kubeovnv1.VpcTunnelMesh, c client.Client, link kubeovnv1.MeshLink, self, peer *kubeovnv1.MeshMemberStatus, addr string) error {
+	natGwDp := ""
+	for _, member := range mesh.Spec.Members {
+		if member.Name == self.Name {
+			natGwDp = member.NatGwDp
+		}
+	}
+	key := types.NamespacedName{Namespace: self.Namespace, Name: meshTunnelName(mesh, self.Name, peer.Name)}
+	return syncManagedTunnel(ctx, c, key, kubeovnv1.VpcTunnelMeshLabel, mesh.Name, func(vpcTunnel *kubeovnv1.VpcNatTunnel) error {
+		vpcTunnel.Spec.NatGwDp = natGwDp
+		vpcTunnel.Spec.InterfaceAddr = addr
+		vpcTunnel.Spec.RemoteIP = peer.GatewayIP
+		vpcTunnel.Spec.RemoteGlobalnetCIDR = peer.GlobalnetCIDR
+		vpcTunnel.Spec.Type = meshTunnelType(mesh)
+		if vpcTunnel.Spec.Type != factory.VXLAN {
+			delete(vpcTunnel.Labels, vxlan.VidLabel)
+			delete(vpcTunnel.Labels, vxlan.PortLabel)
+			return nil
+		}
+		vpcTunnel.Labels[vxlan.VidLabel] = fmt.Sprint(link.VNI)
+		vpcTunnel.Labels[vxlan.PortLabel] = meshVxlanPort(mesh)
+		return nil
+	})
+}
+
+// deleteStaleLinks deletes the tunnels recorded in the status for links that are no longer planned, or whose
+// members moved to another namespace or cluster. They are looked up where they were provisioned.
+func (r *VpcTunnelMeshReconciler) deleteStaleLinks(ctx context.Context, mesh *kubeovnv1.VpcTunnelMesh, links []kubeovnv1.MeshLink, clients map[string]client.Client) error {
+	planned := map[[2]string]bool{}
+	for _, link := range links {
+		planned[[2]string{link.A, link.B}] = true
+	}
+	current := map[string]kubeovnv1.MeshMemberStatus{}
+	for _, member := range mesh.Spec.Members {
+		current[member.Name] = kubeovnv1.MeshMemberStatus{KubeconfigSecret: member.KubeconfigSecret, Namespace: meshMemberNamespace(mesh, member.Namespace)}
+	}
+	previous := map[string]kubeovnv1.MeshMemberStatus{}
+	for _, member := range mesh.Status.Members {
+		previous[member.Name] = member
+	}
+	moved := func(name string) bool {
+		was, now := previous[name], current[name]
+		return was.Namespace != now.Namespace || was.KubeconfigSecret != now.KubeconfigSecret
+	}
+
+	for _, link := range mesh.Status.Links {
+		if planned[[2]string{link.A, link.B}] && !moved(link.A) && !moved(link.B) {
+			continue
+		}
+		if err := r.deleteLink(ctx, mesh, link, previous, clients); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteLink deletes both tunnels of a link from where members says they are
+func (r *VpcTunnelMeshReconciler) deleteLink(ctx context.Context, mesh *kubeovnv1.VpcTunnelMesh, link kubeovnv1.MeshLink, members map[string]kubeovnv1.MeshMemberStatus, clients map[string]client.Client) error {
+	for _, end := range [][2]string{{link.A, link.B}, {link.B, link.A}} {
+		member, ok := members[end[0]]
+		if !ok {
+			continue
+		}
+		c, err := r.memberClient(ctx, mesh, member.KubeconfigSecret, clients)
+		if err != nil {
+			return err
+		}
+		key := types.NamespacedName{Namespace: member.Namespace, Name: meshTunnelName(mesh, end[0], end[1])}
+		if err := deleteManagedTunnel(ctx, c, key, kubeovnv1.VpcTunnelMeshLabel, mesh.Name); err != nil {
+			return err
+		}
+		log.FromContext(ctx).Info("deleted mesh tunnel", "tunnel", key)
+	}
+	return nil
+}
+
+// handleDelete deletes the tunnels of every link. With the force-delete annotation the mesh is released even if
+// some cluster cannot be reached.
+func (r *VpcTunnelMeshReconciler) handleDelete(ctx context.Context, mesh *kubeovnv1.VpcTunnelMesh) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(mesh, meshFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	clients := map[string]client.Client{}
+	members := map[string]kubeovnv1.MeshMemberStatus{}
+	for _, member := range mesh.Status.Members {
+		members[member.Name] = member
+	}
+	for _, link := range mesh.Status.Links {
+		err := r.deleteLink(ctx, mesh, link, members, clients)
+		if err != nil {
+			if mesh.Annotations[kubeovnv1.ForceDeleteAnnotation] != "true" {
+				return ctrl.Result{}, err
+			}
+			log.FromContext(ctx).Error(err, "mesh tunnels left behind by forced delete", "a", link.A, "b", link.B)
+		}
+	}
+	controllerutil.RemoveFinalizer(mesh, meshFinalizer)
+	return ctrl.Result{}, r.Update(ctx, mesh)
+}
+
+// memberClient returns the client of the cluster of a member, the local one without a kubeconfig Secret. Clients
+// are looked up once per reconcile, and only built again when their Secret changes.
+func (r *VpcTunnelMeshReconciler) memberClient(ctx context.Context, mesh *kubeovnv1.VpcTunnelMesh, secret string, clients map[string]client.Client) (client.Client, error) {
+	if secret == "" {
+		return r.Client, nil
+	}
+	if c, ok := clients[secret]; ok {
+		return c, nil
+	}
+	reader := r.APIReader
+	if reader == nil {
+		reader = r.Client
+	}
+	c, err := r.remoteClients.clusterClient(ctx, reader, r.Scheme, r.RemoteClient, mesh.Namespace, secret)
+	if err != nil {
+		return nil, err
+	}
+	clients[secret] = c
+	return c, nil
+}
+
+// planMeshLinks returns the links of the topology of the mesh, with the ends of each ordered by name. Links that
+// are already in the status keep their transit CIDR and VNI, new ones get the first free ones.
+func planMeshLinks(mesh *kubeovnv1.VpcTunnelMesh) ([]kubeovnv1.MeshLink, error) {
+	names := map[string]bool{}
+	for _, member := range mesh.Spec.Members {
+		if names[member.Name] {
+			return nil, fmt.Errorf("duplicate member %q", member.Name)
+		}
+		names[member.Name] = true
+	}
+
+	var links []kubeovnv1.MeshLink
+	pair := func(a, b string) {
+		if b < a {
+			a, b = b, a
+		}
+		links = append(links, kubeovnv1.MeshLink{A: a, B: b})
+	}
+	switch mesh.Spec.Topology {
+	case "", kubeovnv1.TopologyFullMesh:
+		for i, a := range mesh.Spec.Members {
+			for _, b := range mesh.Spec.Members[i+1:] {
+				pair(a.Name, b.Name)
+			}
+		}
+	case kubeovnv1.TopologyHubAndSpoke:
+		if !names[mesh.Spec.Hub] {
+			return nil, fmt.Errorf("hub %q is not a member", mesh.Spec.Hub)
+		}
+		for _, member := range mesh.Spec.Members {
+			if member.Name != mesh.Spec.Hub {
+				pair(mesh.Spec.Hub, member.Name)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown topology %q", mesh.Spec.Topology)
+	}
+
+	transit, err := netip.ParsePrefix(mesh.Spec.TransitCIDR)
+	if err != nil || !transit.Addr().Is4() {
+		return nil, fmt.Errorf("invalid transit CIDR %q", mesh.Spec.TransitCIDR)
+	}
+	vniBase := mesh.Spec.VNIBase
+	if vniBase == 0 {
+		vniBase = defaultVNIBase
+	}
+	existing := map[[2]string]kubeovnv1.MeshLink{}
+	for _, link := range mesh.Status.Links {
+		existing[[2]string{link.A, link.B}] = link
+	}
+
+	// keep the allocations of links that are still planned, so their tunnels are not renumbered
+	var used []netip.Prefix
+	usedVNI := map[int32]bool{}
+	pending := make([]int, 0, len(links))
+	for i, link := range links {
+		old, ok := existing[[2]string{link.A, link.B}]
+		block, err := netip.ParsePrefix(old.TransitCIDR)
+		if !ok || err != nil || block.Bits() != meshLinkBits || !transit.Contains(block.Addr()) || old.VNI < vniBase || usedVNI[old.VNI] {
+			pending = append(pending, i)
+			continue
+		}
+		links[i] = old
+		used = append(used, block)
+		usedVNI[old.VNI] = true
+	}
+	vni := vniBase
+	for _, i := range pending {
+		block, err := globalip.NextBlock(transit, meshLinkBits, used)
+		if err != nil {
+			return nil, fmt.Errorf("transit CIDR: %w", err)
+		}
+		used = append(used, block)
+		for usedVNI[vni] {
+			vni++
+		}
+		if vni > maxVNI {
+			return nil, fmt.Errorf("no VNI left above %d", vniBase)
+		}
+		usedVNI[vni] = true
+		links[i].TransitCIDR, links[i].VNI = block.String(), vni
+	}
+	return links, nil
+}
+
+// meshTunnelName names the tunnel of member self towards member peer
+func meshTunnelName(mesh *kubeovnv1.VpcTunnelMesh, self, peer string) string {
+	return mesh.Name + "-" + self + "-" + peer
+}
+
+func meshMemberNamespace(mesh *kubeovnv1.VpcTunnelMesh, namespace string) string {
+	if namespace == "" {
+		return mesh.Namespace
+	}
+	return namespace
+}
+
+func meshTunnelType(mesh *kubeovnv1.VpcTunnelMesh) string {
+	if mesh.Spec.Type == "" {
+		return factory.GRE
+	}
+	return mesh.Spec.Type
+}
+
+func meshVxlanPort(mesh *kubeovnv1.VpcTunnelMesh) string {
+	if mesh.Spec.VxlanPort == 0 {
+		return vxlan.DefaultPort
+	}
+	return fmt.Sprint(mesh.Spec.VxlanPort)
+}
+
+// SetupWithManager sets up the controller with the Manager. Only the metadata of Secrets is watched and cached,
+// and the status updates of the local tunnels do not concern the mesh.
+func (r *VpcTunnelMeshReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.APIReader == nil {
+		r.APIReader = mgr.GetAPIReader()
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&kubeovnv1.VpcTunnelMesh{}).
+		Watches(&kubeovnv1.VpcNatTunnel{},
+			handler.EnqueueRequestsFromMapFunc(r.tunnelToMesh),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.secretToMeshes),
+			builder.OnlyMetadata).
+		Complete(r)
+}
+
+// tunnelToMesh maps a local tunnel of a mesh to that mesh, when they share a namespace. Tunnels elsewhere are put
+// back in sync by the periodic resync.
+func (r *VpcTunnelMeshReconciler) tunnelToMesh(_ context.Context, obj client.Object) []reconcile.Request {
+	name, ok := obj.GetLabels()[kubeovnv1.VpcTunnelMeshLabel]
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: name}}}
+}
+
+// secretToMeshes maps a kubeconfig Secret to the meshes with a member using it
+func (r *VpcTunnelMeshReconciler) secretToMeshes(ctx context.Context, obj client.Object) []reconcile.Request {
+	meshes := &kubeovnv1.VpcTunnelMeshList{}
+	if err := r.List(ctx, meshes, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "unable to list meshes", "secret", obj.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, mesh := range meshes.Items {
+		for _, member := range mesh.Spec.Members {
+			if member.KubeconfigSecret == obj.GetName() {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&mesh)})
+				break
+			}
+		}
+	}
+	return requests
+}
@@ -0,0 +1,272 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	ovn "github.com/kubeovn/kube-ovn/pkg/apis/kubeovn/v1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	Submariner "github.com/submariner-io/submariner/pkg/apis/submariner.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kubeovnv1 "multi-vpc/api/v1"
+)
+
+var _ = Describe("VpcTunnelMesh", func() {
+	var mesh *kubeovnv1.VpcTunnelMesh
+
+	BeforeEach(func() {
+		mesh = &kubeovnv1.VpcTunnelMesh{
+			ObjectMeta: metav1.ObjectMeta{Name: "m1", Namespace: "ns1"},
+			Spec: kubeovnv1.VpcTunnelMeshSpec{
+				Members: []kubeovnv1.MeshMember{
+					{Name: "a", NatGwDp: "gw1", GlobalnetCIDR: "242.0.0.0/16"},
+					{Name: "b", NatGwDp: "gw1", KubeconfigSecret: "cluster2", GlobalnetCIDR: "242.1.0.0/16"},
+					{Name: "c", NatGwDp: "gw1", KubeconfigSecret: "cluster3", Namespace: "ns3", GlobalnetCIDR: "242.2.0.0/16"},
+				},
+				TransitCIDR: "10.100.0.0/24",
+				Type:        "vxlan",
+			},
+		}
+	})
+
+	Context("planning links", func() {
+		It("should connect every two members of a full mesh", func() {
+			links, err := planMeshLinks(mesh)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(links).To(Equal([]kubeovnv1.MeshLink{
+				{A: "a", B: "b", TransitCIDR: "10.100.0.0/30", VNI: 1000},
+				{A: "a", B: "c", TransitCIDR: "10.100.0.4/30", VNI: 1001},
+				{A: "b", B: "c", TransitCIDR: "10.100.0.8/30", VNI: 1002},
+			}))
+		})
+
+		It("should keep the allocations of remaining links", func() {
+			links, err := planMeshLinks(mesh)
+			Expect(err).NotTo(HaveOccurred())
+			mesh.Status.Links = links
+
+			mesh.Spec.Topology = kubeovnv1.TopologyHubAndSpoke
+			mesh.Spec.Hub = "c"
+			mesh.Spec.Members = append(mesh.Spec.Members, kubeovnv1.MeshMember{Name: "d", NatGwDp: "gw1"})
+			links, err = planMeshLinks(mesh)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(links).To(Equal([]kubeovnv1.MeshLink{
+				{A: "a", B: "c", TransitCIDR: "10.100.0.4/30", VNI: 1001},
+				{A: "b", B: "c", TransitCIDR: "10.100.0.8/30", VNI: 1002},
+				{A: "c", B: "d", TransitCIDR: "10.100.0.0/30", VNI: 1000},
+			}))
+		})
+
+		It("should reject a hub that is not a member and an exhausted transit CIDR", func() {
+			mesh.Spec.Topology = kubeovnv1.TopologyHubAndSpoke
+			mesh.Spec.Hub = "z"
+			_, err := planMeshLinks(mesh)
+			Expect(err).To(MatchError(ContainSubstring(`hub "z" is not a member`)))
+
+			mesh.Spec.Topology = kubeovnv1.TopologyFullMesh
+			mesh.Spec.TransitCIDR = "10.100.0.0/29"
+			_, err = planMeshLinks(mesh)
+			Expect(err).To(MatchError(ContainSubstring("no free /30 block left")))
+		})
+	})
+
+	Context("reconciling", func() {
+		var (
+			ctx        context.Context
+			clusters   map[string]client.Client
+			reconciler *VpcTunnelMeshReconciler
+			req        ctrl.Request
+		)
+
+		tunnelOn := func(cluster, namespace, name string) (*kubeovnv1.VpcNatTunnel, error) {
+			vpcTunnel := &kubeovnv1.VpcNatTunnel{}
+			err := clusters[cluster].Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, vpcTunnel)
+			return vpcTunnel, err
+		}
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			scheme := runtime.NewScheme()
+			Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+			Expect(kubeovnv1.AddToScheme(scheme)).To(Succeed())
+			Expect(ovn.AddToScheme(scheme)).To(Succeed())
+			Expect(Submariner.AddToScheme(scheme)).To(Succeed())
+
+			secrets := []client.Object{}
+			for _, name := range []string{"cluster2", "cluster3"} {
+				secrets = append(secrets, &corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns1"},
+					Data:       map[string][]byte{kubeovnv1.KubeconfigSecretKey: []byte(name)},
+				})
+			}
+			clusters = map[string]client.Client{
+				"cluster1": fake.NewClientBuilder().WithScheme(scheme).
+					WithStatusSubresource(&kubeovnv1.VpcTunnelMesh{}).
+					WithObjects(append(append(peeringGateway("172.18.0.10"), secrets...), mesh)...).Build(),
+				"cluster2": fake.NewClientBuilder().WithScheme(scheme).WithObjects(peeringGateway("172.19.0.20")...).Build(),
+				"cluster3": fake.NewClientBuilder().WithScheme(scheme).WithObjects(peeringGateway("172.20.0.30")...).Build(),
+			}
+			reconciler = &VpcTunnelMeshReconciler{
+				Client: clusters["cluster1"],
+				Scheme: scheme,
+				RemoteClient: func(kubeconfig []byte, _ *runtime.Scheme) (client.Client, error) {
+					return clusters[string(kubeconfig)], nil
+				},
+			}
+			req = ctrl.Request{NamespacedName: types.NamespacedName{Name: "m1", Namespace: "ns1"}}
+		})
+
+		It("should provision both tunnels of every link and delete those of dropped links", func() {
+			result, err := reconciler.Reconcile(ctx, req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(peeringResyncPeriod))
+
+			ab, err := tunnelOn("cluster1", "ns1", "m1-a-b")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ab.Spec).To(Equal(kubeovnv1.VpcNatTunnelSpec{
+				NatGwDp:             "gw1",
+				InterfaceAddr:       "10.100.0.1/30",
+				RemoteIP:            "172.19.0.20",
+				RemoteGlobalnetCIDR: "242.1.0.0/16",
+				Type:                "vxlan",
+			}))
+			Expect(ab.Labels).To(HaveKeyWithValue("vid", "1000"))
+			Expect(ab.Labels).To(HaveKeyWithValue("vx-port", "4789"))
+			Expect(ab.Labels).To(HaveKeyWithValue(kubeovnv1.VpcTunnelMeshLabel, "m1"))
+			ba, err := tunnelOn("cluster2", "ns1", "m1-b-a")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ba.Spec.InterfaceAddr).To(Equal("10.100.0.2/30"))
+			Expect(ba.Spec.RemoteIP).To(Equal("172.18.0.10"))
+			Expect(ba.Spec.RemoteGlobalnetCIDR).To(Equal("242.0.0.0/16"))
+			cb, err := tunnelOn("cluster3", "ns3", "m1-c-b")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cb.Spec.RemoteIP).To(Equal("172.19.0.20"))
+			Expect(cb.Labels).To(HaveKeyWithValue("vid", "1002"))
+
+			stored := &kubeovnv1.VpcTunnelMesh{}
+			Expect(reconciler.Get(ctx, req.NamespacedName, stored)).To(Succeed())
+			Expect(meta.IsStatusConditionTrue(stored.Status.Conditions, kubeovnv1.ConditionPeeringReady)).To(BeTrue())
+			Expect(stored.Status.Links).To(HaveLen(3))
+
+			stored.Spec.Topology = kubeovnv1.TopologyHubAndSpoke
+			stored.Spec.Hub = "a"
+			Expect(reconciler.Update(ctx, stored)).To(Succeed())
+			_, err = reconciler.Reconcile(ctx, req)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = tunnelOn("cluster2", "ns1", "m1-b-c")
+			Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+			_, err = tunnelOn("cluster3", "ns3", "m1-c-b")
+			Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+			_, err = tunnelOn("cluster3", "ns3", "m1-c-a")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(reconciler.Get(ctx, req.NamespacedName, stored)).To(Succeed())
+			Expect(reconciler.Delete(ctx, stored)).To(Succeed())
+			_, err = reconciler.Reconcile(ctx, req)
+			Expect(err).NotTo(HaveOccurred())
+			for _, t := range [][3]string{{"cluster1", "ns1", "m1-a-b"}, {"cluster1", "ns1", "m1-a-c"}, {"cluster2", "ns1", "m1-b-a"}, {"cluster3", "ns3", "m1-c-a"}} {
+				_, err = tunnelOn(t[0], t[1], t[2])
+				Expect(k8serrors.IsNotFound(err)).To(BeTrue(), t[2])
+			}
+		})
+
+		It("should recreate the tunnels when the type or the vxlan port changes", func() {
+			_, err := reconciler.Reconcile(ctx, req)
+			Expect(err).NotTo(HaveOccurred())
+
+			stored := &kubeovnv1.VpcTunnelMesh{}
+			Expect(reconciler.Get(ctx, req.NamespacedName, stored)).To(Succeed())
+			stored.Spec.VxlanPort = 8472
+			Expect(reconciler.Update(ctx, stored)).To(Succeed())
+			result, err := reconciler.Reconcile(ctx, req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(peeringRetryPeriod))
+			Expect(reconciler.Get(ctx, req.NamespacedName, stored)).To(Succeed())
+			Expect(meta.FindStatusCondition(stored.Status.Conditions, kubeovnv1.ConditionPeeringReady).Reason).To(Equal("TunnelRecreating"))
+			_, err = tunnelOn("cluster2", "ns1", "m1-b-a")
+			Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+			_, err = reconciler.Reconcile(ctx, req)
+			Expect(err).NotTo(HaveOccurred())
+			ba, err := tunnelOn("cluster2", "ns1", "m1-b-a")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ba.Labels).To(HaveKeyWithValue("vx-port", "8472"))
+
+			Expect(reconciler.Get(ctx, req.NamespacedName, stored)).To(Succeed())
+			stored.Spec.Type = "gre"
+			Expect(reconciler.Update(ctx, stored)).To(Succeed())
+			_, err = reconciler.Reconcile(ctx, req)
+			Expect(err).NotTo(HaveOccurred())
+			result, err = reconciler.Reconcile(ctx, req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(peeringResyncPeriod))
+			ba, err = tunnelOn("cluster2", "ns1", "m1-b-a")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ba.Spec.Type).To(Equal("gre"))
+			Expect(ba.Labels).NotTo(HaveKey("vid"))
+			Expect(ba.Labels).NotTo(HaveKey("vx-port"))
+		})
+
+		It("should reject members that resolve to the same globalnet CIDR", func() {
+			stored := &kubeovnv1.VpcTunnelMesh{}
+			Expect(reconciler.Get(ctx, req.NamespacedName, stored)).To(Succeed())
+			stored.Spec.Members[1].KubeconfigSecret = ""
+			stored.Spec.Members[1].GlobalnetCIDR = "242.0.0.0/16"
+			Expect(reconciler.Update(ctx, stored)).To(Succeed())
+
+			result, err := reconciler.Reconcile(ctx, req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(peeringRetryPeriod))
+			_, err = tunnelOn("cluster1", "ns1", "m1-a-b")
+			Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+
+			Expect(reconciler.Get(ctx, req.NamespacedName, stored)).To(Succeed())
+			condition := meta.FindStatusCondition(stored.Status.Conditions, kubeovnv1.ConditionPeeringReady)
+			Expect(condition.Reason).To(Equal("InvalidTopology"))
+			Expect(condition.Message).To(ContainSubstring("members a and b"))
+		})
+
+		It("should connect the members that are up while another one is not", func() {
+			Expect(clusters["cluster3"].DeleteAllOf(ctx, &corev1.Pod{}, client.InNamespace("kube-system"))).To(Succeed())
+
+			result, err := reconciler.Reconcile(ctx, req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.RequeueAfter).To(Equal(peeringRetryPeriod))
+			_, err = tunnelOn("cluster1", "ns1", "m1-a-b")
+			Expect(err).NotTo(HaveOccurred())
+			_, err = tunnelOn("cluster1", "ns1", "m1-a-c")
+			Expect(k8serrors.IsNotFound(err)).To(BeTrue())
+
+			stored := &kubeovnv1.VpcTunnelMesh{}
+			Expect(reconciler.Get(ctx, req.NamespacedName, stored)).To(Succeed())
+			condition := meta.FindStatusCondition(stored.Status.Conditions, kubeovnv1.ConditionPeeringReady)
+			Expect(condition.Reason).To(Equal("MemberGatewayNotReady"))
+			Expect(condition.Message).To(ContainSubstring("member c"))
+		})
+	})
+})
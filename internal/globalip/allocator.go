@@ -0,0 +1,94 @@
+package globalip
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// Range is an inclusive range of consecutive IPv4 addresses
+type Range struct {
+	First netip.Addr
+	Last  netip.Addr
+}
+
+// Overlaps reports whether r and o share an address
+func (r Range) Overlaps(o Range) bool {
+	return !r.Last.Less(o.First) && !o.Last.Less(r.First)
+}
+
+// NextBlock returns the first prefix of length bits in pool that overlaps none of used
+func NextBlock(pool netip.Prefix, bits int, used []netip.Prefix) (netip.Prefix, error) {
+	pool = pool.Masked()
+	if !pool.Addr().Is4() || bits < pool.Bits() || bits > 32 {
+		return netip.Prefix{}, fmt.Errorf("cannot carve /%d blocks out of %s", bits, pool)
+	}
+	block := netip.PrefixFrom(pool.Addr(), bits)
+	for {
+		free := true
+		for _, u := range used {
+			if u.Overlaps(block) {
+				free = false
+				break
+			}
+		}
+		if free {
+			return block, nil
+		}
+		next := lastAddr(block).Next()
+		if !next.IsValid() || !pool.Contains(next) {
+			return netip.Prefix{}, fmt.Errorf("no free /%d block left in %s", bits, pool)
+		}
+		block = netip.PrefixFrom(next, bits)
+	}
+}
+
+// NextRange returns the first run of n free addresses in block. The network address of the block is never handed
+// out, and neither is any address of used.
+func NextRange(block netip.Prefix, n int, used []Range) (Range, error) {
+	block = block.Masked()
+	if n < 1 {
+		return Range{}, fmt.Errorf("invalid range size %d", n)
+	}
+	first := block.Addr().Next()
+	for first.IsValid() && block.Contains(first) {
+		r := Range{First: first, Last: first}
+		for i := 1; i < n && r.Last.IsValid(); i++ {
+			r.Last = r.Last.Next()
+		}
+		if !r.Last.IsValid() || !block.Contains(r.Last) {
+			break
+		}
+		taken := false
+		for _, u := range used {
+			if u.Overlaps(r) {
+				// carry on right after the range in the way
+				first, taken = u.Last.Next(), true
+				break
+			}
+		}
+		if !taken {
+			return r, nil
+		}
+	}
+	return Range{}, fmt.Errorf("no %d free addresses left in %s", n, block)
+}
+
+// Addrs lists the addresses of r
+func (r Range) Addrs() []string {
+	var addrs []string
+	for addr := r.First; addr.IsValid() && !r.Last.Less(addr); addr = addr.Next() {
+		addrs = append(addrs, addr.String())
+	}
+	return addrs
+}
+
+// lastAddr returns the last address of an IPv4 prefix
+func lastAddr(p netip.Prefix) netip.Addr {
+	a := p.Masked().Addr().As4()
+	host := uint32(1)<<(32-p.Bits()) - 1
+	if p.Bits() == 0 {
+		host = ^uint32(0)
+	}
+	v := uint32(a[0])<<24 | uint32(a[1])<<16 | uint32(a[2])<<8 | uint32(a[3]) | host
+	return netip.AddrFrom4([4]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)})
+}
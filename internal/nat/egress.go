@@ -0,0 +1,90 @@
+package nat
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// IPRange is an inclusive range of consecutive IPv4 addresses
+type IPRange struct {
+	First netip.Addr
+	Last  netip.Addr
+}
+
+// String returns the range as iptables and nft take it, a single address or "first-last"
+func (r IPRange) String() string {
+	if r.First == r.Last {
+		return r.First.String()
+	}
+	return r.First.String() + "-" + r.Last.String()
+}
+
+// Size returns the number of addresses in the range
+func (r IPRange) Size() uint64 {
+	first, last := r.First.As4(), r.Last.As4()
+	return uint64(beUint32(last)-beUint32(first)) + 1
+}
+
+// EgressRanges sorts the egress IPs, drops duplicates and merges consecutive addresses into ranges.
+// Submariner allocates the IPs of a ClusterGlobalEgressIP wherever its pool has room, so they need not be
+// ordered or contiguous. Entries that are not IPv4 addresses are skipped and reported in the error.
+func EgressRanges(egressIPs []string) ([]IPRange, error) {
+	var addrs []netip.Addr
+	var invalid []string
+	for _, ip := range egressIPs {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil || !addr.Is4() {
+			invalid = append(invalid, ip)
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i].Less(addrs[j]) })
+
+	var ranges []IPRange
+	for _, addr := range addrs {
+		if n := len(ranges); n != 0 {
+			last := &ranges[n-1]
+			if addr == last.Last {
+				continue
+			}
+			if addr == last.Last.Next() {
+				last.Last = addr
+				continue
+			}
+		}
+		ranges = append(ranges, IPRange{First: addr, Last: addr})
+	}
+	if len(invalid) != 0 {
+		return ranges, fmt.Errorf("invalid IPv4 egress IPs: %s", strings.Join(invalid, ", "))
+	}
+	return ranges, nil
+}
+
+// RangesString joins the ranges, it tells whether two lists of egress IPs translate to the same rules
+func RangesString(ranges []IPRange) string {
+	parts := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		parts = append(parts, r.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// SpreadRanges calls fn for each range with its share of the new connections not taken by the previous ranges,
+// as size/remaining, so that every address gets the same share. remaining equals size for the last range.
+func SpreadRanges(ranges []IPRange, fn func(r IPRange, size, remaining uint64)) {
+	var remaining uint64
+	for _, r := range ranges {
+		remaining += r.Size()
+	}
+	for _, r := range ranges {
+		fn(r, r.Size(), remaining)
+		remaining -= r.Size()
+	}
+}
+
+func beUint32(b [4]byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
@@ -0,0 +1,45 @@
+package factory
+
+import (
+	"sort"
+
+	"multi-vpc/internal/nat"
+	"multi-vpc/internal/nat/iptables"
+	"multi-vpc/internal/nat/nftables"
+)
+
+const (
+	IPTABLES = "iptables"
+	NFTABLES = "nftables"
+)
+
+// backends holds the registered NAT backends and their operation constructors
+var backends = map[string]func(nat.SnatRule) nat.SnatOperation{
+	IPTABLES: iptables.NewIptablesOp,
+	NFTABLES: nftables.NewNftablesOp,
+}
+
+// IsRegistered reports whether backend is a registered NAT backend
+func IsRegistered(backend string) bool {
+	_, ok := backends[backend]
+	return ok
+}
+
+// RegisteredBackends returns the names of all registered NAT backends
+func RegisteredBackends() []string {
+	names := make([]string, 0, len(backends))
+	for b := range backends {
+		names = append(names, b)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CreateSnatOperation returns the operation of backend for rule. Tunnels provisioned before backends were
+// recorded have an empty backend and use iptables.
+func CreateSnatOperation(backend string, rule nat.SnatRule) nat.SnatOperation {
+	if newOp, ok := backends[backend]; ok {
+		return newOp(rule)
+	}
+	return iptables.NewIptablesOp(rule)
+}
@@ -0,0 +1,51 @@
+package nat
+
+// SnatRule is the NAT of a tunnel: traffic to one of RemoteCIDRs leaving through OutInterface gets one of EgressIPs
+// as source, or the address Mappings give it. Traffic to a mapped overlay range coming in through OutInterface
+// is translated back, and traffic to the global IP of one of Ingress is forwarded to its internal IP.
+type SnatRule struct {
+	// Chain holds the rules of the tunnel and nothing else, its incoming mappings and ingress rules go to
+	// Chain+DnatChainSuffix
+	Chain        string
+	OutInterface string
+	RemoteCIDRs  []string
+	EgressIPs    []string
+	Mappings     []Mapping
+	Ingress      []Dnat
+}
+
+// Mapping translates Local to Overlay, a prefix of the same length, address by address
+type Mapping struct {
+	Local   string
+	Overlay string
+}
+
+// Dnat forwards connections to GlobalIP, or to its Port of Protocol if set, to InternalIP and TargetPort
+type Dnat struct {
+	GlobalIP   string
+	InternalIP string
+	Protocol   string
+	Port       int32
+	TargetPort int32
+}
+
+// ToPort returns the port connections are forwarded to, none if the rule forwards every port
+func (d Dnat) ToPort() int32 {
+	if d.TargetPort != 0 {
+		return d.TargetPort
+	}
+	return d.Port
+}
+
+// DnatChainSuffix names the chain holding the incoming mappings and ingress rules of a tunnel
+const DnatChainSuffix = "-IN"
+
+// SnatOperation generates the commands that install and remove a SnatRule on a gateway.
+// CreateCmd replaces whatever the chain held before, DeleteCmd succeeds even if the chain is already gone.
+// UpdateCmd turns the rules installed for previous into the ones of the operation, leaving the prefixes both
+// share in place. It returns an empty string if there is nothing to change.
+type SnatOperation interface {
+	CreateCmd() string
+	DeleteCmd() string
+	UpdateCmd(previous SnatRule) string
+}
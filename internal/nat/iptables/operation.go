@@ -0,0 +1,145 @@
+package iptables
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"multi-vpc/internal/cidr"
+	"multi-vpc/internal/nat"
+)
+
+type IptablesOperation struct {
+	rule nat.SnatRule
+}
+
+func NewIptablesOp(rule nat.SnatRule) nat.SnatOperation {
+	return &IptablesOperation{
+		rule: rule,
+	}
+}
+
+// CreateCmd rebuilds the chain of the tunnel and jumps to it from POSTROUTING
+func (o *IptablesOperation) CreateCmd() string {
+	rule := o.rule
+	cmds := []string{
+		fmt.Sprintf("iptables -t nat -N %s 2>/dev/null", rule.Chain),
+		fmt.Sprintf("iptables -t nat -F %s", rule.Chain),
+	}
+	for _, remote := range rule.RemoteCIDRs {
+		for _, spec := range o.ruleSpecs(remote) {
+			cmds = append(cmds, "iptables -t nat -A "+spec)
+		}
+	}
+	cmds = append(cmds, jumpCmd("POSTROUTING", rule.Chain))
+	return strings.Join(append(cmds, o.dnatCmds()...), ";")
+}
+
+// dnatCmds rebuilds the chain forwarding the ingress global IPs and translating the overlay ranges back, or removes
+// it if there is neither
+func (o *IptablesOperation) dnatCmds() []string {
+	chain := o.rule.Chain + nat.DnatChainSuffix
+	if len(o.rule.Mappings) == 0 && len(o.rule.Ingress) == 0 {
+		return deleteChainCmds("PREROUTING", chain)
+	}
+	cmds := []string{
+		fmt.Sprintf("iptables -t nat -N %s 2>/dev/null", chain),
+		fmt.Sprintf("iptables -t nat -F %s", chain),
+	}
+	// the ingress rules go first, their global IPs may lie in an overlay range
+	for _, d := range o.rule.Ingress {
+		match := fmt.Sprintf("-i %s -d %s", o.rule.OutInterface, d.GlobalIP)
+		target := d.InternalIP
+		if d.Protocol != "" {
+			match += " -p " + d.Protocol
+			if d.Port != 0 {
+				match += fmt.Sprintf(" --dport %d", d.Port)
+			}
+		}
+		if port := d.ToPort(); port != 0 {
+			target += fmt.Sprintf(":%d", port)
+		}
+		cmds = append(cmds, fmt.Sprintf("iptables -t nat -A %s %s -j DNAT --to-destination %s", chain, match, target))
+	}
+	for _, m := range o.rule.Mappings {
+		cmds = append(cmds, fmt.Sprintf("iptables -t nat -A %s -i %s -d %s -j NETMAP --to %s", chain, o.rule.OutInterface, m.Overlay, m.Local))
+	}
+	return append(cmds, jumpCmd("PREROUTING", chain))
+}
+
+// UpdateCmd adds and removes the rules of the prefixes that changed, and rebuilds the incoming chain if the ingress
+// rules changed. Both chains are rebuilt if anything else differs from previous.
+func (o *IptablesOperation) UpdateCmd(previous nat.SnatRule) string {
+	rule := o.rule
+	if previous.Chain != rule.Chain || previous.OutInterface != rule.OutInterface || toSource(previous.EgressIPs) != toSource(rule.EgressIPs) ||
+		!slices.Equal(previous.Mappings, rule.Mappings) {
+		return o.CreateCmd()
+	}
+	var dnatCmds []string
+	if !slices.Equal(previous.Ingress, rule.Ingress) {
+		dnatCmds = o.dnatCmds()
+	}
+	added, removed := cidr.Diff(previous.RemoteCIDRs, rule.RemoteCIDRs)
+	if len(added) == 0 && len(removed) == 0 {
+		return strings.Join(dnatCmds, ";")
+	}
+	// the chain and the jump are ensured, in case the gateway lost them
+	cmds := []string{fmt.Sprintf("iptables -t nat -N %s 2>/dev/null || true", rule.Chain)}
+	for _, remote := range removed {
+		for _, spec := range o.ruleSpecs(remote) {
+			cmds = append(cmds, fmt.Sprintf("iptables -t nat -D %s 2>/dev/null || true", spec))
+		}
+	}
+	for _, remote := range added {
+		for _, spec := range o.ruleSpecs(remote) {
+			cmds = append(cmds, fmt.Sprintf("iptables -t nat -C %s 2>/dev/null || iptables -t nat -A %s", spec, spec))
+		}
+	}
+	cmds = append(cmds, jumpCmd("POSTROUTING", rule.Chain))
+	return strings.Join(append(cmds, dnatCmds...), ";")
+}
+
+func (o *IptablesOperation) DeleteCmd() string {
+	return strings.Join(append(deleteChainCmds("POSTROUTING", o.rule.Chain), deleteChainCmds("PREROUTING", o.rule.Chain+nat.DnatChainSuffix)...), ";")
+}
+
+func deleteChainCmds(hook, chain string) []string {
+	return []string{
+		fmt.Sprintf("iptables -t nat -D %s -j %s 2>/dev/null || true", hook, chain),
+		fmt.Sprintf("iptables -t nat -F %s 2>/dev/null || true", chain),
+		fmt.Sprintf("iptables -t nat -X %s 2>/dev/null || true", chain),
+	}
+}
+
+// ruleSpecs are the SNAT rules of one remote prefix, without the -A/-C/-D command. Mapped subnets get a NETMAP
+// rule each. A SNAT target takes a single range, so egress IPs in several ranges get a rule each, picked at random
+// in proportion to its size. Only the first packet of a connection goes through the nat table, so a connection
+// keeps its address.
+func (o *IptablesOperation) ruleSpecs(remote string) []string {
+	match := fmt.Sprintf("%s -o %s -d %s", o.rule.Chain, o.rule.OutInterface, remote)
+	ranges, _ := nat.EgressRanges(o.rule.EgressIPs)
+	var specs []string
+	for _, m := range o.rule.Mappings {
+		specs = append(specs, fmt.Sprintf("%s -s %s -j NETMAP --to %s", match, m.Local, m.Overlay))
+	}
+	nat.SpreadRanges(ranges, func(r nat.IPRange, size, remaining uint64) {
+		spec := match
+		if size != remaining {
+			probability := strconv.FormatFloat(float64(size)/float64(remaining), 'f', -1, 64)
+			spec += " -m statistic --mode random --probability " + probability
+		}
+		specs = append(specs, spec+" -j SNAT --to-source "+r.String())
+	})
+	return specs
+}
+
+func jumpCmd(hook, chain string) string {
+	return fmt.Sprintf("iptables -t nat -C %s -j %s 2>/dev/null || iptables -t nat -A %s -j %s", hook, chain, hook, chain)
+}
+
+// toSource identifies the SNAT targets of the egress IPs
+func toSource(egressIPs []string) string {
+	ranges, _ := nat.EgressRanges(egressIPs)
+	return nat.RangesString(ranges)
+}
@@ -0,0 +1,129 @@
+package nftables
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"multi-vpc/internal/cidr"
+	"multi-vpc/internal/nat"
+)
+
+// Table is the nftables table owning every tunnel chain, kept apart from the tables of iptables-nft
+const Table = "multi-vpc"
+
+type NftablesOperation struct {
+	rule nat.SnatRule
+}
+
+func NewNftablesOp(rule nat.SnatRule) nat.SnatOperation {
+	return &NftablesOperation{
+		rule: rule,
+	}
+}
+
+// CreateCmd replaces the rules of the tunnel chain in a single nft transaction. The chain is a base chain
+// hooked at postrouting, so no jump rule has to be tracked.
+func (o *NftablesOperation) CreateCmd() string {
+	rule := o.rule
+	statements := []string{
+		fmt.Sprintf("add table ip %s", Table),
+		addChain(rule.Chain),
+		fmt.Sprintf("flush chain ip %s %s", Table, rule.Chain),
+	}
+	ranges, _ := nat.EgressRanges(rule.EgressIPs)
+	for _, remote := range rule.RemoteCIDRs {
+		match := fmt.Sprintf("add rule ip %s %s oifname \"%s\" ip daddr %s", Table, rule.Chain, rule.OutInterface, remote)
+		// like with iptables, each range of egress IPs gets a rule, picked at random in proportion to its size
+		nat.SpreadRanges(ranges, func(r nat.IPRange, size, remaining uint64) {
+			statement := match
+			if size != remaining {
+				statement += fmt.Sprintf(" numgen random mod %d < %d", remaining, size)
+			}
+			statements = append(statements, statement+" counter snat to "+r.String())
+		})
+		for _, m := range rule.Mappings {
+			statements = append(statements, fmt.Sprintf("%s ip saddr %s counter snat ip prefix to ip saddr map { %s : %s }", match, m.Local, m.Local, m.Overlay))
+		}
+	}
+	return transaction(append(statements, o.dnatStatements()...)...)
+}
+
+// dnatStatements rebuild the chain forwarding the ingress global IPs and translating the overlay ranges back, or
+// delete it if there is neither
+func (o *NftablesOperation) dnatStatements() []string {
+	chain := o.rule.Chain + nat.DnatChainSuffix
+	statements := []string{
+		addDnatChain(chain),
+		fmt.Sprintf("flush chain ip %s %s", Table, chain),
+	}
+	if len(o.rule.Mappings) == 0 && len(o.rule.Ingress) == 0 {
+		return append(statements, fmt.Sprintf("delete chain ip %s %s", Table, chain))
+	}
+	// the ingress rules go first, their global IPs may lie in an overlay range
+	for _, d := range o.rule.Ingress {
+		match := fmt.Sprintf("add rule ip %s %s iifname \"%s\" ip daddr %s", Table, chain, o.rule.OutInterface, d.GlobalIP)
+		target := d.InternalIP
+		switch {
+		case d.Port != 0:
+			match += fmt.Sprintf(" %s dport %d", d.Protocol, d.Port)
+		case d.Protocol != "":
+			match += " meta l4proto " + d.Protocol
+		}
+		if port := d.ToPort(); port != 0 {
+			target += fmt.Sprintf(":%d", port)
+		}
+		statements = append(statements, match+" counter dnat to "+target)
+	}
+	for _, m := range o.rule.Mappings {
+		statements = append(statements, fmt.Sprintf("add rule ip %s %s iifname \"%s\" ip daddr %s counter dnat ip prefix to ip daddr map { %s : %s }",
+			Table, chain, o.rule.OutInterface, m.Overlay, m.Overlay, m.Local))
+	}
+	return statements
+}
+
+// UpdateCmd rewrites the chain. nft deletes rules by handle only, and since the flush and the new rules are one
+// transaction, the prefixes kept are translated throughout.
+func (o *NftablesOperation) UpdateCmd(previous nat.SnatRule) string {
+	if previous.Chain == o.rule.Chain && previous.OutInterface == o.rule.OutInterface &&
+		cidr.Equal(previous.RemoteCIDRs, o.rule.RemoteCIDRs) && sameRanges(previous.EgressIPs, o.rule.EgressIPs) &&
+		slices.Equal(previous.Mappings, o.rule.Mappings) && slices.Equal(previous.Ingress, o.rule.Ingress) {
+		return ""
+	}
+	return o.CreateCmd()
+}
+
+// DeleteCmd declares the chain before deleting it, so the transaction does not fail on a chain that is gone
+func (o *NftablesOperation) DeleteCmd() string {
+	chain := o.rule.Chain
+	dnatChain := chain + nat.DnatChainSuffix
+	return transaction(
+		fmt.Sprintf("add table ip %s", Table),
+		addChain(chain),
+		fmt.Sprintf("flush chain ip %s %s", Table, chain),
+		fmt.Sprintf("delete chain ip %s %s", Table, chain),
+		addDnatChain(dnatChain),
+		fmt.Sprintf("flush chain ip %s %s", Table, dnatChain),
+		fmt.Sprintf("delete chain ip %s %s", Table, dnatChain),
+	)
+}
+
+func sameRanges(a, b []string) bool {
+	rangesA, _ := nat.EgressRanges(a)
+	rangesB, _ := nat.EgressRanges(b)
+	return nat.RangesString(rangesA) == nat.RangesString(rangesB)
+}
+
+func addChain(chain string) string {
+	return fmt.Sprintf("add chain ip %s %s { type nat hook postrouting priority 100; policy accept; }", Table, chain)
+}
+
+func addDnatChain(chain string) string {
+	return fmt.Sprintf("add chain ip %s %s { type nat hook prerouting priority -100; policy accept; }", Table, chain)
+}
+
+// transaction feeds the statements to nft -f, which applies all of them or none. The here-document ends the
+// command line, so a transaction has to be the last command of a script.
+func transaction(statements ...string) string {
+	return "nft -f - <<'EOF'\n" + strings.Join(statements, "\n") + "\nEOF\n"
+}
@@ -0,0 +1,103 @@
+package options
+
+import (
+	"flag"
+)
+
+// NatBackendAuto detects the NAT backend from the iptables variant of each gateway image
+const NatBackendAuto = "auto"
+
+// Options holds the names of the kube-ovn and Submariner objects the controllers work with.
+// The defaults match a stock kube-ovn and Submariner installation.
+type Options struct {
+	// KubeOvnNamespace is where kube-ovn runs the vpc-nat-gw StatefulSets and the vpc-dns Deployments
+	KubeOvnNamespace string
+	// NatGwPrefix is prepended to a gateway name to get its StatefulSet name and pod "app" label
+	NatGwPrefix string
+	// NatGwLabel marks the vpc-nat-gw StatefulSets and pods
+	NatGwLabel string
+	// NatGwContainer is the container of the gateway pod commands are run in
+	NatGwContainer string
+	// ExternalIPAnnotation holds the gateway pod's address on the external network attachment
+	ExternalIPAnnotation string
+	// ExternalNetworkNamespace is the namespace of the NetworkAttachmentDefinitions of the kube-ovn external subnets,
+	// for gateway pods that do not reference theirs
+	ExternalNetworkNamespace string
+	// GatewayIPAnnotation holds the gateway pod's address of the VPC subnet gateway
+	GatewayIPAnnotation string
+	// NatBackend is the backend of the tunnel SNAT rules: iptables, nftables or auto
+	NatBackend string
+
+	// SubmarinerNamespace is where the Submariner Gateway objects live
+	SubmarinerNamespace string
+	// ClusterGlobalEgressIP is the name of the cluster-wide Submariner ClusterGlobalEgressIP
+	ClusterGlobalEgressIP string
+	// GlobalnetCIDR is the globalnet CIDR the cluster joined the clusterset with. When empty, the global CIDR
+	// of the local Submariner Cluster is used.
+	GlobalnetCIDR string
+
+	// DnsServiceNamespace and DnsServiceName locate the cluster DNS service vpc-dns forwards to
+	DnsServiceNamespace string
+	DnsServiceName      string
+	// DefaultSubnet is the kube-ovn subnet vpc-dns reaches the cluster DNS through
+	DefaultSubnet string
+}
+
+// NewOptions returns the options for a default kube-ovn and Submariner installation
+func NewOptions() *Options {
+	return &Options{
+		KubeOvnNamespace:         "kube-system",
+		NatGwPrefix:              "vpc-nat-gw-",
+		NatGwLabel:               "ovn.kubernetes.io/vpc-nat-gw",
+		NatGwContainer:           "vpc-nat-gw",
+		ExternalIPAnnotation:     "ovn-vpc-external-network.kube-system.kubernetes.io/ip_address",
+		ExternalNetworkNamespace: "kube-system",
+		GatewayIPAnnotation:      "ovn.kubernetes.io/gateway",
+		NatBackend:               NatBackendAuto,
+		SubmarinerNamespace:      "submariner-operator",
+		ClusterGlobalEgressIP:    "cluster-egress.submariner.io",
+		DnsServiceNamespace:      "kube-system",
+		DnsServiceName:           "kube-dns",
+		DefaultSubnet:            "ovn-default",
+	}
+}
+
+// BindFlags registers the options on fs, keeping the current values as defaults
+func (o *Options) BindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.KubeOvnNamespace, "kube-ovn-namespace", o.KubeOvnNamespace,
+		"The namespace of the kube-ovn vpc-nat-gw and vpc-dns workloads.")
+	fs.StringVar(&o.NatGwPrefix, "vpc-nat-gw-prefix", o.NatGwPrefix,
+		"The prefix kube-ovn puts in front of a VpcNatGateway name to name its StatefulSet.")
+	fs.StringVar(&o.NatGwLabel, "vpc-nat-gw-label", o.NatGwLabel,
+		"The label set to \"true\" on vpc-nat-gw StatefulSets and pods.")
+	fs.StringVar(&o.NatGwContainer, "vpc-nat-gw-container", o.NatGwContainer,
+		"The container of the vpc-nat-gw pod tunnel commands are run in.")
+	fs.StringVar(&o.ExternalIPAnnotation, "external-ip-annotation", o.ExternalIPAnnotation,
+		"The vpc-nat-gw pod annotation holding its external network address, i.e. <nad name>.<nad namespace>.kubernetes.io/ip_address.")
+	fs.StringVar(&o.ExternalNetworkNamespace, "external-network-namespace", o.ExternalNetworkNamespace,
+		"The namespace of the NetworkAttachmentDefinitions of the kube-ovn external subnets, used for vpc-nat-gw pods "+
+			"that do not reference theirs in k8s.v1.cni.cncf.io/networks.")
+	fs.StringVar(&o.GatewayIPAnnotation, "gateway-ip-annotation", o.GatewayIPAnnotation,
+		"The vpc-nat-gw pod annotation holding the gateway address of its VPC subnet.")
+	fs.StringVar(&o.NatBackend, "nat-backend", o.NatBackend,
+		"The backend of the tunnel SNAT rules on the gateways: iptables, nftables or auto to detect it per gateway. "+
+			"The kubeovn.ustc.io/nat-backend annotation of a VpcNatGateway overrides it.")
+	fs.StringVar(&o.SubmarinerNamespace, "submariner-namespace", o.SubmarinerNamespace,
+		"The namespace of the Submariner Gateway objects.")
+	fs.StringVar(&o.ClusterGlobalEgressIP, "cluster-global-egress-ip", o.ClusterGlobalEgressIP,
+		"The name of the Submariner ClusterGlobalEgressIP used as SNAT source.")
+	fs.StringVar(&o.GlobalnetCIDR, "globalnet-cidr", o.GlobalnetCIDR,
+		"The globalnet CIDR of the local cluster, picked among the subnets of the active Submariner Gateway. "+
+			"Defaults to the global CIDR of the local Submariner Cluster.")
+	fs.StringVar(&o.DnsServiceNamespace, "dns-service-namespace", o.DnsServiceNamespace,
+		"The namespace of the cluster DNS service.")
+	fs.StringVar(&o.DnsServiceName, "dns-service-name", o.DnsServiceName,
+		"The name of the cluster DNS service vpc-dns forwards clusterset.local to.")
+	fs.StringVar(&o.DefaultSubnet, "default-subnet", o.DefaultSubnet,
+		"The kube-ovn subnet vpc-dns reaches the cluster DNS service through.")
+}
+
+// NatGwStsName returns the StatefulSet name of the gateway
+func (o *Options) NatGwStsName(natGw string) string {
+	return o.NatGwPrefix + natGw
+}
@@ -1,6 +1,8 @@
 package factory
 
 import (
+	"sort"
+
 	v1 "multi-vpc/api/v1"
 	"multi-vpc/internal/tunnel"
 	"multi-vpc/internal/tunnel/gre"
@@ -21,13 +23,31 @@ const (
 	GRE   = "gre"
 )
 
+// drivers holds the registered tunnel types and their operation constructors
+var drivers = map[string]func(*v1.VpcNatTunnel) tunnel.TunnelOperation{
+	VXLAN: vxlan.NewVxlanOp,
+	GRE:   gre.NewGreOp,
+}
+
+// IsRegistered reports whether tunnelType has a registered driver
+func IsRegistered(tunnelType string) bool {
+	_, ok := drivers[tunnelType]
+	return ok
+}
+
+// RegisteredTypes returns the names of all registered tunnel types
+func RegisteredTypes() []string {
+	types := make([]string, 0, len(drivers))
+	for t := range drivers {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
 func (f *TunnelOperationFactory) CreateTunnelOperation(tunnel *v1.VpcNatTunnel) tunnel.TunnelOperation {
-	switch tunnel.Spec.Type {
-	case "vxlan":
-		return vxlan.NewVxlanOp(tunnel)
-	case "gre":
-		return gre.NewGreOp(tunnel)
-	default:
-		return gre.NewGreOp(tunnel)
+	if newOp, ok := drivers[tunnel.Spec.Type]; ok {
+		return newOp(tunnel)
 	}
+	return gre.NewGreOp(tunnel)
 }
@@ -16,16 +16,19 @@ func NewGreOp(tunnel *v1.VpcNatTunnel) tunnel.TunnelOperation {
 	}
 }
 
+// CreateCmd creates the interface, replacing one left by an attempt whose outcome was not recorded
 func (g *GreOperation) CreateCmd() string {
+	addr := tunnel.InterfaceAddr(g.tunnel)
 	tunnel := g.tunnel
 
-	createCmd := fmt.Sprintf("ip tunnel add %s mode gre remote %s local %s ttl 255", tunnel.Name, tunnel.Spec.RemoteIP, tunnel.Status.InternalIP)
-	setUpCmd := fmt.Sprintf("ip link set %s up", tunnel.Name)
-	addrCmd := fmt.Sprintf("ip addr add %s dev %s", tunnel.Spec.InterfaceAddr, tunnel.Name)
-	return createCmd + ";" + setUpCmd + ";" + addrCmd
+	cleanCmd := g.DeleteCmd() + " 2>/dev/null || true"
+	createCmd := fmt.Sprintf("ip tunnel add %s mode gre remote %s local %s ttl 255", tunnel.Status.InterfaceName, tunnel.Spec.RemoteIP, tunnel.Status.InternalIP)
+	setUpCmd := fmt.Sprintf("ip link set %s up", tunnel.Status.InterfaceName)
+	addrCmd := fmt.Sprintf("ip addr add %s dev %s", addr, tunnel.Status.InterfaceName)
+	return cleanCmd + ";" + createCmd + ";" + setUpCmd + ";" + addrCmd
 }
 
 func (g *GreOperation) DeleteCmd() string {
-	delCmd := fmt.Sprintf("ip tunnel del %s", g.tunnel.Name)
+	delCmd := fmt.Sprintf("ip tunnel del %s", g.tunnel.Status.InterfaceName)
 	return delCmd
 }
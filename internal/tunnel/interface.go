@@ -1,6 +1,19 @@
 package tunnel
 
+import (
+	v1 "multi-vpc/api/v1"
+)
+
 type TunnelOperation interface {
 	CreateCmd() string
 	DeleteCmd() string
 }
+
+// InterfaceAddr returns the address of the tunnel interface, the one allocated from its InterfaceAddrPool unless
+// the spec sets one
+func InterfaceAddr(t *v1.VpcNatTunnel) string {
+	if t.Spec.InterfaceAddr != "" {
+		return t.Spec.InterfaceAddr
+	}
+	return t.Status.AllocatedInterfaceAddr
+}
@@ -0,0 +1,44 @@
+package tunnel
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+)
+
+const (
+	// InterfacePrefix marks the kernel interfaces created for VpcNatTunnels
+	InterfacePrefix = "mvpc-"
+	// ChainPrefix marks the iptables chains created for VpcNatTunnels
+	ChainPrefix = "MVPC-"
+	// maxInterfaceNameLen is IFNAMSIZ without the trailing NUL
+	maxInterfaceNameLen = 15
+	// minRouteTable and maxRouteTable bound the routing tables of tunnels, clear of the tables reserved by
+	// iproute2 and of small IDs picked by hand
+	minRouteTable = 0x10000
+	maxRouteTable = 0x7fffffff
+)
+
+// GenInterfaceName returns a deterministic kernel interface name for the tunnel namespace/name.
+// Tunnel names may exceed IFNAMSIZ and collide across namespaces, so a hash of both is used instead.
+func GenInterfaceName(namespace, name string) string {
+	return InterfacePrefix + hashName(namespace, name)[:maxInterfaceNameLen-len(InterfacePrefix)]
+}
+
+// GenChainName returns the nat chain holding the rules of the tunnel namespace/name.
+// It shares the hash of the interface name, so both are easy to match in iptables-save.
+func GenChainName(namespace, name string) string {
+	return ChainPrefix + hashName(namespace, name)[:maxInterfaceNameLen-len(InterfacePrefix)]
+}
+
+// GenRouteTable returns the policy routing table of the tunnel namespace/name. Collisions between tunnels
+// on one gateway are unlikely but possible, and are reported as conflicts before provisioning.
+func GenRouteTable(namespace, name string) int {
+	sum := sha256.Sum256([]byte(namespace + "/" + name))
+	return minRouteTable + int(binary.BigEndian.Uint32(sum[:4])%(maxRouteTable-minRouteTable))
+}
+
+func hashName(namespace, name string) string {
+	sum := sha256.Sum256([]byte(namespace + "/" + name))
+	return hex.EncodeToString(sum[:])
+}
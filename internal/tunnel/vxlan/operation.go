@@ -9,6 +9,10 @@ import (
 const (
 	DefaultVid  string = "100"
 	DefaultPort string = "4789"
+
+	// VidLabel and PortLabel set the VNI and UDP port of a vxlan tunnel
+	VidLabel  = "vid"
+	PortLabel = "vx-port"
 )
 
 type VxlanOperation struct {
@@ -21,28 +25,32 @@ func NewVxlanOp(tunnel *v1.VpcNatTunnel) tunnel.TunnelOperation {
 	}
 }
 
+// CreateCmd creates the interface, replacing one left by an attempt whose outcome was not recorded
 func (v *VxlanOperation) CreateCmd() string {
+	addr := tunnel.InterfaceAddr(v.tunnel)
 	tunnel := v.tunnel
-	vid, port := getVidAndPort(tunnel)
+	vid, port := GetVidAndPort(tunnel)
 
-	createCmd := fmt.Sprintf("ip link add %s type vxlan id %s dev net1 dstport %s remote %s local %s", tunnel.Name, vid, port, tunnel.Spec.RemoteIP, tunnel.Status.InternalIP)
-	setUpCmd := fmt.Sprintf("ip link set %s up", tunnel.Name)
-	addrCmd := fmt.Sprintf("ip addr add %s dev %s", tunnel.Spec.InterfaceAddr, tunnel.Name)
-	return createCmd + ";" + setUpCmd + ";" + addrCmd
+	cleanCmd := v.DeleteCmd() + " 2>/dev/null || true"
+	createCmd := fmt.Sprintf("ip link add %s type vxlan id %s dev net1 dstport %s remote %s local %s", tunnel.Status.InterfaceName, vid, port, tunnel.Spec.RemoteIP, tunnel.Status.InternalIP)
+	setUpCmd := fmt.Sprintf("ip link set %s up", tunnel.Status.InterfaceName)
+	addrCmd := fmt.Sprintf("ip addr add %s dev %s", addr, tunnel.Status.InterfaceName)
+	return cleanCmd + ";" + createCmd + ";" + setUpCmd + ";" + addrCmd
 }
 
 func (v *VxlanOperation) DeleteCmd() string {
-	delCmd := fmt.Sprintf("ip link del %s", v.tunnel.Name)
+	delCmd := fmt.Sprintf("ip link del %s", v.tunnel.Status.InterfaceName)
 	return delCmd
 }
 
-func getVidAndPort(t *v1.VpcNatTunnel) (string, string) {
+// GetVidAndPort returns the VNI and UDP port of a vxlan tunnel, taken from its "vid" and "vx-port" labels
+func GetVidAndPort(t *v1.VpcNatTunnel) (string, string) {
 	retVid := DefaultVid
 	retPort := DefaultPort
-	if vid, ok := t.Labels["vid"]; ok {
+	if vid, ok := t.Labels[VidLabel]; ok {
 		retVid = vid
 	}
-	if port, ok := t.Labels["vx-port"]; ok {
+	if port, ok := t.Labels[PortLabel]; ok {
 		retPort = port
 	}
 	return retVid, retPort
@@ -0,0 +1,305 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	ovn "github.com/kubeovn/kube-ovn/pkg/apis/kubeovn/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	kubeovnv1 "multi-vpc/api/v1"
+	"multi-vpc/internal/tunnel/factory"
+	"multi-vpc/internal/tunnel/vxlan"
+)
+
+// log is for logging in this package.
+var vpcnattunnellog = logf.Log.WithName("vpcnattunnel-resource")
+
+// VpcNatTunnelCustomValidator validates VpcNatTunnel objects before they reach the controller,
+// so that malformed addresses are never pasted into commands run in the gateway pod.
+type VpcNatTunnelCustomValidator struct {
+	Client client.Client
+}
+
+// SetupWebhookWithManager registers the VpcNatTunnel webhook with the manager.
+func (v *VpcNatTunnelCustomValidator) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	if v.Client == nil {
+		v.Client = mgr.GetClient()
+	}
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&kubeovnv1.VpcNatTunnel{}).
+		WithValidator(v).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-kubeovn-ustc-io-v1-vpcnattunnel,mutating=false,failurePolicy=fail,sideEffects=None,groups=kubeovn.ustc.io,resources=vpcnattunnels,verbs=create;update,versions=v1,name=vvpcnattunnel.kb.io,admissionReviewVersions=v1
+
+var _ webhook.CustomValidator = &VpcNatTunnelCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *VpcNatTunnelCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	tunnel, ok := obj.(*kubeovnv1.VpcNatTunnel)
+	if !ok {
+		return nil, fmt.Errorf("expected a VpcNatTunnel object but got %T", obj)
+	}
+	vpcnattunnellog.Info("validate create", "name", tunnel.Name)
+
+	allErrs := validateSpec(&tunnel.Spec)
+	allErrs = append(allErrs, validateVxlanLabels(tunnel)...)
+	allErrs = append(allErrs, v.validateNatGw(ctx, &tunnel.Spec)...)
+	return nil, toInvalid(tunnel, allErrs)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *VpcNatTunnelCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	oldTunnel, ok := oldObj.(*kubeovnv1.VpcNatTunnel)
+	if !ok {
+		return nil, fmt.Errorf("expected a VpcNatTunnel object but got %T", oldObj)
+	}
+	tunnel, ok := newObj.(*kubeovnv1.VpcNatTunnel)
+	if !ok {
+		return nil, fmt.Errorf("expected a VpcNatTunnel object but got %T", newObj)
+	}
+	vpcnattunnellog.Info("validate update", "name", tunnel.Name)
+
+	// never block finalizer removal on a tunnel that is being deleted
+	if !tunnel.DeletionTimestamp.IsZero() {
+		return nil, nil
+	}
+
+	allErrs := validateSpec(&tunnel.Spec)
+	allErrs = append(allErrs, validateVxlanLabels(tunnel)...)
+	// the VNI and port are only read when the interface is created
+	if oldTunnel.Status.Initialized && tunnel.Spec.Type == factory.VXLAN {
+		for _, label := range []string{vxlan.VidLabel, vxlan.PortLabel} {
+			if oldTunnel.Labels[label] != tunnel.Labels[label] {
+				allErrs = append(allErrs, field.Forbidden(field.NewPath("metadata", "labels").Key(label), "cannot be changed once the tunnel is provisioned, delete and recreate the tunnel instead"))
+			}
+		}
+	}
+	// the tunnel interface is created with a fixed driver, it has to be recreated to change it
+	if oldTunnel.Spec.Type != tunnel.Spec.Type {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "type"), "tunnel type cannot be changed, delete and recreate the tunnel instead"))
+	}
+	// only look up the gateway when it changes, so a vanished gateway does not make the object read-only
+	if oldTunnel.Spec.NatGwDp != tunnel.Spec.NatGwDp || oldTunnel.Spec.Vpc != tunnel.Spec.Vpc {
+		allErrs = append(allErrs, v.validateNatGw(ctx, &tunnel.Spec)...)
+	}
+	return nil, toInvalid(tunnel, allErrs)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *VpcNatTunnelCustomValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func validateSpec(spec *kubeovnv1.VpcNatTunnelSpec) field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if net.ParseIP(spec.RemoteIP) == nil {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("remoteIp"), spec.RemoteIP, "must be a valid IP address"))
+	}
+	// with a pool the controller allocates the interface address
+	switch {
+	case spec.InterfaceAddrPool != "" && spec.InterfaceAddr != "":
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("interfaceAddr"), "cannot be set together with interfaceAddrPool"))
+	case spec.InterfaceAddrPool == "":
+		if _, _, err := net.ParseCIDR(spec.InterfaceAddr); err != nil {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("interfaceAddr"), spec.InterfaceAddr, "must be an address in CIDR notation, e.g. 10.0.0.1/24"))
+		}
+	}
+	// with a remote cluster ID the controller resolves the remote globalnet CIDR
+	if spec.RemoteClusterID == "" && spec.RemoteGlobalnetCIDR == "" && len(spec.RemoteCIDRs) == 0 {
+		allErrs = append(allErrs, field.Required(specPath.Child("remoteCIDRs"), "remoteGlobalnetCIDR or remoteCIDRs must be set"))
+	}
+	if spec.RemoteClusterID != "" && spec.RemoteGlobalnetCIDR != "" {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("remoteGlobalnetCIDR"), "cannot be set together with remoteClusterID"))
+	}
+	if spec.RemoteGlobalnetCIDR != "" {
+		if _, _, err := net.ParseCIDR(spec.RemoteGlobalnetCIDR); err != nil {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("remoteGlobalnetCIDR"), spec.RemoteGlobalnetCIDR, "must be a valid CIDR"))
+		}
+	}
+	allErrs = append(allErrs, validateCIDRs(specPath.Child("remoteCIDRs"), spec.RemoteCIDRs)...)
+	allErrs = append(allErrs, validateCIDRs(specPath.Child("sourceCIDRs"), spec.SourceCIDRs)...)
+	allErrs = append(allErrs, validateOverlayMappings(specPath, spec)...)
+	allErrs = append(allErrs, validateIngress(specPath.Child("ingress"), spec.Ingress)...)
+	if spec.GlobalIPPool != "" && spec.Mode != "" && spec.Mode != kubeovnv1.ModeGlobalnet {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("globalIPPool"), "only allowed in globalnet mode"))
+	}
+	allErrs = append(allErrs, validateEgress(specPath, spec)...)
+	if spec.NatGwDp == "" {
+		allErrs = append(allErrs, field.Required(specPath.Child("natGwDp"), "must name a vpc-nat-gw gateway"))
+	}
+	if spec.Type != "" && !factory.IsRegistered(spec.Type) {
+		allErrs = append(allErrs, field.NotSupported(specPath.Child("type"), spec.Type, factory.RegisteredTypes()))
+	}
+	return allErrs
+}
+
+// validateVxlanLabels checks the VNI and port labels of a vxlan tunnel, which are pasted into the command creating
+// its interface
+func validateVxlanLabels(tunnel *kubeovnv1.VpcNatTunnel) field.ErrorList {
+	if tunnel.Spec.Type != factory.VXLAN {
+		return nil
+	}
+	var allErrs field.ErrorList
+	labelsPath := field.NewPath("metadata", "labels")
+	for _, label := range []struct {
+		key string
+		max int
+	}{{vxlan.VidLabel, 1<<24 - 1}, {vxlan.PortLabel, 65535}} {
+		value, ok := tunnel.Labels[label.key]
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(value); err != nil || n < 1 || n > label.max {
+			allErrs = append(allErrs, field.Invalid(labelsPath.Key(label.key), value, fmt.Sprintf("must be an integer between 1 and %d", label.max)))
+		}
+	}
+	return allErrs
+}
+
+func validateCIDRs(fldPath *field.Path, cidrs []string) field.ErrorList {
+	var allErrs field.ErrorList
+	for i, cidr := range cidrs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i), cidr, "must be a valid CIDR"))
+		}
+	}
+	return allErrs
+}
+
+// validateEgress checks that the SNAT sources of the tunnel are IPv4 addresses from a single source, in globalnet mode
+func validateEgress(specPath *field.Path, spec *kubeovnv1.VpcNatTunnelSpec) field.ErrorList {
+	var allErrs field.ErrorList
+	if len(spec.EgressIPs) == 0 && spec.GlobalEgressIP == "" {
+		return nil
+	}
+	if spec.Mode != "" && spec.Mode != kubeovnv1.ModeGlobalnet {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("egressIPs"), "only allowed in globalnet mode"))
+	}
+	if len(spec.EgressIPs) != 0 && spec.GlobalEgressIP != "" {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("globalEgressIP"), "cannot be set together with egressIPs"))
+	}
+	for i, egressIP := range spec.EgressIPs {
+		if ip := net.ParseIP(egressIP); ip == nil || ip.To4() == nil {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("egressIPs").Index(i), egressIP, "must be an IPv4 address"))
+		}
+	}
+	return allErrs
+}
+
+// validateIngress checks that every ingress rule has a global IP or a GlobalIngressIP to take it from, and only
+// matches ports of a protocol
+func validateIngress(fldPath *field.Path, ingress []kubeovnv1.IngressRule) field.ErrorList {
+	var allErrs field.ErrorList
+	for i, rule := range ingress {
+		rulePath := fldPath.Index(i)
+		if ip := net.ParseIP(rule.InternalIP); ip == nil || ip.To4() == nil {
+			allErrs = append(allErrs, field.Invalid(rulePath.Child("internalIP"), rule.InternalIP, "must be an IPv4 address"))
+		}
+		globalIP := net.ParseIP(rule.GlobalIP)
+		switch {
+		case rule.GlobalIP == "" && rule.GlobalIngressIP == "":
+			allErrs = append(allErrs, field.Required(rulePath.Child("globalIP"), "globalIP or globalIngressIP must be set"))
+		case rule.GlobalIP != "" && (globalIP == nil || globalIP.To4() == nil):
+			allErrs = append(allErrs, field.Invalid(rulePath.Child("globalIP"), rule.GlobalIP, "must be an IPv4 address"))
+		}
+		if rule.Port != 0 && rule.Protocol == "" {
+			allErrs = append(allErrs, field.Required(rulePath.Child("protocol"), "must be set with port"))
+		}
+		if rule.TargetPort != 0 && rule.Port == 0 {
+			allErrs = append(allErrs, field.Required(rulePath.Child("port"), "must be set with targetPort"))
+		}
+	}
+	return allErrs
+}
+
+// validateOverlayMappings checks that overlay mode maps at least one subnet, each to a range of the same size,
+// and that no other mode sets mappings
+func validateOverlayMappings(specPath *field.Path, spec *kubeovnv1.VpcNatTunnelSpec) field.ErrorList {
+	var allErrs field.ErrorList
+	fldPath := specPath.Child("overlayMappings")
+	if spec.Mode != kubeovnv1.ModeOverlay {
+		if len(spec.OverlayMappings) != 0 {
+			allErrs = append(allErrs, field.Forbidden(fldPath, "only allowed in overlay mode"))
+		}
+		return allErrs
+	}
+	if len(spec.OverlayMappings) == 0 {
+		allErrs = append(allErrs, field.Required(fldPath, "overlay mode needs at least one mapping"))
+	}
+	for i, m := range spec.OverlayMappings {
+		_, local, err := net.ParseCIDR(m.LocalCIDR)
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i).Child("localCIDR"), m.LocalCIDR, "must be a valid CIDR"))
+		}
+		_, overlay, err2 := net.ParseCIDR(m.OverlayCIDR)
+		if err2 != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i).Child("overlayCIDR"), m.OverlayCIDR, "must be a valid CIDR"))
+		}
+		if err != nil || err2 != nil {
+			continue
+		}
+		localOnes, _ := local.Mask.Size()
+		overlayOnes, _ := overlay.Mask.Size()
+		if localOnes != overlayOnes {
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i).Child("overlayCIDR"), m.OverlayCIDR, "must have the prefix length of localCIDR"))
+		}
+	}
+	return allErrs
+}
+
+// validateNatGw checks that the kube-ovn VpcNatGateway referenced by the tunnel exists and serves its VPC
+func (v *VpcNatTunnelCustomValidator) validateNatGw(ctx context.Context, spec *kubeovnv1.VpcNatTunnelSpec) field.ErrorList {
+	if spec.NatGwDp == "" {
+		return nil
+	}
+	natGwPath := field.NewPath("spec", "natGwDp")
+	gw := &ovn.VpcNatGateway{}
+	err := v.Client.Get(ctx, client.ObjectKey{Name: spec.NatGwDp}, gw)
+	switch {
+	case k8serrors.IsNotFound(err):
+		return field.ErrorList{field.NotFound(natGwPath, spec.NatGwDp)}
+	case err != nil:
+		return field.ErrorList{field.InternalError(natGwPath, err)}
+	}
+	if spec.Vpc != "" && spec.Vpc != gw.Spec.Vpc {
+		return field.ErrorList{field.Invalid(field.NewPath("spec", "vpc"), spec.Vpc, fmt.Sprintf("gateway %s belongs to vpc %s", gw.Name, gw.Spec.Vpc))}
+	}
+	return nil
+}
+
+func toInvalid(tunnel *kubeovnv1.VpcNatTunnel, allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return k8serrors.NewInvalid(kubeovnv1.GroupVersion.WithKind("VpcNatTunnel").GroupKind(), tunnel.Name, allErrs)
+}
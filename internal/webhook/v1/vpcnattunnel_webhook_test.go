@@ -0,0 +1,269 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+
+	ovn "github.com/kubeovn/kube-ovn/pkg/apis/kubeovn/v1"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	kubeovnv1 "multi-vpc/api/v1"
+)
+
+var _ = Describe("VpcNatTunnel Webhook", func() {
+	var (
+		ctx       context.Context
+		validator *VpcNatTunnelCustomValidator
+		tunnel    *kubeovnv1.VpcNatTunnel
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(kubeovnv1.AddToScheme(scheme)).To(Succeed())
+		Expect(ovn.AddToScheme(scheme)).To(Succeed())
+		gw := &ovn.VpcNatGateway{ObjectMeta: metav1.ObjectMeta{Name: "gw1"}, Spec: ovn.VpcNatSpec{Vpc: "vpc1"}}
+		validator = &VpcNatTunnelCustomValidator{
+			Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(gw).Build(),
+		}
+		tunnel = &kubeovnv1.VpcNatTunnel{
+			ObjectMeta: metav1.ObjectMeta{Name: "ovn-gre0", Namespace: "ns1"},
+			Spec: kubeovnv1.VpcNatTunnelSpec{
+				RemoteIP:            "10.10.0.21",
+				InterfaceAddr:       "10.100.0.1/24",
+				NatGwDp:             "gw1",
+				Type:                "gre",
+				RemoteGlobalnetCIDR: "242.0.0.0/16",
+			},
+		}
+	})
+
+	Context("When creating a VpcNatTunnel", func() {
+		It("should admit a valid tunnel", func() {
+			_, err := validator.ValidateCreate(ctx, tunnel)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should reject malformed addresses", func() {
+			tunnel.Spec.RemoteIP = "10.10.0.21; reboot"
+			tunnel.Spec.InterfaceAddr = "10.100.0.1"
+			tunnel.Spec.RemoteGlobalnetCIDR = "242.0.0.0/33"
+			tunnel.Spec.RemoteCIDRs = []string{"10.96.0.0/12", "10.97.0.0"}
+			tunnel.Spec.SourceCIDRs = []string{"10.0.1.0"}
+			_, err := validator.ValidateCreate(ctx, tunnel)
+			Expect(k8serrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.remoteIp"))
+			Expect(err.Error()).To(ContainSubstring("spec.interfaceAddr"))
+			Expect(err.Error()).To(ContainSubstring("spec.remoteGlobalnetCIDR"))
+			Expect(err.Error()).To(ContainSubstring("spec.remoteCIDRs[1]"))
+			Expect(err.Error()).To(ContainSubstring("spec.sourceCIDRs[0]"))
+		})
+
+		It("should require a remote prefix", func() {
+			tunnel.Spec.RemoteGlobalnetCIDR = ""
+			_, err := validator.ValidateCreate(ctx, tunnel)
+			Expect(k8serrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.remoteCIDRs"))
+
+			tunnel.Spec.RemoteCIDRs = []string{"10.96.0.0/12"}
+			_, err = validator.ValidateCreate(ctx, tunnel)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should leave the remote globalnet CIDR, but not the remote IP, to a remote cluster ID", func() {
+			tunnel.Spec.RemoteGlobalnetCIDR = ""
+			_, err := validator.ValidateCreate(ctx, tunnel)
+			Expect(k8serrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.remoteCIDRs"))
+
+			tunnel.Spec.RemoteClusterID = "cluster2"
+			_, err = validator.ValidateCreate(ctx, tunnel)
+			Expect(err).NotTo(HaveOccurred())
+
+			tunnel.Spec.RemoteGlobalnetCIDR = "242.1.0.0/16"
+			_, err = validator.ValidateCreate(ctx, tunnel)
+			Expect(err).To(MatchError(ContainSubstring("spec.remoteGlobalnetCIDR")))
+
+			tunnel.Spec.RemoteGlobalnetCIDR = ""
+			tunnel.Spec.RemoteIP = ""
+			_, err = validator.ValidateCreate(ctx, tunnel)
+			Expect(err).To(MatchError(ContainSubstring("spec.remoteIp")))
+		})
+
+		It("should leave the interface address to a pool", func() {
+			tunnel.Spec.InterfaceAddr = ""
+			_, err := validator.ValidateCreate(ctx, tunnel)
+			Expect(k8serrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.interfaceAddr"))
+
+			tunnel.Spec.InterfaceAddrPool = "p2p"
+			_, err = validator.ValidateCreate(ctx, tunnel)
+			Expect(err).NotTo(HaveOccurred())
+
+			tunnel.Spec.InterfaceAddr = "10.100.0.1/30"
+			_, err = validator.ValidateCreate(ctx, tunnel)
+			Expect(err).To(MatchError(ContainSubstring("spec.interfaceAddr")))
+		})
+
+		It("should check the overlay mappings", func() {
+			tunnel.Spec.Mode = kubeovnv1.ModeOverlay
+			_, err := validator.ValidateCreate(ctx, tunnel)
+			Expect(err).To(MatchError(ContainSubstring("spec.overlayMappings")))
+
+			tunnel.Spec.OverlayMappings = []kubeovnv1.OverlayMapping{
+				{LocalCIDR: "10.0.1.0/24", OverlayCIDR: "172.31.1.0/24"},
+				{LocalCIDR: "10.0.2.0/24", OverlayCIDR: "172.31.2.0/23"},
+				{LocalCIDR: "10.0.3.0", OverlayCIDR: "172.31.3.0/24"},
+			}
+			_, err = validator.ValidateCreate(ctx, tunnel)
+			Expect(k8serrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.overlayMappings[1].overlayCIDR"))
+			Expect(err.Error()).To(ContainSubstring("spec.overlayMappings[2].localCIDR"))
+			Expect(err.Error()).NotTo(ContainSubstring("spec.overlayMappings[0]"))
+
+			tunnel.Spec.OverlayMappings = tunnel.Spec.OverlayMappings[:1]
+			_, err = validator.ValidateCreate(ctx, tunnel)
+			Expect(err).NotTo(HaveOccurred())
+
+			tunnel.Spec.Mode = kubeovnv1.ModeSubnet
+			_, err = validator.ValidateCreate(ctx, tunnel)
+			Expect(err).To(MatchError(ContainSubstring("only allowed in overlay mode")))
+		})
+
+		It("should check the ingress rules", func() {
+			tunnel.Spec.Ingress = []kubeovnv1.IngressRule{
+				{GlobalIngressIP: "web", InternalIP: "10.0.1.10", Protocol: "tcp", Port: 80},
+				{GlobalIP: "242.0.0.20", InternalIP: "10.0.1.11"},
+			}
+			_, err := validator.ValidateCreate(ctx, tunnel)
+			Expect(err).NotTo(HaveOccurred())
+
+			tunnel.Spec.Ingress = []kubeovnv1.IngressRule{
+				{InternalIP: "10.0.1.10"},
+				{GlobalIP: "242.0.0.300", InternalIP: "fd00::10", Port: 80},
+				{GlobalIP: "242.0.0.21", InternalIP: "10.0.1.12", TargetPort: 8080},
+			}
+			_, err = validator.ValidateCreate(ctx, tunnel)
+			Expect(k8serrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.ingress[0].globalIP"))
+			Expect(err.Error()).To(ContainSubstring("spec.ingress[1].globalIP"))
+			Expect(err.Error()).To(ContainSubstring("spec.ingress[1].internalIP"))
+			Expect(err.Error()).To(ContainSubstring("spec.ingress[1].protocol"))
+			Expect(err.Error()).To(ContainSubstring("spec.ingress[2].port"))
+		})
+
+		It("should take egress IPs from a single source", func() {
+			tunnel.Spec.EgressIPs = []string{"242.0.0.30", "242.0.0.31"}
+			_, err := validator.ValidateCreate(ctx, tunnel)
+			Expect(err).NotTo(HaveOccurred())
+
+			tunnel.Spec.EgressIPs = []string{"242.0.0.30", "242.0.0"}
+			tunnel.Spec.GlobalEgressIP = "tenant1"
+			_, err = validator.ValidateCreate(ctx, tunnel)
+			Expect(k8serrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("spec.egressIPs[1]"))
+			Expect(err.Error()).To(ContainSubstring("spec.globalEgressIP"))
+
+			tunnel.Spec.EgressIPs = nil
+			tunnel.Spec.Mode = kubeovnv1.ModeSubnet
+			_, err = validator.ValidateCreate(ctx, tunnel)
+			Expect(err).To(MatchError(ContainSubstring("only allowed in globalnet mode")))
+		})
+
+		It("should only take a global IP pool in globalnet mode", func() {
+			tunnel.Spec.GlobalIPPool = "pool1"
+			_, err := validator.ValidateCreate(ctx, tunnel)
+			Expect(err).NotTo(HaveOccurred())
+
+			tunnel.Spec.Mode = kubeovnv1.ModeSubnet
+			_, err = validator.ValidateCreate(ctx, tunnel)
+			Expect(err).To(MatchError(ContainSubstring("spec.globalIPPool")))
+		})
+
+		It("should reject an unknown tunnel type", func() {
+			tunnel.Spec.Type = "ipip"
+			_, err := validator.ValidateCreate(ctx, tunnel)
+			Expect(err).To(MatchError(ContainSubstring("spec.type")))
+		})
+
+		It("should check the vxlan VNI and port labels", func() {
+			tunnel.Spec.Type = "vxlan"
+			tunnel.Labels = map[string]string{"vid": "16777215", "vx-port": "4789"}
+			_, err := validator.ValidateCreate(ctx, tunnel)
+			Expect(err).NotTo(HaveOccurred())
+
+			tunnel.Labels = map[string]string{"vid": "16777216", "vx-port": "4789;reboot"}
+			_, err = validator.ValidateCreate(ctx, tunnel)
+			Expect(k8serrors.IsInvalid(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("metadata.labels[vid]"))
+			Expect(err.Error()).To(ContainSubstring("metadata.labels[vx-port]"))
+		})
+
+		It("should reject a missing gateway", func() {
+			tunnel.Spec.NatGwDp = "gw2"
+			_, err := validator.ValidateCreate(ctx, tunnel)
+			Expect(err).To(MatchError(ContainSubstring("spec.natGwDp")))
+		})
+
+		It("should reject a gateway of another vpc", func() {
+			tunnel.Spec.Vpc = "vpc1"
+			_, err := validator.ValidateCreate(ctx, tunnel)
+			Expect(err).NotTo(HaveOccurred())
+
+			tunnel.Spec.Vpc = "vpc2"
+			_, err = validator.ValidateCreate(ctx, tunnel)
+			Expect(err).To(MatchError(ContainSubstring("spec.vpc")))
+		})
+	})
+
+	Context("When updating a VpcNatTunnel", func() {
+		It("should reject a type change", func() {
+			updated := tunnel.DeepCopy()
+			updated.Spec.Type = "vxlan"
+			_, err := validator.ValidateUpdate(ctx, tunnel, updated)
+			Expect(err).To(MatchError(ContainSubstring("spec.type")))
+		})
+
+		It("should reject vxlan label changes once the tunnel is provisioned", func() {
+			tunnel.Spec.Type = "vxlan"
+			tunnel.Labels = map[string]string{"vid": "100"}
+			updated := tunnel.DeepCopy()
+			updated.Labels["vid"] = "200"
+			_, err := validator.ValidateUpdate(ctx, tunnel, updated)
+			Expect(err).NotTo(HaveOccurred())
+
+			tunnel.Status.Initialized = true
+			_, err = validator.ValidateUpdate(ctx, tunnel, updated)
+			Expect(err).To(MatchError(ContainSubstring("metadata.labels[vid]")))
+		})
+
+		It("should admit live changes", func() {
+			updated := tunnel.DeepCopy()
+			updated.Spec.RemoteIP = "10.10.0.22"
+			_, err := validator.ValidateUpdate(ctx, tunnel, updated)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+})